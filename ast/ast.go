@@ -54,18 +54,67 @@ func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type LetStatement struct {
-	Token       token.Token // the 'let' token
+	Token       token.Token // the 'let' token, or the name for plain `x = v;`
 	Name        *Identifier
 	Value       Expression
 	Type        string
-	GenericType string // e.g. "T" from generic<T>
+	TypeParams  []*TypeParam // K, V from generic<K, V>
+	// Declare is set for `let`, typed (`x: int = v`) and generic
+	// declarations, which bind a new local instead of assigning to the
+	// nearest existing variable.
+	Declare bool
+}
+
+// TypeParam is one parameter of a generic declaration: T in generic<T>,
+// or T: Comparable, which only accepts types satisfying Comparable.
+type TypeParam struct {
+	Name       string
+	Constraint string // "" when unconstrained
+}
+
+func (tp *TypeParam) String() string {
+	if tp.Constraint != "" {
+		return tp.Name + ": " + tp.Constraint
+	}
+	return tp.Name
+}
+
+// TypeExpr is a type written in a type argument or annotation: int,
+// Point, []int, or an instance of a generic such as Pair<int, string>.
+type TypeExpr struct {
+	Token token.Token // the type name, or '[' of an array type
+	Name  string      // "[]" for an array type
+	Args  []*TypeExpr // type arguments, or the element type of an array
+}
+
+func (te *TypeExpr) String() string {
+	if te.Name == "[]" {
+		return "[]" + te.Args[0].String()
+	}
+	if len(te.Args) == 0 {
+		return te.Name
+	}
+	return te.Name + "<" + TypeArgsString(te.Args) + ">"
+}
+
+// TypeArgsString joins type arguments as they are written between < and >.
+func TypeArgsString(args []*TypeExpr) string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = arg.String()
+	}
+	return strings.Join(out, ", ")
 }
 
 type FunctionLiteral struct {
 	Token         token.Token // the 'fn' token
-	GenericType   string      // e.g. "T"
+	TypeParams    []*TypeParam
 	Parameters    []*Identifier // Parameters will remain identifiers 
+	ParamTypes    []*TypeExpr   // annotation of each parameter, nil if none
+	Defaults      []Expression  // default value of each parameter, nil if none
+	Rest          *Identifier   // ...rest collects the remaining arguments, nil if none
 	Body          *BlockStatement
+	Generator     bool // the body yields, so calls return a generator
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -75,12 +124,18 @@ func (fl *FunctionLiteral) String() string {
 
 	out.WriteString("fn(")
 
+	params := []string{}
 	for i, p := range fl.Parameters {
-		out.WriteString(p.String())
-		if i < len(fl.Parameters)-1 {
-			out.WriteString(", ")
+		param := p.String()
+		if i < len(fl.Defaults) && fl.Defaults[i] != nil {
+			param += " = " + fl.Defaults[i].String()
 		}
+		params = append(params, param)
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
 	}
+	out.WriteString(strings.Join(params, ", "))
 
 	out.WriteString(") ")
 	out.WriteString(fl.Body.String())
@@ -88,10 +143,21 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// SpreadExpression passes the elements of an array as separate arguments:
+// f(...args).
+type SpreadExpression struct {
+	Token token.Token // the '...' token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) String() string       { return "..." + se.Value.String() }
+
 type CallExpression struct {
 	Token     token.Token // the '(' token
 	Function  Expression  // Identifier or FunctionLiteral
-	TypeArg   string      // e.g. "int" from func<int>(...)
+	TypeArgs  []*TypeExpr // int, string from func<int, string>(...)
 	Arguments []Expression
 }
 
@@ -101,6 +167,9 @@ func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ce.Function.String())
+	if len(ce.TypeArgs) > 0 {
+		out.WriteString("<" + TypeArgsString(ce.TypeArgs) + ">")
+	}
 	out.WriteString("(")
 
 	for i, arg := range ce.Arguments {
@@ -120,6 +189,9 @@ func (ls *LetStatement) TokenLiteral() string { return ls.Name.TokenLiteral() }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
+	if ls.Token.Type == token.LET {
+		out.WriteString("let ")
+	}
 	out.WriteString(ls.Name.String())
 	if ls.Type != "" {
 		out.WriteString(":" + ls.Type)
@@ -204,6 +276,15 @@ func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -213,6 +294,29 @@ func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
+// TemplateLiteral is a string with interpolations, "Hello ${name}". Parts
+// alternates freely between *StringLiteral text and the expressions.
+type TemplateLiteral struct {
+	Token token.Token // the TEMPLATE token
+	Parts []Expression
+}
+
+func (tl *TemplateLiteral) expressionNode()      {}
+func (tl *TemplateLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TemplateLiteral) String() string {
+	var out bytes.Buffer
+	out.WriteString("\"")
+	for _, part := range tl.Parts {
+		if text, ok := part.(*StringLiteral); ok {
+			out.WriteString(text.Value)
+			continue
+		}
+		out.WriteString("${" + part.String() + "}")
+	}
+	out.WriteString("\"")
+	return out.String()
+}
+
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, e.g. !
 	Operator string
@@ -308,6 +412,36 @@ func (ls *LoadStatement) String() string {
 	return "load " + ls.File.String() + ";"
 }
 
+// ImportStatement binds a module to Alias; without `as` the alias is the
+// last element of the path.
+type ImportStatement struct {
+	Token token.Token // the 'import' token
+	Path  *StringLiteral
+	Alias *Identifier
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) String() string {
+	out := "import \"" + is.Path.Value + "\""
+	if is.Alias != nil {
+		out += " as " + is.Alias.String()
+	}
+	return out + ";"
+}
+
+// DeleteStatement frees a struct allocated with new.
+type DeleteStatement struct {
+	Token token.Token // the 'delete' token
+	Value Expression
+}
+
+func (ds *DeleteStatement) statementNode()       {}
+func (ds *DeleteStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DeleteStatement) String() string {
+	return "delete " + ds.Value.String() + ";"
+}
+
 type ReturnStatement struct {
 	Token       token.Token // the 'return' token
 	ReturnValue Expression
@@ -327,6 +461,19 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// YieldStatement hands a value to the caller of a generator and pauses it
+// until the next value is asked for.
+type YieldStatement struct {
+	Token token.Token // the 'yield' token
+	Value Expression
+}
+
+func (ys *YieldStatement) statementNode()       {}
+func (ys *YieldStatement) TokenLiteral() string { return ys.Token.Literal }
+func (ys *YieldStatement) String() string {
+	return "yield " + ys.Value.String() + ";"
+}
+
 type BreakStatement struct {
 	Token token.Token
 }
@@ -343,6 +490,99 @@ func (cs *ContinueStatement) statementNode()       {}
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
 func (cs *ContinueStatement) String() string       { return "continue;" }
 
+// TryStatement is try { } catch (e) { } finally { }; either the catch or
+// the finally part may be left out, and the catch parameter is optional.
+type TryStatement struct {
+	Token      token.Token // the 'try' token
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(ts.Block.String())
+	if ts.Catch != nil {
+		out.WriteString(" catch ")
+		if ts.CatchParam != nil {
+			out.WriteString("(" + ts.CatchParam.String() + ") ")
+		}
+		out.WriteString(ts.Catch.String())
+	}
+	if ts.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(ts.Finally.String())
+	}
+
+	return out.String()
+}
+
+// SelectStatement waits on several channel operations and runs the case
+// of the first that can go ahead, or Default when there is one and none
+// can go ahead right away.
+type SelectStatement struct {
+	Token   token.Token // the 'select' token
+	Cases   []*SelectCase
+	Default *BlockStatement
+}
+
+func (ss *SelectStatement) statementNode()       {}
+func (ss *SelectStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *SelectStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("select { ")
+	for _, c := range ss.Cases {
+		out.WriteString(c.String() + " ")
+	}
+	if ss.Default != nil {
+		out.WriteString("default: " + ss.Default.String() + " ")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+// SelectCase is case v = recv(ch): or case send(ch, x): in a select. Name
+// is nil when the received value is not bound; Value is nil for a receive.
+type SelectCase struct {
+	Token   token.Token // the 'case' token
+	Name    *Identifier
+	Send    bool
+	Channel Expression
+	Value   Expression
+	Body    *BlockStatement
+}
+
+func (sc *SelectCase) String() string {
+	var out bytes.Buffer
+	out.WriteString("case ")
+	if sc.Send {
+		out.WriteString("send(" + sc.Channel.String() + ", " + sc.Value.String() + ")")
+	} else {
+		if sc.Name != nil {
+			out.WriteString(sc.Name.String() + " = ")
+		}
+		out.WriteString("recv(" + sc.Channel.String() + ")")
+	}
+	out.WriteString(": " + sc.Body.String())
+	return out.String()
+}
+
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	return "throw " + ts.Value.String() + ";"
+}
+
 type IndexExpression struct {
 	Left  Expression
 	Index Expression
@@ -412,8 +652,9 @@ func (hl *HashLiteral) String() string {
 
 type StructLiteral struct {
 	Token       token.Token // the 'struct' token
-	GenericType string      // e.g. "T"
+	TypeParams  []*TypeParam
 	Pairs       map[string]Expression
+	Embeds      []Expression // structs embedded with ...Base
 }
 
 func (sl *StructLiteral) expressionNode()      {}
@@ -422,6 +663,13 @@ func (sl *StructLiteral) String() string {
 	var out strings.Builder
 	out.WriteString("struct{")
 	i := 0
+	for _, e := range sl.Embeds {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString("..." + e.String())
+		i++
+	}
 	for k, v := range sl.Pairs {
 		if i > 0 {
 			out.WriteString(", ")
@@ -435,6 +683,195 @@ func (sl *StructLiteral) String() string {
 	return out.String()
 }
 
+// InterfaceLiteral lists the methods, and their parameters, that a struct
+// needs to implement the interface.
+type InterfaceLiteral struct {
+	Token   token.Token // the 'interface' token
+	Name    string      // set when the interface is assigned to a name
+	Methods []*InterfaceMethod
+	Embeds  []Expression // interfaces included with ...Other
+}
+
+type InterfaceMethod struct {
+	Name       *Identifier
+	Parameters []*Identifier
+}
+
+func (il *InterfaceLiteral) expressionNode()      {}
+func (il *InterfaceLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *InterfaceLiteral) String() string {
+	var out strings.Builder
+	out.WriteString("interface{")
+	for i, e := range il.Embeds {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString("..." + e.String())
+	}
+	for i, m := range il.Methods {
+		if i > 0 || len(il.Embeds) > 0 {
+			out.WriteString(", ")
+		}
+		params := make([]string, len(m.Parameters))
+		for j, param := range m.Parameters {
+			params[j] = param.String()
+		}
+		out.WriteString(m.Name.String() + "(" + strings.Join(params, ", ") + ")")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+// EnumStatement declares an enum, `enum Color { Red, Green }`, whose
+// variants may carry values: `enum Result { Ok(value), Err(msg) }`.
+type EnumStatement struct {
+	Token    token.Token // the 'enum' token
+	Name     *Identifier
+	Variants []*EnumVariant
+}
+
+type EnumVariant struct {
+	Name   *Identifier
+	Fields []*Identifier // nil for a variant without parentheses
+}
+
+func (es *EnumStatement) statementNode()       {}
+func (es *EnumStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *EnumStatement) String() string {
+	variants := make([]string, len(es.Variants))
+	for i, v := range es.Variants {
+		variants[i] = v.Name.String()
+		if v.Fields != nil {
+			fields := make([]string, len(v.Fields))
+			for j, f := range v.Fields {
+				fields[j] = f.String()
+			}
+			variants[i] += "(" + strings.Join(fields, ", ") + ")"
+		}
+	}
+	return "enum " + es.Name.String() + " { " + strings.Join(variants, ", ") + " }"
+}
+
+// MatchExpression evaluates the body of the first arm whose pattern matches
+// the subject and whose guard, if any, holds.
+type MatchExpression struct {
+	Token   token.Token // the 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+// MatchArm is one `pattern if guard => body` entry. An arm written with an
+// expression body holds it as the only statement of Body.
+type MatchArm struct {
+	Pattern Expression
+	Guard   Expression // nil without `if`
+	Body    *BlockStatement
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out strings.Builder
+	out.WriteString("match " + me.Subject.String() + " {")
+	for i, arm := range me.Arms {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(arm.Pattern.String())
+		if arm.Guard != nil {
+			out.WriteString(" if " + arm.Guard.String())
+		}
+		out.WriteString(" => " + arm.Body.String())
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+// ArrayPattern matches arrays element by element. Without a rest the
+// lengths must agree; `...rest` takes the remaining elements.
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []Expression
+	Rest     *Identifier // nil when there is no `...rest`
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	parts := make([]string, 0, len(ap.Elements)+1)
+	for _, el := range ap.Elements {
+		parts = append(parts, el.String())
+	}
+	if ap.Rest != nil {
+		parts = append(parts, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// HashPattern matches hashes that have every listed key with a matching
+// value. Keys are literals; other keys of the hash are ignored.
+type HashPattern struct {
+	Token  token.Token // the '{' token
+	Keys   []Expression
+	Values []Expression
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+	parts := make([]string, len(hp.Keys))
+	for i, key := range hp.Keys {
+		parts[i] = key.String() + ": " + hp.Values[i].String()
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// StructPattern matches structs, or pointers to them, by field. Type, when
+// given, must be the name the instance was declared with.
+type StructPattern struct {
+	Token  token.Token // the type name, or '{'
+	Type   string
+	Fields []string
+	Values []Expression
+}
+
+func (sp *StructPattern) expressionNode()      {}
+func (sp *StructPattern) TokenLiteral() string { return sp.Token.Literal }
+func (sp *StructPattern) String() string {
+	parts := make([]string, len(sp.Fields))
+	for i, name := range sp.Fields {
+		parts[i] = name + ": " + sp.Values[i].String()
+	}
+	out := "{" + strings.Join(parts, ", ") + "}"
+	if sp.Type != "" {
+		out = sp.Type + " " + out
+	}
+	return out
+}
+
+// VariantPattern matches a value of an enum variant, Color.Red, and the
+// values the variant carries, Result.Ok(v).
+type VariantPattern struct {
+	Token   token.Token // the enum name
+	Enum    string
+	Variant string
+	Args    []Expression // nil for a pattern written without parentheses
+}
+
+func (vp *VariantPattern) expressionNode()      {}
+func (vp *VariantPattern) TokenLiteral() string { return vp.Token.Literal }
+func (vp *VariantPattern) String() string {
+	out := vp.Enum + "." + vp.Variant
+	if vp.Args != nil {
+		args := make([]string, len(vp.Args))
+		for i, arg := range vp.Args {
+			args[i] = arg.String()
+		}
+		out += "(" + strings.Join(args, ", ") + ")"
+	}
+	return out
+}
+
 type PropertyAccessExpression struct {
 	Token    token.Token // The . or -> token
 	Object   Expression
@@ -482,6 +919,38 @@ func (fs *ForStatement) String() string {
 	return out.String()
 }
 
+// ForInStatement is for (v in xs) or for (k, v in xs). Key is nil in the
+// one-variable form.
+type ForInStatement struct {
+	Token    token.Token // The 'for' token
+	Key      *Identifier
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) expressionNode()      {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) String() string {
+	var out strings.Builder
+	out.WriteString("for(")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String() + ", ")
+	}
+	out.WriteString(fs.Value.String() + " in " + fs.Iterable.String() + ") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
+// Names returns the loop variables in the order an iterator produces
+// them: the key first, when there is one.
+func (fs *ForInStatement) Names() []*Identifier {
+	if fs.Key != nil {
+		return []*Identifier{fs.Key, fs.Value}
+	}
+	return []*Identifier{fs.Value}
+}
+
 type AssignExpression struct {
 	Token token.Token // "="
 	Left  Expression
@@ -498,10 +967,11 @@ func (ae *AssignExpression) String() string {
 type VarDeclaration struct {
 	Token       token.Token // The 'IDENT' token of the type
 	Type        string
-	GenericType string // e.g. "T"
+	TypeArgs    []*TypeExpr // int, string from Pair<int, string>
 	IsPointer   bool
 	Name        *Identifier
 	Value       Expression
+	Arguments   []Expression // constructor arguments of `Type name(a, b);`
 }
 
 func (vd *VarDeclaration) statementNode()       {}
@@ -509,12 +979,25 @@ func (vd *VarDeclaration) TokenLiteral() string { return vd.Token.Literal }
 func (vd *VarDeclaration) String() string {
 	var out strings.Builder
 	out.WriteString(vd.Type)
+	if len(vd.TypeArgs) > 0 {
+		out.WriteString("<" + TypeArgsString(vd.TypeArgs) + ">")
+	}
 	if vd.IsPointer {
 		out.WriteString("*")
 	}
 	out.WriteString(" ")
 	out.WriteString(vd.Name.String())
 
+	if vd.Arguments != nil {
+		out.WriteString("(")
+		for i, arg := range vd.Arguments {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(arg.String())
+		}
+		out.WriteString(")")
+	}
 	if vd.Value != nil {
 		out.WriteString(" = ")
 		out.WriteString(vd.Value.String())
@@ -527,7 +1010,7 @@ func (vd *VarDeclaration) String() string {
 type NewExpression struct {
 	Token       token.Token // The 'new' token
 	Class       string
-	GenericType string      // e.g. "int"
+	TypeArgs    []*TypeExpr // int from new Box<int>()
 	Arguments   []Expression
 }
 
@@ -537,6 +1020,9 @@ func (ne *NewExpression) String() string {
 	var out strings.Builder
 	out.WriteString("new ")
 	out.WriteString(ne.Class)
+	if len(ne.TypeArgs) > 0 {
+		out.WriteString("<" + TypeArgsString(ne.TypeArgs) + ">")
+	}
 	out.WriteString("()")
 	return out.String()
 }
@@ -547,27 +1033,44 @@ func (n *Program) GetToken() token.Token { if len(n.Statements) > 0 { return n.S
 func (n *Boolean) GetToken() token.Token { return n.Token }
 func (n *LetStatement) GetToken() token.Token { return n.Token }
 func (n *FunctionLiteral) GetToken() token.Token { return n.Token }
+func (n *SpreadExpression) GetToken() token.Token { return n.Token }
 func (n *CallExpression) GetToken() token.Token { return n.Token }
 func (n *PrintStatement) GetToken() token.Token { return n.Token }
 func (n *ExpressionStatement) GetToken() token.Token { return n.Token }
 func (n *BlockStatement) GetToken() token.Token { return n.Token }
 func (n *Identifier) GetToken() token.Token { return n.Token }
 func (n *IntegerLiteral) GetToken() token.Token { return n.Token }
+func (n *FloatLiteral) GetToken() token.Token { return n.Token }
 func (n *StringLiteral) GetToken() token.Token { return n.Token }
+func (n *TemplateLiteral) GetToken() token.Token { return n.Token }
 func (n *PrefixExpression) GetToken() token.Token { return n.Token }
 func (n *BinaryExpression) GetToken() token.Token { return n.Token }
 func (n *IfStatement) GetToken() token.Token { return n.Token }
 func (n *WhileStatement) GetToken() token.Token { return n.Token }
 func (n *LoadStatement) GetToken() token.Token { return n.Token }
+func (n *ImportStatement) GetToken() token.Token { return n.Token }
+func (n *DeleteStatement) GetToken() token.Token { return n.Token }
 func (n *ReturnStatement) GetToken() token.Token { return n.Token }
 func (n *BreakStatement) GetToken() token.Token { return n.Token }
+func (n *YieldStatement) GetToken() token.Token { return n.Token }
 func (n *ContinueStatement) GetToken() token.Token { return n.Token }
+func (n *TryStatement) GetToken() token.Token { return n.Token }
+func (n *SelectStatement) GetToken() token.Token { return n.Token }
+func (n *ThrowStatement) GetToken() token.Token { return n.Token }
 func (n *IndexExpression) GetToken() token.Token { return n.Left.GetToken() }
 func (n *ArrayLiteral) GetToken() token.Token { return n.Token }
 func (n *HashLiteral) GetToken() token.Token { return n.Token }
 func (n *StructLiteral) GetToken() token.Token { return n.Token }
+func (n *InterfaceLiteral) GetToken() token.Token { return n.Token }
+func (n *MatchExpression) GetToken() token.Token { return n.Token }
+func (n *ArrayPattern) GetToken() token.Token { return n.Token }
+func (n *HashPattern) GetToken() token.Token { return n.Token }
+func (n *StructPattern) GetToken() token.Token { return n.Token }
+func (n *VariantPattern) GetToken() token.Token { return n.Token }
+func (n *EnumStatement) GetToken() token.Token { return n.Token }
 func (n *PropertyAccessExpression) GetToken() token.Token { return n.Token }
 func (n *ForStatement) GetToken() token.Token { return n.Token }
+func (n *ForInStatement) GetToken() token.Token { return n.Token }
 func (n *AssignExpression) GetToken() token.Token { return n.Token }
 func (n *VarDeclaration) GetToken() token.Token { return n.Token }
 func (n *NewExpression) GetToken() token.Token { return n.Token }
@@ -0,0 +1,291 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpDup
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpShl
+	OpShr
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpGreater
+	OpLessEqual
+	OpGreaterEqual
+
+	OpMinus
+	OpBang
+	OpTruthy
+	OpInc
+
+	OpJump
+	OpJumpNotTruthy
+	OpJumpIfBound
+
+	OpIter
+	OpIterNext
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpSetFree
+	OpLocalCell
+	OpFreeCell
+
+	OpArray
+	OpTemplate
+	OpHash
+	OpStruct
+	OpEmbed
+	OpInterface
+	OpIndex
+	OpSetIndex
+	OpGetProperty
+	OpSetProperty
+
+	OpClosure
+	OpCall
+	OpCallSpread
+	OpReturnValue
+	OpSelf
+
+	OpMatch
+	OpNoMatch
+
+	OpImport
+	OpModule
+
+	OpTry
+	OpEndTry
+	OpThrow
+
+	OpPrint
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpShl:          {"OpShl", []int{}},
+	OpShr:          {"OpShr", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLess:         {"OpLess", []int{}},
+	OpGreater:      {"OpGreater", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+	OpTruthy: {"OpTruthy", []int{}},
+	OpInc:    {"OpInc", []int{}},
+
+	// jump targets are absolute offsets; 4 bytes so large loaded modules fit
+	OpJump:          {"OpJump", []int{4}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{4}},
+	// local index, then the jump target taken when the local is set; skips
+	// the default of a parameter the caller passed
+	OpJumpIfBound: {"OpJumpIfBound", []int{1, 4}},
+
+	// replaces the collection on top with an iterator over it
+	OpIter: {"OpIter", []int{}},
+	// number of loop variables, then the jump target taken when the
+	// iterator on top is exhausted; otherwise pushes the variables' values
+	OpIterNext: {"OpIterNext", []int{1, 4}},
+
+	OpGetGlobal:  {"OpGetGlobal", []int{2}},
+	OpSetGlobal:  {"OpSetGlobal", []int{2}},
+	OpGetLocal:   {"OpGetLocal", []int{1}},
+	OpSetLocal:   {"OpSetLocal", []int{1}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
+	OpGetFree:    {"OpGetFree", []int{1}},
+	OpSetFree:    {"OpSetFree", []int{1}},
+	OpLocalCell:  {"OpLocalCell", []int{1}},
+	OpFreeCell:   {"OpFreeCell", []int{1}},
+
+	// joins that many values into the string of a template literal
+	OpTemplate: {"OpTemplate", []int{2}},
+
+	OpArray:  {"OpArray", []int{2}},
+	OpHash:   {"OpHash", []int{2}},
+	OpStruct: {"OpStruct", []int{2}},
+	// name constant of the embedded struct, which is on top of the struct
+	OpEmbed: {"OpEmbed", []int{2}},
+	// interface constant, then number of embedded interfaces on the stack
+	OpInterface: {"OpInterface", []int{2, 1}},
+	OpIndex:  {"OpIndex", []int{}},
+	// leaves the value, then true with the new string on top for string
+	// targets (which the compiler stores back) or false otherwise
+	OpSetIndex: {"OpSetIndex", []int{}},
+	// property name constant, then 1 for -> access or 0 for .
+	OpGetProperty: {"OpGetProperty", []int{2, 1}},
+	OpSetProperty: {"OpSetProperty", []int{2, 1}},
+
+	// function constant, then number of captured cells on the stack
+	OpClosure:     {"OpClosure", []int{2, 1}},
+	OpCall:        {"OpCall", []int{1}},
+	// number of arrays on the stack whose elements are the arguments
+	OpCallSpread:  {"OpCallSpread", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	// the receiver the running closure was read from, for self and this
+	OpSelf: {"OpSelf", []int{}},
+
+	// pattern constant; leaves the subject, then pushes the bound values
+	// and true if it matches, or false
+	OpMatch: {"OpMatch", []int{2}},
+	// pops the subject no arm matched and raises
+	OpNoMatch: {"OpNoMatch", []int{}},
+
+	// module index; OpModule builds the module from the running frame
+	OpImport: {"OpImport", []int{2}},
+	OpModule: {"OpModule", []int{2}},
+
+	// absolute address of the handler, which starts with the exception
+	// pushed onto the stack
+	OpTry:    {"OpTry", []int{4}},
+	OpEndTry: {"OpEndTry", []int{}},
+	OpThrow:  {"OpThrow", []int{}},
+
+	OpPrint: {"OpPrint", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes an instruction; operands are big-endian.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of def from ins and reports how many
+// bytes they took.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint32(ins Instructions) uint32 { return binary.BigEndian.Uint32(ins) }
+func ReadUint16(ins Instructions) uint16 { return binary.BigEndian.Uint16(ins) }
+func ReadUint8(ins Instructions) uint8   { return uint8(ins[0]) }
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), len(def.OperandWidths))
+	}
+
+	switch len(operands) {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operand count for %s\n", def.Name)
+}
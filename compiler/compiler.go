@@ -0,0 +1,1295 @@
+// Package compiler lowers an ast.Program to bytecode for the vm package.
+//
+// Scoping follows the tree-walking evaluator: `let` declares a name in the
+// current function (or for loop), plain assignment updates the nearest
+// enclosing binding and only declares a new local when none exists, and
+// reads walk outwards. Because the evaluator resolves names at run time, the
+// names a program or function body assigns are declared up front, so a
+// function can update a global that is first assigned further down the
+// file. Names that cannot be resolved at all are treated as globals and
+// checked when the VM reads them.
+package compiler
+
+import (
+	"Nikium/ast"
+	"Nikium/evaluator"
+	"Nikium/lexer"
+	"Nikium/parser"
+	"Nikium/token"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Builtins are the native functions reachable through OpGetBuiltin, in
+// operand order.
+var Builtins = evaluator.Builtins()
+
+type Bytecode struct {
+	Instructions Instructions
+	Constants    []evaluator.Object
+	GlobalNames  []string
+	Positions    []Position
+	Modules      []*CompiledModule
+}
+
+type CompilationScope struct {
+	instructions Instructions
+	positions    []Position
+}
+
+type loopScope struct {
+	breaks    []int
+	continues []int
+	tries     int // active try handlers when the loop was entered
+}
+
+// tryScope is a try (or catch) body whose handler is active; leaving it
+// early with return, break or continue must pop the handler and run the
+// finally block on the way out.
+type tryScope struct {
+	finally *ast.BlockStatement
+}
+
+type Compiler struct {
+	constants []evaluator.Object
+	intConsts map[int64]int
+	strConsts map[string]int
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	loops []*loopScope
+	tries []*tryScope
+	tok   token.Token
+
+	dir         string // directory of the file being compiled
+	modules     []*CompiledModule
+	moduleIndex map[string]int
+	importing   []string // files being compiled, innermost last
+}
+
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, b := range Builtins {
+		symbolTable.DefineBuiltin(i, b.Name)
+	}
+
+	return &Compiler{
+		constants:   []evaluator.Object{},
+		intConsts:   make(map[int64]int),
+		strConsts:   make(map[string]int),
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{{}},
+		moduleIndex: make(map[string]int),
+	}
+}
+
+// SetDir records the directory of the script being compiled, so imports
+// and loads resolve relative to it.
+func (c *Compiler) SetDir(dir string) {
+	c.dir = dir
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		GlobalNames:  c.symbolTable.Root().Names(),
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Modules:      c.modules,
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	if node == nil {
+		return c.errorf("cannot compile an incomplete expression")
+	}
+	prevTok := c.tok
+	c.tok = node.GetToken()
+	defer func() { c.tok = prevTok }()
+
+	switch node := node.(type) {
+
+	case *ast.Program:
+		c.hoistAssignments(node.Statements)
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if node.Expression == nil {
+			return nil
+		}
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		if node.Declare {
+			return c.compileDeclaration(node.Name.Value, node.Value)
+		}
+		return c.compileAssignment(node.Name.Value, node.Value, false)
+
+	case *ast.VarDeclaration:
+		if node.Value != nil {
+			return c.compileAssignment(node.Name.Value, node.Value, false)
+		}
+		if node.IsPointer || node.Type == "p" || node.Type == "string" || node.Type == "int" || node.Type == "float" {
+			c.emit(OpNull)
+			c.storeSymbol(c.assignTarget(node.Name.Value))
+			return nil
+		}
+		return c.unsupported("stack struct declaration", node)
+
+	case *ast.AssignExpression:
+		if left, ok := node.Left.(*ast.Identifier); ok {
+			return c.compileAssignment(left.Value, node.Value, true)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		return c.compileStore(node.Left)
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			c.emit(OpNull)
+		} else if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.unwindTries(0); err != nil {
+			return err
+		}
+		c.emit(OpReturnValue)
+
+	case *ast.PrintStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(OpPrint)
+
+	case *ast.BreakStatement:
+		if len(c.loops) == 0 {
+			return c.errorf("break outside of a loop")
+		}
+		loop := c.loops[len(c.loops)-1]
+		if err := c.unwindTries(loop.tries); err != nil {
+			return err
+		}
+		loop.breaks = append(loop.breaks, c.emit(OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		if len(c.loops) == 0 {
+			return c.errorf("continue outside of a loop")
+		}
+		loop := c.loops[len(c.loops)-1]
+		if err := c.unwindTries(loop.tries); err != nil {
+			return err
+		}
+		loop.continues = append(loop.continues, c.emit(OpJump, 9999))
+
+	case *ast.LoadStatement:
+		return c.compileLoad(node)
+
+	case *ast.ImportStatement:
+		return c.compileImport(node)
+
+	case *ast.TryStatement:
+		return c.compileTry(node)
+
+	case *ast.SelectStatement:
+		return c.unsupported("select", node)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(OpThrow)
+
+	case *ast.IntegerLiteral:
+		c.emit(OpConstant, c.addInteger(node.Value))
+
+	case *ast.FloatLiteral:
+		c.emit(OpConstant, c.addConstant(&evaluator.Float{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		c.emit(OpConstant, c.addString(node.Value))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(OpTrue)
+		} else {
+			c.emit(OpFalse)
+		}
+
+	case *ast.Identifier:
+		if node.Value == "self" || node.Value == "this" {
+			if _, ok := c.symbolTable.Resolve(node.Value); !ok {
+				c.emit(OpSelf)
+				return nil
+			}
+		}
+		symbol := c.resolve(node.Value)
+		if symbol.Scope == BuiltinScope && taskBuiltins[node.Value] {
+			return c.unsupported(node.Value, node)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.PrefixExpression:
+		return c.compilePrefix(node)
+
+	case *ast.BinaryExpression:
+		return c.compileBinary(node)
+
+	case *ast.IfStatement:
+		return c.compileIf(node)
+
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+
+	case *ast.WhileStatement:
+		return c.compileWhile(node)
+
+	case *ast.ForStatement:
+		return c.compileFor(node)
+
+	case *ast.ForInStatement:
+		return c.compileForIn(node)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(OpArray, len(node.Elements))
+
+	case *ast.TemplateLiteral:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(OpTemplate, len(node.Parts))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// map order is random; sort so the bytecode is deterministic
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(OpHash, len(node.Pairs)*2)
+
+	case *ast.StructLiteral:
+		keys := []string{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			c.emit(OpConstant, c.addString(k))
+			value := node.Pairs[k]
+			// fields typed with a generic parameter start out unset
+			if ident, ok := value.(*ast.Identifier); ok && evaluator.IsTypeParam(node.TypeParams, ident.Value) {
+				c.emit(OpNull)
+				continue
+			}
+			// primitive type names start the field at its zero value
+			if ident, ok := value.(*ast.Identifier); ok {
+				if zero := evaluator.TypeZeroValue(ident.Value); zero != nil {
+					c.emit(OpConstant, c.addConstant(zero))
+					continue
+				}
+			}
+			if err := c.Compile(value); err != nil {
+				return err
+			}
+		}
+		c.emit(OpStruct, len(node.Pairs)*2)
+		for _, embed := range node.Embeds {
+			if err := c.Compile(embed); err != nil {
+				return err
+			}
+			c.emit(OpEmbed, c.addString(evaluator.EmbedName(embed)))
+		}
+
+	case *ast.EnumStatement:
+		c.emit(OpConstant, c.addConstant(evaluator.NewEnumType(node)))
+		c.storeSymbol(c.symbolTable.Define(node.Name.Value))
+
+	case *ast.InterfaceLiteral:
+		for _, embed := range node.Embeds {
+			if err := c.Compile(embed); err != nil {
+				return err
+			}
+		}
+		c.emit(OpInterface, c.addConstant(evaluator.NewInterface(node)), len(node.Embeds))
+
+	case *ast.PropertyAccessExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		c.emit(OpGetProperty, c.addString(node.Property.Value), arrowOperand(node))
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(OpIndex)
+
+	case *ast.FunctionLiteral:
+		if node.Generator {
+			return c.unsupported("yield", node)
+		}
+		return c.compileFunction(node)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		if hasSpread(node.Arguments) {
+			return c.compileSpreadCall(node)
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		if len(node.Arguments) > 255 {
+			return c.errorf("too many arguments in call to %s", node.Function.String())
+		}
+		c.emit(OpCall, len(node.Arguments))
+
+	case *ast.NewExpression:
+		return c.unsupported("new", node)
+
+	case *ast.DeleteStatement:
+		return c.unsupported("delete", node)
+
+	default:
+		return c.errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+// compileAssignment stores value under name. As an expression the assigned
+// value is left on the stack.
+func (c *Compiler) compileAssignment(name string, value ast.Expression, isExpr bool) error {
+	var symbol Symbol
+	// define functions before compiling them so they can call themselves
+	if _, isFn := value.(*ast.FunctionLiteral); isFn {
+		symbol = c.assignTarget(name)
+	}
+	if err := c.Compile(value); err != nil {
+		return err
+	}
+	if symbol.Name == "" {
+		symbol = c.assignTarget(name)
+	}
+	if isExpr {
+		c.emit(OpDup)
+	}
+	c.storeSymbol(symbol)
+	return nil
+}
+
+// compileDeclaration binds value to a new name in the current scope.
+func (c *Compiler) compileDeclaration(name string, value ast.Expression) error {
+	if _, isFn := value.(*ast.FunctionLiteral); isFn {
+		// declare first so the function can call itself
+		symbol := c.symbolTable.Define(name)
+		if err := c.Compile(value); err != nil {
+			return err
+		}
+		c.storeSymbol(symbol)
+		return nil
+	}
+	// the value is compiled first so `let x = x + 1` reads the outer x
+	if err := c.Compile(value); err != nil {
+		return err
+	}
+	c.storeSymbol(c.symbolTable.Define(name))
+	return nil
+}
+
+// hoistAssignments declares the names that stmts assign without `let` and
+// that no enclosing scope knows yet, mirroring the evaluator, which looks
+// the target up when the assignment runs rather than where it is written.
+// For loop bodies and nested functions have their own scopes and are left
+// alone.
+func (c *Compiler) hoistAssignments(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			if !stmt.Declare {
+				c.hoistName(stmt.Name.Value)
+			}
+		case *ast.ImportStatement:
+			c.hoistName(importName(stmt))
+		case *ast.BlockStatement:
+			c.hoistAssignments(stmt.Statements)
+		case *ast.TryStatement:
+			// the catch block gets its own scope, like the evaluator's
+			c.hoistAssignments(stmt.Block.Statements)
+			if stmt.Finally != nil {
+				c.hoistAssignments(stmt.Finally.Statements)
+			}
+		case *ast.ExpressionStatement:
+			switch expr := stmt.Expression.(type) {
+			case *ast.AssignExpression:
+				if ident, ok := expr.Left.(*ast.Identifier); ok {
+					c.hoistName(ident.Value)
+				}
+			case *ast.IfStatement:
+				c.hoistAssignments(expr.Consequence.Statements)
+				if expr.Alternative != nil {
+					c.hoistAssignments(expr.Alternative.Statements)
+				}
+			case *ast.WhileStatement:
+				c.hoistAssignments(expr.Body.Statements)
+			}
+		}
+	}
+}
+
+func (c *Compiler) hoistName(name string) {
+	if symbol, ok := c.symbolTable.Resolve(name); ok && symbol.Scope != BuiltinScope {
+		return
+	}
+	c.symbolTable.Define(name)
+}
+
+// compileStore writes the value on top of the stack into target and leaves
+// the value in its place.
+func (c *Compiler) compileStore(target ast.Expression) error {
+	switch target := target.(type) {
+	case *ast.Identifier:
+		c.emit(OpDup)
+		c.storeSymbol(c.assignTarget(target.Value))
+	case *ast.PropertyAccessExpression:
+		if err := c.Compile(target.Object); err != nil {
+			return err
+		}
+		c.emit(OpSetProperty, c.addString(target.Property.Value), arrowOperand(target))
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		c.emit(OpSetIndex)
+		// a string target produced a new string that replaces the old one
+		jumpPos := c.emit(OpJumpNotTruthy, 9999)
+		if err := c.compileStore(target.Left); err != nil {
+			return err
+		}
+		c.emit(OpPop)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	default:
+		return c.errorf("invalid lvalue in assignment")
+	}
+	return nil
+}
+
+// assignTarget returns the slot an assignment to name writes to: the
+// nearest enclosing binding, or a new one in the current scope.
+func (c *Compiler) assignTarget(name string) Symbol {
+	if symbol, ok := c.symbolTable.Resolve(name); ok && symbol.Scope != BuiltinScope {
+		return symbol
+	}
+	return c.symbolTable.Define(name)
+}
+
+func (c *Compiler) resolve(name string) Symbol {
+	if symbol, ok := c.symbolTable.Resolve(name); ok {
+		return symbol
+	}
+	return c.symbolTable.Root().Define(name)
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(OpGetFree, s.Index)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(OpSetFree, s.Index)
+	}
+}
+
+func (c *Compiler) compilePrefix(node *ast.PrefixExpression) error {
+	switch node.Operator {
+	case "++":
+		ident, ok := node.Right.(*ast.Identifier)
+		if !ok {
+			return c.errorf("++ requires ident")
+		}
+		c.loadSymbol(c.resolve(ident.Value))
+		c.emit(OpInc)
+		c.emit(OpDup)
+		c.storeSymbol(c.assignTarget(ident.Value))
+		return nil
+	case "*":
+		// dereference is a placeholder in the evaluator too
+		c.emit(OpNull)
+		return nil
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	switch node.Operator {
+	case "!":
+		c.emit(OpBang)
+	case "-":
+		c.emit(OpMinus)
+	default:
+		return c.errorf("unknown operator %s", node.Operator)
+	}
+	return nil
+}
+
+var binaryOps = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"%":  OpMod,
+	"<<": OpShl,
+	">>": OpShr,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	">":  OpGreater,
+	"<=": OpLessEqual,
+	">=": OpGreaterEqual,
+}
+
+func (c *Compiler) compileBinary(node *ast.BinaryExpression) error {
+	switch node.Operator {
+	case "&&":
+		// left; JumpNotTruthy false; right; Truthy; Jump end; false: False
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		jumpFalse := c.emit(OpJumpNotTruthy, 9999)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(OpTruthy)
+		jumpEnd := c.emit(OpJump, 9999)
+		c.changeOperand(jumpFalse, len(c.currentInstructions()))
+		c.emit(OpFalse)
+		c.changeOperand(jumpEnd, len(c.currentInstructions()))
+		return nil
+	case "||":
+		// left; JumpNotTruthy right; True; Jump end; right: right; Truthy
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		jumpRight := c.emit(OpJumpNotTruthy, 9999)
+		c.emit(OpTrue)
+		jumpEnd := c.emit(OpJump, 9999)
+		c.changeOperand(jumpRight, len(c.currentInstructions()))
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(OpTruthy)
+		c.changeOperand(jumpEnd, len(c.currentInstructions()))
+		return nil
+	}
+
+	op, ok := binaryOps[node.Operator]
+	if !ok {
+		return c.errorf("unknown operator %s", node.Operator)
+	}
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(op)
+	return nil
+}
+
+func (c *Compiler) compileIf(node *ast.IfStatement) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+	jump := c.emit(OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	if node.Alternative == nil {
+		c.emit(OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperand(jump, len(c.currentInstructions()))
+	return nil
+}
+
+// compileMatch keeps the subject on the stack while the arms try it:
+//
+//	<subject>
+//	OpMatch pattern; OpJumpNotTruthy next; <store bindings>
+//	<guard>; OpJumpNotTruthy next
+//	OpPop; <body>; OpJump end
+//	next: ... OpNoMatch
+//	end:
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	var endJumps []int
+	for _, arm := range node.Arms {
+		names := evaluator.PatternNames(arm.Pattern)
+		c.emit(OpMatch, c.addConstant(&Pattern{Node: arm.Pattern, Names: names}))
+		nextJumps := []int{c.emit(OpJumpNotTruthy, 9999)}
+
+		// the names a pattern binds are scoped to its arm
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+		for i := len(names) - 1; i >= 0; i-- {
+			c.storeSymbol(c.symbolTable.Define(names[i]))
+		}
+		err := func() error {
+			if arm.Guard != nil {
+				if err := c.Compile(arm.Guard); err != nil {
+					return err
+				}
+				nextJumps = append(nextJumps, c.emit(OpJumpNotTruthy, 9999))
+			}
+			c.emit(OpPop)
+			return c.compileBlockValue(arm.Body)
+		}()
+		c.symbolTable = c.symbolTable.Outer
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(OpJump, 9999))
+
+		for _, pos := range nextJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+	c.emit(OpNoMatch)
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileBlockValue compiles block so that it leaves the value of its last
+// expression statement on the stack, or null if it has none.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if block == nil || len(block.Statements) == 0 {
+		c.emit(OpNull)
+		return nil
+	}
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+	if es, ok := block.Statements[last].(*ast.ExpressionStatement); ok && es.Expression != nil {
+		prevTok := c.tok
+		c.tok = es.Token
+		defer func() { c.tok = prevTok }()
+		return c.Compile(es.Expression)
+	}
+	if err := c.Compile(block.Statements[last]); err != nil {
+		return err
+	}
+	c.emit(OpNull)
+	return nil
+}
+
+func (c *Compiler) compileWhile(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpEnd := c.emit(OpJumpNotTruthy, 9999)
+
+	loop := c.enterLoop()
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(OpJump, start)
+	c.leaveLoop()
+
+	end := len(c.currentInstructions())
+	c.changeOperand(jumpEnd, end)
+	c.patchLoop(loop, end, start)
+	c.emit(OpNull)
+	return nil
+}
+
+func (c *Compiler) compileFor(node *ast.ForStatement) error {
+	// the loop variables live in their own block, like the evaluator's loopEnv
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	if node.Init != nil {
+		if err := c.Compile(node.Init); err != nil {
+			return err
+		}
+	}
+
+	start := len(c.currentInstructions())
+	jumpEnd := -1
+	if node.Condition != nil {
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpEnd = c.emit(OpJumpNotTruthy, 9999)
+	}
+
+	loop := c.enterLoop()
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.leaveLoop()
+
+	post := len(c.currentInstructions())
+	if node.Post != nil {
+		if err := c.Compile(node.Post); err != nil {
+			return err
+		}
+	}
+	c.emit(OpJump, start)
+
+	end := len(c.currentInstructions())
+	if jumpEnd != -1 {
+		c.changeOperand(jumpEnd, end)
+	}
+	c.patchLoop(loop, end, post)
+	c.emit(OpNull)
+	return nil
+}
+
+// compileForIn keeps the iterator in a hidden variable of the loop's block,
+// so break and continue leave the stack as they find it.
+func (c *Compiler) compileForIn(node *ast.ForInStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(OpIter)
+
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+	iter := c.symbolTable.Define("(iterator)")
+	c.storeSymbol(iter)
+
+	start := len(c.currentInstructions())
+	c.loadSymbol(iter)
+	names := node.Names()
+	next := c.emit(OpIterNext, len(names), 9999)
+	for i := len(names) - 1; i >= 0; i-- {
+		c.storeSymbol(c.symbolTable.Define(names[i].Value))
+	}
+
+	loop := c.enterLoop()
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(OpJump, start)
+	c.leaveLoop()
+
+	end := len(c.currentInstructions())
+	copy(c.currentInstructions()[next:], Make(OpIterNext, len(names), end))
+	c.patchLoop(loop, end, start)
+	c.emit(OpNull)
+	return nil
+}
+
+// compileTry lays out
+//
+//	OpTry handler; <try>; OpEndTry; <finally>; OpJump end
+//	handler: <catch>; <finally>; OpJump end
+//	end:
+//
+// A catch body is guarded by a second handler when there is a finally
+// block, so an error raised while handling still runs it before
+// propagating. Without a catch block the handler runs finally and rethrows.
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	var endJumps []int
+
+	handlerPos := c.emit(OpTry, 9999)
+	if err := c.compileGuarded(node.Block, node.Finally); err != nil {
+		return err
+	}
+	c.emit(OpEndTry)
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+	endJumps = append(endJumps, c.emit(OpJump, 9999))
+	c.changeOperand(handlerPos, len(c.currentInstructions()))
+
+	if node.Catch != nil {
+		innerPos := -1
+		if node.Finally != nil {
+			innerPos = c.emit(OpTry, 9999)
+		}
+
+		// the catch parameter is scoped to the catch block
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+		if node.CatchParam != nil {
+			c.storeSymbol(c.symbolTable.Define(node.CatchParam.Value))
+		} else {
+			c.emit(OpPop)
+		}
+		var err error
+		if node.Finally != nil {
+			err = c.compileGuarded(node.Catch, node.Finally)
+		} else {
+			err = c.Compile(node.Catch)
+		}
+		c.symbolTable = c.symbolTable.Outer
+		if err != nil {
+			return err
+		}
+
+		if node.Finally != nil {
+			c.emit(OpEndTry)
+			if err := c.compileFinally(node.Finally); err != nil {
+				return err
+			}
+			endJumps = append(endJumps, c.emit(OpJump, 9999))
+			c.changeOperand(innerPos, len(c.currentInstructions()))
+		}
+	}
+
+	if node.Finally != nil {
+		// an error escaped: run finally, then rethrow the exception that is
+		// still on the stack
+		if err := c.compileFinally(node.Finally); err != nil {
+			return err
+		}
+		c.emit(OpThrow)
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileGuarded compiles a block while its try handler is active.
+func (c *Compiler) compileGuarded(block *ast.BlockStatement, finally *ast.BlockStatement) error {
+	c.tries = append(c.tries, &tryScope{finally: finally})
+	defer func() { c.tries = c.tries[:len(c.tries)-1] }()
+	return c.Compile(block)
+}
+
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+	return c.Compile(finally)
+}
+
+// unwindTries pops the try handlers above depth, innermost first, running
+// each finally block outside of its own handler.
+func (c *Compiler) unwindTries(depth int) error {
+	tries := c.tries
+	defer func() { c.tries = tries }()
+
+	for i := len(tries) - 1; i >= depth; i-- {
+		c.emit(OpEndTry)
+		c.tries = tries[:i]
+		if err := c.compileFinally(tries[i].finally); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) enterLoop() *loopScope {
+	loop := &loopScope{tries: len(c.tries)}
+	c.loops = append(c.loops, loop)
+	return loop
+}
+
+func (c *Compiler) leaveLoop() {
+	c.loops = c.loops[:len(c.loops)-1]
+}
+
+func (c *Compiler) patchLoop(loop *loopScope, breakTarget, continueTarget int) {
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, breakTarget)
+	}
+	for _, pos := range loop.continues {
+		c.changeOperand(pos, continueTarget)
+	}
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral) error {
+	outerLoops, outerTries := c.loops, c.tries
+	c.loops, c.tries = nil, nil
+	c.enterScope()
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	if node.Rest != nil {
+		c.symbolTable.Define(node.Rest.Value)
+	}
+	c.hoistAssignments(node.Body.Statements)
+
+	// parameters the caller left out are unset and take their default
+	required := len(node.Parameters)
+	for i, def := range node.Defaults {
+		if def != nil {
+			required = i
+			break
+		}
+	}
+	for i := required; i < len(node.Parameters); i++ {
+		jumpPos := c.emit(OpJumpIfBound, i, 9999)
+		if err := c.Compile(node.Defaults[i]); err != nil {
+			return err
+		}
+		c.emit(OpSetLocal, i)
+		copy(c.currentInstructions()[jumpPos:], Make(OpJumpIfBound, i, len(c.currentInstructions())))
+	}
+
+	if err := c.compileBlockValue(node.Body); err != nil {
+		return err
+	}
+	c.emit(OpReturnValue)
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumDefinitions()
+	localNames := c.symbolTable.Names()
+	instructions, positions := c.leaveScope()
+	c.loops, c.tries = outerLoops, outerTries
+
+	if numLocals > 256 {
+		return c.errorf("too many local variables in function")
+	}
+
+	freeNames := make([]string, len(freeSymbols))
+	for i, s := range freeSymbols {
+		freeNames[i] = s.Name
+		if s.Scope == LocalScope {
+			c.emit(OpLocalCell, s.Index)
+		} else {
+			c.emit(OpFreeCell, s.Index)
+		}
+	}
+
+	fn := &CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		NumRequired:   required,
+		Variadic:      node.Rest != nil,
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+		Positions:     positions,
+	}
+	c.emit(OpClosure, c.addConstant(fn), len(freeSymbols))
+	return nil
+}
+
+func hasSpread(args []ast.Expression) bool {
+	for _, a := range args {
+		if _, ok := a.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSpreadCall passes the arguments of f(a, ...xs) as arrays: each
+// plain argument wrapped in one of its own, each spread array as it is.
+func (c *Compiler) compileSpreadCall(node *ast.CallExpression) error {
+	for _, a := range node.Arguments {
+		if spread, ok := a.(*ast.SpreadExpression); ok {
+			if err := c.Compile(spread.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+		c.emit(OpArray, 1)
+	}
+	if len(node.Arguments) > 255 {
+		return c.errorf("too many arguments in call to %s", node.Function.String())
+	}
+	c.emit(OpCallSpread, len(node.Arguments))
+	return nil
+}
+
+func (c *Compiler) compileLoad(node *ast.LoadStatement) error {
+	file, err := evaluator.ResolveModule(node.File.Value, c.dir)
+	if err != nil {
+		return c.errorf("could not read file: %s", node.File.Value)
+	}
+	content, err := evaluator.ReadModule(file)
+	if err != nil {
+		return c.errorf("could not read file: %s", node.File.Value)
+	}
+
+	l := lexer.New(string(content))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return c.errorf("failed to parse loaded file: %s", node.File.Value)
+	}
+
+	return c.Compile(program)
+}
+
+// compileImport binds the module to its alias in the current scope. The
+// module itself is compiled once, the first time any file imports it.
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	idx, err := c.compileModule(node.Path.Value)
+	if err != nil {
+		return err
+	}
+	c.emit(OpImport, idx)
+
+	name := importName(node)
+	symbol, ok := c.symbolTable.Own(name)
+	if !ok {
+		symbol = c.symbolTable.Define(name)
+	}
+	c.storeSymbol(symbol)
+	return nil
+}
+
+func importName(node *ast.ImportStatement) string {
+	if node.Alias != nil {
+		return node.Alias.Value
+	}
+	return evaluator.ModuleName(node.Path.Value)
+}
+
+// compileModule compiles the file behind an import path as a function of
+// its own and returns its module index. Module scopes only see builtins;
+// names they cannot resolve still get slots among the program's globals.
+func (c *Compiler) compileModule(path string) (int, error) {
+	file, err := evaluator.ResolveModule(path, c.dir)
+	if err != nil {
+		return 0, c.errorf("%s", err)
+	}
+	if idx, ok := c.moduleIndex[file]; ok {
+		return idx, nil
+	}
+	for i, importing := range c.importing {
+		if importing == file {
+			chain := append(append([]string{}, c.importing[i:]...), file)
+			for j := range chain {
+				chain[j] = evaluator.ModuleName(chain[j])
+			}
+			return 0, c.errorf("import cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	content, err := evaluator.ReadModule(file)
+	if err != nil {
+		return 0, c.errorf("could not read module: %s", file)
+	}
+	p := parser.New(lexer.New(string(content)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return 0, c.errorf("failed to parse module %s: %s", file, p.Errors()[0])
+	}
+
+	outerTable, outerDir, outerTok := c.symbolTable, c.dir, c.tok
+	outerLoops, outerTries := c.loops, c.tries
+	c.symbolTable = NewModuleSymbolTable(c.symbolTable.Root())
+	c.dir = filepath.Dir(file)
+	c.loops, c.tries = nil, nil
+	c.importing = append(c.importing, file)
+	c.enterScope()
+
+	c.hoistAssignments(program.Statements)
+	for _, stmt := range program.Statements {
+		if err := c.Compile(stmt); err != nil {
+			return 0, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	var exports []Symbol
+	for _, name := range c.symbolTable.Names() {
+		if symbol, ok := c.symbolTable.Own(name); ok && evaluator.IsExported(name) {
+			exports = append(exports, symbol)
+		}
+	}
+	mod := &CompiledModule{Name: evaluator.ModuleName(file), Path: file, Exports: exports}
+	idx := len(c.modules)
+	c.modules = append(c.modules, mod)
+	c.moduleIndex[file] = idx
+
+	c.emit(OpModule, idx)
+	c.emit(OpReturnValue)
+
+	numLocals := c.symbolTable.NumDefinitions()
+	localNames := c.symbolTable.Names()
+	instructions, positions := c.leaveScope()
+	if numLocals > 256 {
+		return 0, c.errorf("too many top-level variables in module %s", file)
+	}
+	mod.Fn = &CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		LocalNames:   localNames,
+		Positions:    positions,
+	}
+
+	c.importing = c.importing[:len(c.importing)-1]
+	c.symbolTable, c.dir, c.tok = outerTable, outerDir, outerTok
+	c.loops, c.tries = outerLoops, outerTries
+	return idx, nil
+}
+
+// taskBuiltins run functions on goroutines of their own, which the VM, with
+// a single stack and frame set, cannot do for compiled closures.
+var taskBuiltins = map[string]bool{"spawn": true, "task_group": true}
+
+// UnsupportedError is returned for a language feature the compiler cannot
+// lower yet. The program is still valid, so callers can run it on the
+// evaluator instead.
+type UnsupportedError struct {
+	Feature string
+	Node    string
+	Line    int
+	Column  int
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by the compiler: %s on line %d, col %d", e.Feature, e.Node, e.Line, e.Column)
+}
+
+func (c *Compiler) unsupported(feature string, node ast.Node) error {
+	return &UnsupportedError{Feature: feature, Node: node.String(), Line: c.tok.Line, Column: c.tok.Column}
+}
+
+// errorf reports a compile error at the node being compiled.
+func (c *Compiler) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s on line %d, col %d", fmt.Sprintf(format, a...), c.tok.Line, c.tok.Column)
+}
+
+func arrowOperand(pa *ast.PropertyAccessExpression) int {
+	if pa.Token.Literal == "->" {
+		return 1
+	}
+	return 0
+}
+
+/* ---------- constants & emission ---------- */
+
+func (c *Compiler) addConstant(obj evaluator.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) addInteger(v int64) int {
+	if idx, ok := c.intConsts[v]; ok {
+		return idx
+	}
+	idx := c.addConstant(&evaluator.Integer{Value: v})
+	c.intConsts[v] = idx
+	return idx
+}
+
+func (c *Compiler) addString(v string) int {
+	if idx, ok := c.strConsts[v]; ok {
+		return idx
+	}
+	idx := c.addConstant(&evaluator.String{Value: v})
+	c.strConsts[v] = idx
+	return idx
+}
+
+func (c *Compiler) emit(op Opcode, operands ...int) int {
+	ins := Make(op, operands...)
+	pos := len(c.currentInstructions())
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = append(scope.instructions, ins...)
+
+	n := len(scope.positions)
+	if n == 0 || scope.positions[n-1].Line != c.tok.Line || scope.positions[n-1].Column != c.tok.Column {
+		scope.positions = append(scope.positions, Position{Offset: pos, Line: c.tok.Line, Column: c.tok.Column})
+	}
+	return pos
+}
+
+func (c *Compiler) currentInstructions() Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := Opcode(c.currentInstructions()[opPos])
+	newInstruction := Make(op, operand)
+	copy(c.currentInstructions()[opPos:], newInstruction)
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (Instructions, []Position) {
+	scope := c.scopes[c.scopeIndex]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope.instructions, scope.positions
+}
@@ -0,0 +1,418 @@
+package compiler
+
+import (
+	"Nikium/ast"
+	"Nikium/evaluator"
+	"Nikium/lexer"
+	"Nikium/parser"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []Instructions
+}
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpJump, []int{65536}, []byte{byte(OpJump), 0, 1, 0, 0}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Fatalf("instruction has wrong length. want=%d, got=%d",
+				len(tt.expected), len(instruction))
+		}
+		for i, b := range tt.expected {
+			if instruction[i] != tt.expected[i] {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d",
+					i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpClosure 65535 255
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpAdd),
+				Make(OpPop),
+			},
+		},
+		{
+			input:             "2 * 2 % 2",
+			expectedConstants: []interface{}{2},
+			expectedInstructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 0),
+				Make(OpMul),
+				Make(OpConstant, 0),
+				Make(OpMod),
+				Make(OpPop),
+			},
+		},
+		{
+			input:             "-1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpMinus),
+				Make(OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []Instructions{
+				// 0000
+				Make(OpTrue),
+				// 0001
+				Make(OpJumpNotTruthy, 14),
+				// 0006
+				Make(OpConstant, 0),
+				// 0009
+				Make(OpJump, 15),
+				// 0014
+				Make(OpNull),
+				// 0015
+				Make(OpPop),
+				// 0016
+				Make(OpConstant, 1),
+				// 0019
+				Make(OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestWhileLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []Instructions{
+				// 0000
+				Make(OpTrue),
+				// 0001
+				Make(OpJumpNotTruthy, 16),
+				// 0006
+				Make(OpJump, 16),
+				// 0011
+				Make(OpJump, 0),
+				// 0016
+				Make(OpNull),
+				// 0017
+				Make(OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "one = 1; two = one;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpSetGlobal, 0),
+				Make(OpGetGlobal, 0),
+				Make(OpSetGlobal, 1),
+			},
+		},
+		{
+			// reading a name that is not defined yet reserves a global for it
+			input: "f = fn() { g() };",
+			expectedConstants: []interface{}{
+				[]Instructions{
+					Make(OpGetGlobal, 1),
+					Make(OpCall, 0),
+					Make(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				Make(OpClosure, 0, 0),
+				Make(OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a) { fn(b) { a + b } }`,
+			expectedConstants: []interface{}{
+				[]Instructions{
+					Make(OpGetFree, 0),
+					Make(OpGetLocal, 0),
+					Make(OpAdd),
+					Make(OpReturnValue),
+				},
+				[]Instructions{
+					Make(OpLocalCell, 0),
+					Make(OpClosure, 0, 1),
+					Make(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				Make(OpClosure, 1, 0),
+				Make(OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestScopes(t *testing.T) {
+	input := `
+total = 0;
+for (i = 0; i < 3; ++i) { total = total + i; let sq = i * i; }
+bump = fn() { count = count + 1; let tmp = 1; };
+count = 0;
+`
+	program := parse(input)
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// assignments update the nearest binding, and count is known as a
+	// global before bump is compiled; i and sq live in the loop's scope
+	// (which at the top level shares the global slots), tmp in bump
+	names := c.Bytecode().GlobalNames
+	expected := []string{"total", "bump", "count", "i", "sq"}
+	if len(names) != len(expected) {
+		t.Fatalf("wrong globals. want=%v, got=%v", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("wrong global %d. want=%q, got=%q", i, name, names[i])
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "break outside of a loop on line 1, col 1"},
+		{"x = fn() { continue; };", "continue outside of a loop on line 1, col 12"},
+		{"[1][0] = 2;", "invalid lvalue in assignment on line 1, col 8"},
+		{`import "no/such/module";`, "module not found: no/such/module.nik on line 1, col 1"},
+	}
+
+	for _, tt := range tests {
+		c := New()
+		err := c.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected compile error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestUnsupportedFeatures(t *testing.T) {
+	tests := []struct {
+		input   string
+		feature string
+	}{
+		{"P = struct { x: 0 }; p = new P();", "new"},
+		{"P = struct { x: 0 }; P p;", "stack struct declaration"},
+		{"p = 0; delete p;", "delete"},
+		{"f = fn() { if (true) { q = new Q(); } };", "new"},
+		{"g = fn() { yield 1; };", "yield"},
+		{"ch = channel(1); select { case v = recv(ch): v; default: 0; }", "select"},
+		{"f = fn(n) { n * 2 }; t = spawn(f, 21); await(t);", "spawn"},
+		{"task_group([fn() { 1 }, fn() { 2 }]);", "task_group"},
+		{"run = spawn; run(fn() { 1 });", "spawn"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		unsupported, ok := err.(*UnsupportedError)
+		if !ok {
+			t.Errorf("%q: expected an UnsupportedError, got %v", tt.input, err)
+			continue
+		}
+		if unsupported.Feature != tt.feature {
+			t.Errorf("%q: wrong feature. want=%q, got=%q", tt.input, tt.feature, unsupported.Feature)
+		}
+	}
+
+	// a script's own spawn is an ordinary function
+	if err := New().Compile(parse("spawn = fn(f) { f() }; spawn(fn() { 1 });")); err != nil {
+		t.Errorf("expected a user-defined spawn to compile, got %v", err)
+	}
+}
+
+func TestSymbolTableBlocks(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+
+	block := NewBlockSymbolTable(global)
+	b := block.Define("b")
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("block symbol in global scope wrong. got=%+v", b)
+	}
+
+	fn := NewEnclosedSymbolTable(block)
+	fn.Define("c")
+	inner := NewBlockSymbolTable(fn)
+	d := inner.Define("d")
+	if d != (Symbol{Name: "d", Scope: LocalScope, Index: 1}) {
+		t.Errorf("block symbol in function scope wrong. got=%+v", d)
+	}
+	if fn.NumDefinitions() != 2 {
+		t.Errorf("function should own block slots. got=%d", fn.NumDefinitions())
+	}
+
+	for _, name := range []string{"a", "b"} {
+		sym, ok := inner.Resolve(name)
+		if !ok || sym.Scope != GlobalScope {
+			t.Errorf("%s should resolve as global. got=%+v", name, sym)
+		}
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("block variable b leaked into the global scope")
+	}
+	if sym, _ := global.Resolve("a"); sym != a {
+		t.Errorf("a resolved wrong. got=%+v", sym)
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != "" {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+		if err := testConstants(tt.expectedConstants, bytecode.Constants); err != "" {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func concatInstructions(s []Instructions) Instructions {
+	out := Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func testInstructions(expected []Instructions, actual Instructions) string {
+	concatted := concatInstructions(expected)
+	if actual.String() != concatted.String() {
+		return "wrong instructions.\nwant=\n" + concatted.String() + "got=\n" + actual.String()
+	}
+	return ""
+}
+
+func testConstants(expected []interface{}, actual []evaluator.Object) string {
+	if len(expected) != len(actual) {
+		return "wrong number of constants"
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*evaluator.Integer)
+			if !ok || integer.Value != int64(constant) {
+				return "constant is not the expected integer"
+			}
+		case string:
+			str, ok := actual[i].(*evaluator.String)
+			if !ok || str.Value != constant {
+				return "constant is not the expected string"
+			}
+		case []Instructions:
+			fn, ok := actual[i].(*CompiledFunction)
+			if !ok {
+				return "constant is not a function"
+			}
+			if err := testInstructions(constant, fn.Instructions); err != "" {
+				return err
+			}
+		}
+	}
+	return ""
+}
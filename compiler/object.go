@@ -0,0 +1,75 @@
+package compiler
+
+import (
+	"Nikium/ast"
+	"Nikium/evaluator"
+	"fmt"
+)
+
+const (
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	PATTERN_OBJ           = "PATTERN"
+)
+
+// Position maps the instruction starting at Offset back to the source.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+type CompiledFunction struct {
+	Instructions  Instructions
+	NumLocals     int
+	NumParameters int
+	NumRequired   int  // parameters without a default
+	Variadic      bool // a rest parameter follows the others as a local
+	LocalNames    []string
+	FreeNames     []string
+	Positions     []Position
+}
+
+func (cf *CompiledFunction) Type() evaluator.ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// PositionAt returns the source position of the instruction at ip.
+func (cf *CompiledFunction) PositionAt(ip int) (Position, bool) {
+	return positionAt(cf.Positions, ip)
+}
+
+func positionAt(positions []Position, ip int) (Position, bool) {
+	lo, hi := 0, len(positions)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if positions[mid].Offset <= ip {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return Position{}, false
+	}
+	return positions[lo-1], true
+}
+
+// CompiledModule is an imported file. Fn runs the module body once and
+// ends in OpModule, which collects Exports from its locals.
+type CompiledModule struct {
+	Name    string
+	Path    string
+	Fn      *CompiledFunction
+	Exports []Symbol
+}
+
+// Pattern is the pattern of a match arm, with the names it binds in the
+// order OpMatch pushes their values.
+type Pattern struct {
+	Node  ast.Expression
+	Names []string
+}
+
+func (p *Pattern) Type() evaluator.ObjectType { return PATTERN_OBJ }
+func (p *Pattern) Inspect() string            { return "pattern " + p.Node.String() }
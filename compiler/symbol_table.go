@@ -0,0 +1,138 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+	FreeScope    SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// slots tracks the storage behind a global or function scope. Block scopes
+// share the slots of the scope they sit in, so their variables live in the
+// same frame but are only visible inside the block.
+type slots struct {
+	names []string
+}
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store       map[string]Symbol
+	slots       *slots
+	scope       SymbolScope
+	block       bool
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store: make(map[string]Symbol),
+		slots: &slots{},
+		scope: GlobalScope,
+	}
+}
+
+// NewModuleSymbolTable starts the global scope of an imported module. It
+// sees only the builtins, but new globals share the program's slots.
+func NewModuleSymbolTable(globals *SymbolTable) *SymbolTable {
+	s := &SymbolTable{
+		store: make(map[string]Symbol),
+		slots: globals.slots,
+		scope: GlobalScope,
+	}
+	for name, symbol := range globals.store {
+		if symbol.Scope == BuiltinScope {
+			s.store[name] = symbol
+		}
+	}
+	return s
+}
+
+// NewEnclosedSymbolTable opens a function scope inside outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.scope = LocalScope
+	return s
+}
+
+// NewBlockSymbolTable opens a block scope (such as a for loop) inside outer.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{
+		Outer: outer,
+		store: make(map[string]Symbol),
+		slots: outer.slots,
+		scope: outer.scope,
+		block: true,
+	}
+}
+
+func (s *SymbolTable) Define(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: s.scope, Index: len(s.slots.names)}
+	s.slots.names = append(s.slots.names, name)
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve looks name up through the enclosing scopes. Locals of an outer
+// function are turned into free variables of this one on the way.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok {
+		return symbol, ok
+	}
+	if s.block || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+	return s.defineFree(symbol), true
+}
+
+// Own reports whether name is declared directly in this scope.
+func (s *SymbolTable) Own(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok && symbol.Scope == FreeScope {
+		return symbol, false
+	}
+	return symbol, ok
+}
+
+// Root returns the global table at the bottom of the chain.
+func (s *SymbolTable) Root() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+// NumDefinitions is the number of slots used by this scope and its blocks.
+func (s *SymbolTable) NumDefinitions() int { return len(s.slots.names) }
+
+// Names returns the variable name stored in each slot.
+func (s *SymbolTable) Names() []string { return s.slots.names }
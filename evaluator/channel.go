@@ -0,0 +1,184 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Channel passes values between tasks. An unbuffered channel hands each
+// value straight from send to recv; a buffered one holds up to its
+// capacity before send waits.
+type Channel struct {
+	ch     chan Object
+	mu     sync.Mutex // guards closed
+	closed bool
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string  { return fmt.Sprintf("channel(%d)", cap(c.ch)) }
+
+// Send waits until val is taken or buffered. Sending on a closed channel
+// is a ValueError.
+func (c *Channel) Send(val Object) (errObj *Error) {
+	// close may win the race after the check below; the send then panics
+	defer func() {
+		if recover() != nil {
+			errObj = &Error{Kind: VALUE_ERROR, Message: "send: channel is closed"}
+		}
+	}()
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return &Error{Kind: VALUE_ERROR, Message: "send: channel is closed"}
+	}
+	c.ch <- val
+	return nil
+}
+
+// Recv waits for a value. ok is false once the channel is closed and its
+// buffer drained.
+func (c *Channel) Recv() (val Object, ok bool) {
+	val, ok = <-c.ch
+	return val, ok
+}
+
+// Close stops further sends and lets receivers drain what is buffered.
+func (c *Channel) Close() *Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return &Error{Kind: VALUE_ERROR, Message: "close: channel is already closed"}
+	}
+	c.closed = true
+	close(c.ch)
+	return nil
+}
+
+// nativeChannel is channel() or channel(capacity).
+func nativeChannel(args []Object) Object {
+	if len(args) > 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("channel: expected 0 to 1 arguments, got %d", len(args))}
+	}
+	capacity := int64(0)
+	if len(args) == 1 {
+		n, ok := args[0].(*Integer)
+		if !ok {
+			return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("channel: expected an integer capacity, got %s", args[0].Type())}
+		}
+		if n.Value < 0 {
+			return &Error{Kind: VALUE_ERROR, Message: fmt.Sprintf("channel: capacity must not be negative, got %d", n.Value)}
+		}
+		capacity = n.Value
+	}
+	return &Channel{ch: make(chan Object, capacity)}
+}
+
+// channelArg returns args[0] as a channel after checking there are n
+// arguments.
+func channelArg(name string, args []Object, n int) (*Channel, *Error) {
+	if len(args) != n {
+		return nil, &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("%s: expected %d arguments, got %d", name, n, len(args))}
+	}
+	ch, ok := args[0].(*Channel)
+	if !ok {
+		return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s: expected a channel, got %s", name, args[0].Type())}
+	}
+	return ch, nil
+}
+
+func nativeSend(args []Object) Object {
+	ch, errObj := channelArg("send", args, 2)
+	if errObj != nil {
+		return errObj
+	}
+	if errObj := ch.Send(args[1]); errObj != nil {
+		return errObj
+	}
+	return NULL
+}
+
+// nativeRecv is recv(ch): the next value, or null once ch is closed and
+// empty.
+func nativeRecv(args []Object) Object {
+	ch, errObj := channelArg("recv", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if val, ok := ch.Recv(); ok {
+		return val
+	}
+	return NULL
+}
+
+func nativeClose(args []Object) Object {
+	ch, errObj := channelArg("close", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if errObj := ch.Close(); errObj != nil {
+		return errObj
+	}
+	return NULL
+}
+
+// evalSelectStatement evaluates every channel and sent value, then waits
+// for the first case that can go ahead. The chosen case runs in a scope of
+// its own, where the received value is bound.
+func evalSelectStatement(node *ast.SelectStatement, env *Environment) Object {
+	cases := make([]reflect.SelectCase, 0, len(node.Cases)+1)
+	for _, c := range node.Cases {
+		obj := Eval(c.Channel, env)
+		if isError(obj) {
+			return obj
+		}
+		ch, ok := obj.(*Channel)
+		if !ok {
+			return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("select: expected a channel, got %s", obj.Type())}
+		}
+		if !c.Send {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.ch)})
+			continue
+		}
+		val := Eval(c.Value, env)
+		if isError(val) {
+			return val
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.ch), Send: reflect.ValueOf(&val).Elem()})
+	}
+	if node.Default != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+
+	chosen, received, ok, errObj := selectCases(cases)
+	if errObj != nil {
+		return errObj
+	}
+	caseEnv := NewEnclosedEnvironment(env)
+	if chosen == len(node.Cases) {
+		return Eval(node.Default, caseEnv)
+	}
+	c := node.Cases[chosen]
+	if c.Name != nil {
+		var val Object = NULL
+		if ok {
+			val = received.Interface().(Object)
+		}
+		caseEnv.Set(c.Name.Value, val)
+	}
+	return Eval(c.Body, caseEnv)
+}
+
+// selectCases runs reflect.Select, turning a send on a closed channel into
+// a ValueError.
+func selectCases(cases []reflect.SelectCase) (chosen int, received reflect.Value, ok bool, errObj *Error) {
+	defer func() {
+		if recover() != nil {
+			errObj = &Error{Kind: VALUE_ERROR, Message: "select: send on a closed channel"}
+		}
+	}()
+	chosen, received, ok = reflect.Select(cases)
+	return chosen, received, ok, nil
+}
@@ -0,0 +1,144 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// EnumType is the value an enum declaration binds its name to. Its
+// variants are read from it as properties: Color.Red is a value, Result.Ok
+// a function building one from the values the variant carries.
+type EnumType struct {
+	Name     string
+	Variants []*Variant
+	units    map[string]*EnumValue
+}
+
+type Variant struct {
+	Name   string
+	Fields []string // nil for a variant declared without parentheses
+}
+
+func (e *EnumType) Type() ObjectType { return ENUM_TYPE_OBJ }
+func (e *EnumType) Inspect() string {
+	names := make([]string, len(e.Variants))
+	for i, v := range e.Variants {
+		names[i] = v.Name
+		if v.Fields != nil {
+			names[i] += "(" + strings.Join(v.Fields, ", ") + ")"
+		}
+	}
+	return "enum " + e.Name + " { " + strings.Join(names, ", ") + " }"
+}
+
+// NewEnumType builds the enum declared by node. Variants without fields
+// are created once, so Color.Red is always the same value.
+func NewEnumType(node *ast.EnumStatement) *EnumType {
+	enum := &EnumType{Name: node.Name.Value, units: make(map[string]*EnumValue)}
+	for _, v := range node.Variants {
+		variant := &Variant{Name: v.Name.Value}
+		if v.Fields != nil {
+			variant.Fields = make([]string, len(v.Fields))
+			for i, f := range v.Fields {
+				variant.Fields[i] = f.Value
+			}
+		} else {
+			enum.units[variant.Name] = &EnumValue{Enum: enum, Variant: variant}
+		}
+		enum.Variants = append(enum.Variants, variant)
+	}
+	return enum
+}
+
+// Member returns the variant called name.
+func (e *EnumType) Member(name string) Object {
+	if unit, ok := e.units[name]; ok {
+		return unit
+	}
+	for _, v := range e.Variants {
+		if v.Name != name {
+			continue
+		}
+		variant := v
+		return &Function{Native: func(args []Object) Object {
+			if len(args) != len(variant.Fields) {
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("%s.%s expects %d %s, got %d",
+					e.Name, variant.Name, len(variant.Fields), plural(len(variant.Fields), "argument"), len(args))}
+			}
+			values := make([]Object, len(args))
+			copy(values, args)
+			return &EnumValue{Enum: e, Variant: variant, Values: values}
+		}}
+	}
+	return &Error{Kind: VALUE_ERROR, Message: fmt.Sprintf("enum %s has no variant %s", e.Name, name)}
+}
+
+// EnumValue is one variant of an enum with the values it carries.
+type EnumValue struct {
+	Enum    *EnumType
+	Variant *Variant
+	Values  []Object
+}
+
+func (v *EnumValue) Type() ObjectType { return ENUM_OBJ }
+func (v *EnumValue) Inspect() string {
+	out := v.Enum.Name + "." + v.Variant.Name
+	if v.Variant.Fields != nil {
+		values := make([]string, len(v.Values))
+		for i, val := range v.Values {
+			values[i] = val.Inspect()
+		}
+		out += "(" + strings.Join(values, ", ") + ")"
+	}
+	return out
+}
+
+// HashKey lets enum values key hashes. Values carrying something that is
+// not hashable itself fall back to its printed form.
+func (v *EnumValue) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(v.Enum.Name + "." + v.Variant.Name))
+	for _, val := range v.Values {
+		if hashable, ok := val.(Hashable); ok {
+			key := hashable.HashKey()
+			fmt.Fprintf(h, "|%s:%d", key.Type, key.Value)
+		} else {
+			fmt.Fprintf(h, "|%s:%s", val.Type(), val.Inspect())
+		}
+	}
+	return HashKey{Type: ENUM_OBJ, Value: h.Sum64()}
+}
+
+// Property returns the value a variant carries in its field called name.
+func (v *EnumValue) Property(name string) (Object, bool) {
+	for i, field := range v.Variant.Fields {
+		if field == name {
+			return v.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// Equal reports whether two enum values are the same variant of the same
+// enum carrying equal values.
+func (v *EnumValue) Equal(other *EnumValue) bool {
+	if v.Enum != other.Enum || v.Variant != other.Variant {
+		return false
+	}
+	for i, val := range v.Values {
+		eq := evalInfixExpression("==", val, other.Values[i])
+		if isError(eq) || eq != TRUE {
+			return false
+		}
+	}
+	return true
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -3,9 +3,11 @@ package evaluator
 import (
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,12 +19,26 @@ import (
 var (
 	asyncMu     sync.Mutex
 	asyncNextID int64
-	asyncJobs   = make(map[int64]chan Object)
+	asyncJobs   = make(map[int64]*Task) // tasks still running, by id
+
+	// contentsMu guards what arrays, hashes and structs hold, which index
+	// and field assignments change in place while spawned tasks read them.
+	contentsMu sync.RWMutex
 )
 
 type Environment struct {
+	// mu guards store and owned: a spawned task shares the environments
+	// of the closure it runs with the rest of the program.
+	mu    sync.RWMutex
 	store map[string]Object
 	outer *Environment
+	owned []*Struct // instances declared in this scope, destroyed when it ends
+
+	dir     string       // directory of the script, for relative imports
+	modules *moduleCache // shared by a program and everything it imports
+
+	gen  *generator // set on the call environment of a generator body
+	task *Task      // the spawned task running this scope, nil on the main one
 }
 
 func NewEnvironment() *Environment {
@@ -35,6 +51,7 @@ func NewEnvironment() *Environment {
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
 				return &Error{
+					Kind:    ARGUMENT_ERROR,
 					Message: fmt.Sprintf("len: expected 1 argument, got %d", len(args)),
 				}
 			}
@@ -42,11 +59,16 @@ func NewEnvironment() *Environment {
 			case *String:
 				return &Integer{Value: int64(len(arg.Value))}
 			case *Array:
+				contentsMu.RLock()
+				defer contentsMu.RUnlock()
 				return &Integer{Value: int64(len(arg.Elements))}
 			case *Hash:
+				contentsMu.RLock()
+				defer contentsMu.RUnlock()
 				return &Integer{Value: int64(len(arg.Pairs))}
 			default:
 				return &Error{
+					Kind:    TYPE_ERROR,
 					Message: fmt.Sprintf("len: unsupported type %s", arg.Type()),
 				}
 			}
@@ -69,27 +91,65 @@ func NewEnvironment() *Environment {
 	env.Set("push", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 2 {
-				return &Error{Message: fmt.Sprintf("push: expected 2 arguments, got %d", len(args))}
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("push: expected 2 arguments, got %d", len(args))}
 			}
 			arr, ok := args[0].(*Array)
 			if !ok {
-				return &Error{Message: "push: first argument must be an array"}
+				return &Error{Kind: TYPE_ERROR, Message: "push: first argument must be an array"}
+			}
+			elements := arr.snapshot()
+			return &Array{Elements: append(elements, args[1])}
+		},
+	})
+
+	// append and pop change the array in place, in amortized O(1), for
+	// code that grows and shrinks the same array many times
+	env.Set("append", &Function{
+		Native: func(args []Object) Object {
+			if len(args) != 2 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("append: expected 2 arguments, got %d", len(args))}
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return &Error{Kind: TYPE_ERROR, Message: "append: first argument must be an array"}
+			}
+			contentsMu.Lock()
+			arr.Elements = append(arr.Elements, args[1])
+			contentsMu.Unlock()
+			return arr
+		},
+	})
+
+	env.Set("pop", &Function{
+		Native: func(args []Object) Object {
+			if len(args) != 1 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("pop: expected 1 argument, got %d", len(args))}
 			}
-			newElements := make([]Object, len(arr.Elements)+1)
-			copy(newElements, arr.Elements)
-			newElements[len(arr.Elements)] = args[1]
-			return &Array{Elements: newElements}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return &Error{Kind: TYPE_ERROR, Message: "pop: argument must be an array"}
+			}
+			contentsMu.Lock()
+			defer contentsMu.Unlock()
+			n := len(arr.Elements)
+			if n == 0 {
+				return &Error{Kind: VALUE_ERROR, Message: "pop: array is empty"}
+			}
+			last := arr.Elements[n-1]
+			arr.Elements[n-1] = nil
+			arr.Elements = arr.Elements[:n-1]
+			return last
 		},
 	})
 
 	env.Set("ord", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: fmt.Sprintf("ord: expected 1 argument, got %d", len(args))}
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("ord: expected 1 argument, got %d", len(args))}
 			}
 			s, ok := args[0].(*String)
 			if !ok || len(s.Value) == 0 {
-				return &Error{Message: "ord: expected non-empty string"}
+				return &Error{Kind: VALUE_ERROR, Message: "ord: expected non-empty string"}
 			}
 			return &Integer{Value: int64(s.Value[0])}
 		},
@@ -98,11 +158,11 @@ func NewEnvironment() *Environment {
 	env.Set("chr", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: fmt.Sprintf("chr: expected 1 argument, got %d", len(args))}
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("chr: expected 1 argument, got %d", len(args))}
 			}
 			n, ok := args[0].(*Integer)
 			if !ok {
-				return &Error{Message: "chr: expected integer"}
+				return &Error{Kind: TYPE_ERROR, Message: "chr: expected integer"}
 			}
 			return &String{Value: string(rune(n.Value))}
 		},
@@ -113,15 +173,15 @@ func NewEnvironment() *Environment {
 	env.Set("file_read", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: fmt.Sprintf("file_read: expected 1 argument, got %d", len(args))}
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("file_read: expected 1 argument, got %d", len(args))}
 			}
 			path, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "file_read: expected string path"}
+				return &Error{Kind: TYPE_ERROR, Message: "file_read: expected string path"}
 			}
 			data, err := os.ReadFile(path.Value)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("file_read: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("file_read: %s", err)}
 			}
 			return &String{Value: string(data)}
 		},
@@ -130,19 +190,19 @@ func NewEnvironment() *Environment {
 	env.Set("file_write", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 2 {
-				return &Error{Message: fmt.Sprintf("file_write: expected 2 arguments, got %d", len(args))}
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("file_write: expected 2 arguments, got %d", len(args))}
 			}
 			path, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "file_write: first argument must be string path"}
+				return &Error{Kind: TYPE_ERROR, Message: "file_write: first argument must be string path"}
 			}
 			data, ok := args[1].(*String)
 			if !ok {
-				return &Error{Message: "file_write: second argument must be string data"}
+				return &Error{Kind: TYPE_ERROR, Message: "file_write: second argument must be string data"}
 			}
 			err := os.WriteFile(path.Value, []byte(data.Value), 0644)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("file_write: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("file_write: %s", err)}
 			}
 			return NULL
 		},
@@ -151,24 +211,24 @@ func NewEnvironment() *Environment {
 	env.Set("file_append", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 2 {
-				return &Error{Message: fmt.Sprintf("file_append: expected 2 arguments, got %d", len(args))}
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("file_append: expected 2 arguments, got %d", len(args))}
 			}
 			path, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "file_append: first argument must be string path"}
+				return &Error{Kind: TYPE_ERROR, Message: "file_append: first argument must be string path"}
 			}
 			data, ok := args[1].(*String)
 			if !ok {
-				return &Error{Message: "file_append: second argument must be string data"}
+				return &Error{Kind: TYPE_ERROR, Message: "file_append: second argument must be string data"}
 			}
 			f, err := os.OpenFile(path.Value, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("file_append: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("file_append: %s", err)}
 			}
 			defer f.Close()
 			_, err = f.WriteString(data.Value)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("file_append: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("file_append: %s", err)}
 			}
 			return NULL
 		},
@@ -177,11 +237,11 @@ func NewEnvironment() *Environment {
 	env.Set("file_exists", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "file_exists: expected 1 argument"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "file_exists: expected 1 argument"}
 			}
 			path, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "file_exists: expected string path"}
+				return &Error{Kind: TYPE_ERROR, Message: "file_exists: expected string path"}
 			}
 			_, err := os.Stat(path.Value)
 			if os.IsNotExist(err) {
@@ -194,15 +254,15 @@ func NewEnvironment() *Environment {
 	env.Set("file_delete", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "file_delete: expected 1 argument"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "file_delete: expected 1 argument"}
 			}
 			path, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "file_delete: expected string path"}
+				return &Error{Kind: TYPE_ERROR, Message: "file_delete: expected string path"}
 			}
 			err := os.Remove(path.Value)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("file_delete: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("file_delete: %s", err)}
 			}
 			return NULL
 		},
@@ -219,11 +279,11 @@ func NewEnvironment() *Environment {
 	env.Set("time_sleep", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "time_sleep: expected 1 argument (ms)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "time_sleep: expected 1 argument (ms)"}
 			}
 			ms, ok := args[0].(*Integer)
 			if !ok {
-				return &Error{Message: "time_sleep: expected integer milliseconds"}
+				return &Error{Kind: TYPE_ERROR, Message: "time_sleep: expected integer milliseconds"}
 			}
 			time.Sleep(time.Duration(ms.Value) * time.Millisecond)
 			return NULL
@@ -233,11 +293,11 @@ func NewEnvironment() *Environment {
 	env.Set("time_format", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "time_format: expected 1 argument (unix_ms)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "time_format: expected 1 argument (unix_ms)"}
 			}
 			ms, ok := args[0].(*Integer)
 			if !ok {
-				return &Error{Message: "time_format: expected integer"}
+				return &Error{Kind: TYPE_ERROR, Message: "time_format: expected integer"}
 			}
 			t := time.UnixMilli(ms.Value)
 			return &String{Value: t.Format("2006-01-02 15:04:05")}
@@ -246,79 +306,46 @@ func NewEnvironment() *Environment {
 
 	// --- Concurrency ---
 
-	env.Set("spawn", &Function{
-		Native: func(args []Object) Object {
-			if len(args) != 1 {
-				return &Error{Message: "spawn: expected 1 argument (function)"}
-			}
-			fn, ok := args[0].(*Function)
-			if !ok {
-				return &Error{Message: "spawn: expected function"}
-			}
-
-			asyncMu.Lock()
-			asyncNextID++
-			id := asyncNextID
-			ch := make(chan Object, 1)
-			asyncJobs[id] = ch
-			asyncMu.Unlock()
-
-			go func() {
-				result := applyFunction(fn, []Object{}, "")
-				ch <- result
-			}()
-
-			return &Integer{Value: id}
-		},
-	})
-
-	env.Set("await", &Function{
-		Native: func(args []Object) Object {
-			if len(args) != 1 {
-				return &Error{Message: "await: expected 1 argument (task id)"}
-			}
-			id, ok := args[0].(*Integer)
-			if !ok {
-				return &Error{Message: "await: expected integer id"}
-			}
-
-			asyncMu.Lock()
-			ch, exists := asyncJobs[id.Value]
-			asyncMu.Unlock()
+	env.Set("spawn", &Function{Native: nativeSpawn})
 
-			if !exists {
-				return &Error{Message: fmt.Sprintf("await: no task with id %d", id.Value)}
-			}
+	env.Set("channel", &Function{Native: nativeChannel})
+	env.Set("send", &Function{Native: nativeSend})
+	env.Set("recv", &Function{Native: nativeRecv})
+	env.Set("close", &Function{Native: nativeClose})
 
-			result := <-ch
+	env.Set("await", &Function{Native: nativeAwait})
+	env.Set("await_timeout", &Function{Native: nativeAwaitTimeout})
+	env.Set("await_all", &Function{Native: nativeAwaitAll})
+	env.Set("await_any", &Function{Native: nativeAwaitAny})
+	env.Set("cancel", &Function{Native: nativeCancel})
+	env.Set("task_group", &Function{Native: nativeTaskGroup})
 
-			asyncMu.Lock()
-			delete(asyncJobs, id.Value)
-			asyncMu.Unlock()
-
-			return result
-		},
-	})
+	env.Set("mutex", &Function{Native: nativeMutex})
+	env.Set("rwmutex", &Function{Native: nativeRWMutex})
+	env.Set("with_lock", taskNative(nativeWithLock))
+	env.Set("with_rlock", taskNative(nativeWithRLock))
+	env.Set("atomic", &Function{Native: nativeAtomic})
+	env.Set("waitgroup", &Function{Native: nativeWaitGroup})
 
 	// --- Network ---
 
 	env.Set("net_get", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "net_get: expected 1 argument (url)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "net_get: expected 1 argument (url)"}
 			}
 			url, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "net_get: expected string url"}
+				return &Error{Kind: TYPE_ERROR, Message: "net_get: expected string url"}
 			}
 			resp, err := http.Get(url.Value)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("net_get: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("net_get: %s", err)}
 			}
 			defer resp.Body.Close()
 			body, err := io.ReadAll(resp.Body)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("net_get: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("net_get: %s", err)}
 			}
 			return &String{Value: string(body)}
 		},
@@ -327,15 +354,15 @@ func NewEnvironment() *Environment {
 	env.Set("net_status", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "net_status: expected 1 argument (url)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "net_status: expected 1 argument (url)"}
 			}
 			url, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "net_status: expected string url"}
+				return &Error{Kind: TYPE_ERROR, Message: "net_status: expected string url"}
 			}
 			resp, err := http.Get(url.Value)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("net_status: %s", err)}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("net_status: %s", err)}
 			}
 			defer resp.Body.Close()
 			return &Integer{Value: int64(resp.StatusCode)}
@@ -347,16 +374,16 @@ func NewEnvironment() *Environment {
 	env.Set("build", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "build: expected 1 argument (shell command)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "build: expected 1 argument (shell command)"}
 			}
 			cmdStr, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "build: expected string command"}
+				return &Error{Kind: TYPE_ERROR, Message: "build: expected string command"}
 			}
 			cmd := exec.Command("sh", "-c", cmdStr.Value)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("build: %s\n%s", err, string(output))}
+				return &Error{Kind: IO_ERROR, Message: fmt.Sprintf("build: %s\n%s", err, string(output))}
 			}
 			return &String{Value: string(output)}
 		},
@@ -364,17 +391,20 @@ func NewEnvironment() *Environment {
 
 	// --- Hash helpers ---
 
+	env.Set("range", &Function{Native: nativeRange})
+	env.Set("next", taskNative(nativeNext))
+
 	env.Set("keys", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "keys: expected 1 argument"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "keys: expected 1 argument"}
 			}
 			h, ok := args[0].(*Hash)
 			if !ok {
-				return &Error{Message: "keys: expected hash"}
+				return &Error{Kind: TYPE_ERROR, Message: "keys: expected hash"}
 			}
 			keys := []Object{}
-			for _, pair := range h.Pairs {
+			for _, pair := range h.snapshot() {
 				keys = append(keys, pair.Key)
 			}
 			return &Array{Elements: keys}
@@ -384,14 +414,14 @@ func NewEnvironment() *Environment {
 	env.Set("values", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "values: expected 1 argument"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "values: expected 1 argument"}
 			}
 			h, ok := args[0].(*Hash)
 			if !ok {
-				return &Error{Message: "values: expected hash"}
+				return &Error{Kind: TYPE_ERROR, Message: "values: expected hash"}
 			}
 			vals := []Object{}
-			for _, pair := range h.Pairs {
+			for _, pair := range h.snapshot() {
 				vals = append(vals, pair.Value)
 			}
 			return &Array{Elements: vals}
@@ -401,17 +431,19 @@ func NewEnvironment() *Environment {
 	env.Set("has_key", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 2 {
-				return &Error{Message: "has_key: expected 2 arguments (hash, key)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "has_key: expected 2 arguments (hash, key)"}
 			}
 			h, ok := args[0].(*Hash)
 			if !ok {
-				return &Error{Message: "has_key: first argument must be hash"}
+				return &Error{Kind: TYPE_ERROR, Message: "has_key: first argument must be hash"}
 			}
 			hashable, ok := args[1].(Hashable)
 			if !ok {
-				return &Error{Message: "has_key: key not hashable"}
+				return &Error{Kind: TYPE_ERROR, Message: "has_key: key not hashable"}
 			}
+			contentsMu.RLock()
 			_, exists := h.Pairs[hashable.HashKey()]
+			contentsMu.RUnlock()
 			return nativeBoolToBooleanObject(exists)
 		},
 	})
@@ -419,19 +451,19 @@ func NewEnvironment() *Environment {
 	env.Set("set", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 3 {
-				return &Error{Message: "set: expected 3 arguments (hash, key, value)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "set: expected 3 arguments (hash, key, value)"}
 			}
 			h, ok := args[0].(*Hash)
 			if !ok {
-				return &Error{Message: "set: first argument must be hash"}
+				return &Error{Kind: TYPE_ERROR, Message: "set: first argument must be hash"}
 			}
 			hashable, ok := args[1].(Hashable)
 			if !ok {
-				return &Error{Message: "set: key not hashable"}
+				return &Error{Kind: TYPE_ERROR, Message: "set: key not hashable"}
 			}
 			newPairs := make(map[HashKey]HashPair)
-			for k, v := range h.Pairs {
-				newPairs[k] = v
+			for _, pair := range h.snapshot() {
+				newPairs[pair.Key.(Hashable).HashKey()] = pair
 			}
 			hk := hashable.HashKey()
 			newPairs[hk] = HashPair{Key: args[1], Value: args[2]}
@@ -442,19 +474,19 @@ func NewEnvironment() *Environment {
 	env.Set("delete_key", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 2 {
-				return &Error{Message: "delete_key: expected 2 arguments (hash, key)"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "delete_key: expected 2 arguments (hash, key)"}
 			}
 			h, ok := args[0].(*Hash)
 			if !ok {
-				return &Error{Message: "delete_key: first argument must be hash"}
+				return &Error{Kind: TYPE_ERROR, Message: "delete_key: first argument must be hash"}
 			}
 			hashable, ok := args[1].(Hashable)
 			if !ok {
-				return &Error{Message: "delete_key: key not hashable"}
+				return &Error{Kind: TYPE_ERROR, Message: "delete_key: key not hashable"}
 			}
 			newPairs := make(map[HashKey]HashPair)
-			for k, v := range h.Pairs {
-				newPairs[k] = v
+			for _, pair := range h.snapshot() {
+				newPairs[pair.Key.(Hashable).HashKey()] = pair
 			}
 			delete(newPairs, hashable.HashKey())
 			return &Hash{Pairs: newPairs}
@@ -466,16 +498,18 @@ func NewEnvironment() *Environment {
 	env.Set("type", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "type: expected 1 argument"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "type: expected 1 argument"}
 			}
 			return &String{Value: string(args[0].Type())}
 		},
 	})
 
+	env.Set("format", &Function{Native: nativeFormat})
+
 	env.Set("str", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "str: expected 1 argument"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "str: expected 1 argument"}
 			}
 			return &String{Value: args[0].Inspect()}
 		},
@@ -484,20 +518,172 @@ func NewEnvironment() *Environment {
 	env.Set("int_parse", &Function{
 		Native: func(args []Object) Object {
 			if len(args) != 1 {
-				return &Error{Message: "int_parse: expected 1 argument"}
+				return &Error{Kind: ARGUMENT_ERROR, Message: "int_parse: expected 1 argument"}
 			}
 			s, ok := args[0].(*String)
 			if !ok {
-				return &Error{Message: "int_parse: expected string"}
+				return &Error{Kind: TYPE_ERROR, Message: "int_parse: expected string"}
 			}
 			val, err := strconv.ParseInt(strings.TrimSpace(s.Value), 10, 64)
 			if err != nil {
-				return &Error{Message: fmt.Sprintf("int_parse: %s", err)}
+				return &Error{Kind: PARSE_ERROR, Message: fmt.Sprintf("int_parse: %s", err)}
 			}
 			return &Integer{Value: val}
 		},
 	})
 
+	// --- Numbers ---
+
+	env.Set("float", &Function{
+		Native: func(args []Object) Object {
+			if len(args) != 1 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: "float: expected 1 argument"}
+			}
+			switch arg := args[0].(type) {
+			case *Float:
+				return arg
+			case *Integer:
+				return &Float{Value: float64(arg.Value)}
+			case *String:
+				val, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return &Error{Kind: PARSE_ERROR, Message: fmt.Sprintf("float: cannot convert %q", arg.Value)}
+				}
+				return &Float{Value: val}
+			default:
+				return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("float: unsupported type %s", arg.Type())}
+			}
+		},
+	})
+
+	env.Set("int", &Function{
+		Native: func(args []Object) Object {
+			if len(args) != 1 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: "int: expected 1 argument"}
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				// truncates toward zero, like a C cast
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				val, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+				if err != nil {
+					return &Error{Kind: PARSE_ERROR, Message: fmt.Sprintf("int: cannot convert %q", arg.Value)}
+				}
+				return &Integer{Value: val}
+			default:
+				return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("int: unsupported type %s", arg.Type())}
+			}
+		},
+	})
+
+	env.Set("round", &Function{
+		Native: func(args []Object) Object {
+			if len(args) < 1 || len(args) > 2 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: "round: expected 1 or 2 arguments (number, digits)"}
+			}
+			if !isNumeric(args[0]) {
+				return &Error{Kind: TYPE_ERROR, Message: "round: expected number"}
+			}
+			x := toFloat(args[0])
+			digits := int64(0)
+			if len(args) == 2 {
+				d, ok := args[1].(*Integer)
+				if !ok {
+					return &Error{Kind: TYPE_ERROR, Message: "round: digits must be integer"}
+				}
+				digits = d.Value
+			}
+			scale := math.Pow(10, float64(digits))
+			return &Float{Value: math.Round(x*scale) / scale}
+		},
+	})
+
+	env.Set("float_format", &Function{
+		Native: func(args []Object) Object {
+			if len(args) != 2 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: "float_format: expected 2 arguments (number, digits)"}
+			}
+			if !isNumeric(args[0]) {
+				return &Error{Kind: TYPE_ERROR, Message: "float_format: expected number"}
+			}
+			x := toFloat(args[0])
+			digits, ok := args[1].(*Integer)
+			if !ok || digits.Value < 0 {
+				return &Error{Kind: TYPE_ERROR, Message: "float_format: digits must be a non-negative integer"}
+			}
+			return &String{Value: strconv.FormatFloat(x, 'f', int(digits.Value), 64)}
+		},
+	})
+
+	// math_* back the wrappers in stdlib/math.nik
+	for name, fn := range map[string]func(float64) float64{
+		"math_sqrt":  math.Sqrt,
+		"math_sin":   math.Sin,
+		"math_cos":   math.Cos,
+		"math_tan":   math.Tan,
+		"math_log":   math.Log,
+		"math_exp":   math.Exp,
+		"math_floor": math.Floor,
+		"math_ceil":  math.Ceil,
+	} {
+		name, fn := name, fn
+		env.Set(name, &Function{
+			Native: func(args []Object) Object {
+				if len(args) != 1 {
+					return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("%s: expected 1 argument", name)}
+				}
+				if !isNumeric(args[0]) {
+					return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s: expected number", name)}
+				}
+				x := toFloat(args[0])
+				return &Float{Value: fn(x)}
+			},
+		})
+	}
+
+	// error(kind, message) builds an exception for throw, e.g.
+	// throw error("ValueError", "price must be positive");
+	env.Set("error", &Function{
+		Native: func(args []Object) Object {
+			if len(args) != 2 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: "error: expected 2 arguments (kind, message)"}
+			}
+			kind, ok := args[0].(*String)
+			if !ok {
+				return &Error{Kind: TYPE_ERROR, Message: "error: kind must be a string"}
+			}
+			msg, ok := args[1].(*String)
+			if !ok {
+				return &Error{Kind: TYPE_ERROR, Message: "error: message must be a string"}
+			}
+			return &Exception{Err: &Error{Kind: kind.Value, Message: msg.Value}}
+		},
+	})
+
+	env.Set("implements", &Function{
+		Native: func(args []Object) Object {
+			if len(args) != 2 {
+				return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("implements: expected 2 arguments, got %d", len(args))}
+			}
+			iface, ok := args[1].(*Interface)
+			if !ok {
+				return &Error{Kind: TYPE_ERROR, Message: "implements: second argument must be an interface"}
+			}
+			return nativeBoolToBooleanObject(Implements(args[0], iface))
+		},
+	})
+
+	// --- Memory ---
+
+	env.Set("heap_stats", &Function{
+		Native: func(args []Object) Object {
+			return heapStats()
+		},
+	})
+
 	env.Set("exit", &Function{
 		Native: func(args []Object) Object {
 			code := 0
@@ -514,21 +700,119 @@ func NewEnvironment() *Environment {
 	return env
 }
 
+// Builtin is a named native function from the global environment.
+type Builtin struct {
+	Name string
+	Fn   *Function
+}
+
+// Builtins returns the native functions every new environment starts with,
+// sorted by name so the order is stable across calls.
+func Builtins() []Builtin {
+	env := NewEnvironment()
+	builtins := make([]Builtin, 0, len(env.store))
+	for name, obj := range env.store {
+		if fn, ok := obj.(*Function); ok && fn.Native != nil {
+			builtins = append(builtins, Builtin{Name: name, Fn: fn})
+		}
+	}
+	sort.Slice(builtins, func(i, j int) bool { return builtins[i].Name < builtins[j].Name })
+	return builtins
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: outer}
+	env := &Environment{store: s, outer: outer}
+	if outer != nil {
+		env.task = outer.task
+	}
+	return env
+}
+
+// SetDir records the directory of the script this environment runs, so
+// imports and loads resolve relative to it.
+func (e *Environment) SetDir(dir string) {
+	e.dir = dir
+}
+
+func (e *Environment) scriptDir() string {
+	for env := e; env != nil; env = env.outer {
+		if env.dir != "" {
+			return env.dir
+		}
+	}
+	return ""
+}
+
+// moduleCache returns the cache of the outermost environment, creating it
+// on first use.
+func (e *Environment) moduleCache() *moduleCache {
+	env := e
+	for env.modules == nil && env.outer != nil {
+		env = env.outer
+	}
+	if env.modules == nil {
+		env.modules = &moduleCache{loaded: make(map[string]*Module)}
+	}
+	return env.modules
+}
+
+// generator returns the generator whose body this environment belongs to.
+func (e *Environment) generator() *generator {
+	for env := e; env != nil; env = env.outer {
+		if env.gen != nil {
+			return env.gen
+		}
+	}
+	return nil
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+	for env := e; env != nil; env = env.outer {
+		if obj, ok := env.local(name); ok {
+			return obj, true
+		}
 	}
+	return nil, false
+}
+
+// local looks name up in this environment only.
+func (e *Environment) local(name string) (Object, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	obj, ok := e.store[name]
 	return obj, ok
 }
 
+// Set binds name in this environment, shadowing any outer binding.
 func (e *Environment) Set(name string, val Object) Object {
+	e.mu.Lock()
 	e.store[name] = val
+	e.mu.Unlock()
 	return val
 }
 
+// Assign updates the nearest enclosing binding of name, or binds it here
+// if no scope defines it yet.
+func (e *Environment) Assign(name string, val Object) Object {
+	for env := e; env != nil; env = env.outer {
+		env.mu.Lock()
+		_, ok := env.store[name]
+		if ok {
+			env.store[name] = val
+		}
+		env.mu.Unlock()
+		if ok {
+			return val
+		}
+	}
+	return e.Set(name, val)
+}
+
+// own records a struct declared in this scope, to destroy when it ends.
+func (e *Environment) own(instance *Struct) {
+	e.mu.Lock()
+	e.owned = append(e.owned, instance)
+	e.mu.Unlock()
+}
+
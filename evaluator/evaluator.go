@@ -5,7 +5,8 @@ import (
 	"Nikium/lexer"
 	"Nikium/parser"
 	"fmt"
-	"os"
+	"math"
+	"strings"
 )
 
 var (
@@ -23,7 +24,7 @@ func Eval(node ast.Node, env *Environment) Object {
 	if err, ok := obj.(*Error); ok {
 		if !err.HasLocation && node != nil {
 			tok := node.GetToken()
-			err.Message = fmt.Sprintf("%s on line %d, col %d", err.Message, tok.Line, tok.Column)
+			err.Line, err.Column = tok.Line, tok.Column
 			err.HasLocation = true
 		}
 	}
@@ -51,15 +52,22 @@ func evalInner(node ast.Node, env *Environment) Object {
 		} else {
 			if strct, isStruct := typeObj.(*Struct); isStruct {
 				if node.IsPointer {
+					if node.Arguments != nil {
+						return newError("cannot construct pointer %s; use new %s(...)", node.Name.Value, node.Type)
+					}
 					val = NULL
 				} else {
 					instance := instantiateStruct(strct, node.Type)
-					callConstructor(instance, []Object{})
-					// resolve generic type args: p<int> name
-					if node.GenericType != "" && strct.GenericTypes != nil {
-						for k := range instance.GenericTypes {
-							instance.GenericTypes[k] = node.GenericType
-						}
+					// resolve generic type args: Pair<int, string> name
+					if errObj := instantiateGeneric(instance, node.Type, node.TypeArgs, env); errObj != nil {
+						return errObj
+					}
+					args := evalArguments(node.Arguments, env)
+					if len(args) == 1 && isError(args[0]) {
+						return args[0]
+					}
+					if errObj := callConstructor(env.task, instance, args); errObj != nil {
+						return errObj
 					}
 					val = instance
 				}
@@ -67,6 +75,9 @@ func evalInner(node ast.Node, env *Environment) Object {
 				val = NULL
 			}
 		}
+		if node.Arguments != nil && val == NULL {
+			return newError("%s has no constructor", node.Type)
+		}
 
 		if node.Value != nil {
 			val = Eval(node.Value, env)
@@ -76,6 +87,9 @@ func evalInner(node ast.Node, env *Environment) Object {
 		}
 
 		env.Set(node.Name.Value, val)
+		if instance, ok := val.(*Struct); ok && node.Value == nil {
+			env.own(instance)
+		}
 		return NULL
 
 	case *ast.NewExpression:
@@ -85,14 +99,22 @@ func evalInner(node ast.Node, env *Environment) Object {
 		}
 		if strct, isStruct := typeObj.(*Struct); isStruct {
 			instance := instantiateStruct(strct, node.Class)
-			callConstructor(instance, evalExpressions(node.Arguments, env))
-			// resolve generic type args: new p<int>()
-			if node.GenericType != "" && instance.GenericTypes != nil {
-				for k := range instance.GenericTypes {
-					instance.GenericTypes[k] = node.GenericType
-				}
+			// resolve generic type args: new Pair<int, string>()
+			if errObj := instantiateGeneric(instance, node.Class, node.TypeArgs, env); errObj != nil {
+				return errObj
+			}
+			args := evalArguments(node.Arguments, env)
+			if len(args) == 1 && isError(args[0]) {
+				return args[0]
+			}
+			if errObj := callConstructor(env.task, instance, args); errObj != nil {
+				return errObj
+			}
+			alloc, errObj := newAllocation(instance)
+			if errObj != nil {
+				return errObj
 			}
-			return &Pointer{Value: instance}
+			return &Pointer{alloc: alloc}
 		}
 		return newError("cannot instantiate %s", node.Class)
 
@@ -101,18 +123,15 @@ func evalInner(node ast.Node, env *Environment) Object {
 		if isError(val) {
 			return val
 		}
-		// propagate generic type param name to struct/function object
-		if node.GenericType != "" {
-			if strct, ok := val.(*Struct); ok {
-				if strct.GenericTypes == nil {
-					strct.GenericTypes = make(map[string]string)
-				}
-				strct.GenericTypes[node.GenericType] = "" // unresolved
-			} else if fn, ok := val.(*Function); ok {
-				fn.GenericType = node.GenericType
-			}
+		if node.Declare {
+			env.Set(node.Name.Value, val)
+		} else {
+			env.Assign(node.Name.Value, val)
 		}
-		env.Set(node.Name.Value, val)
+		return NULL
+
+	case *ast.EnumStatement:
+		env.Set(node.Name.Value, NewEnumType(node))
 		return NULL
 
 	case *ast.AssignExpression:
@@ -120,30 +139,12 @@ func evalInner(node ast.Node, env *Environment) Object {
 		if isError(val) {
 			return val
 		}
-		if pa, ok := node.Left.(*ast.PropertyAccessExpression); ok {
-			object := Eval(pa.Object, env)
-			if isError(object) {
-				return object
-			}
-			if pa.Token.Literal == "->" {
-				ptr, isPtr := object.(*Pointer)
-				if !isPtr {
-					return newError("-> applied to non-pointer in assignment")
-				}
-				return evalPropertyAssignment(ptr.Value, pa.Property, val)
-			} else {
-				if object.Type() == POINTER_OBJ {
-					return newError(". applied to pointer in assignment")
-				}
-				return evalPropertyAssignment(object, pa.Property, val)
-			}
-		} else if id, ok := node.Left.(*ast.Identifier); ok {
-			env.Set(id.Value, val)
-			return val
-		}
-		return newError("invalid lvalue in assignment")
+		return evalAssignment(node.Left, val, env)
 
 	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			return &ReturnValue{Value: NULL}
+		}
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
@@ -161,18 +162,43 @@ func evalInner(node ast.Node, env *Environment) Object {
 	case *ast.BreakStatement:
 		return BREAK_OBJ_VAL
 
+	case *ast.YieldStatement:
+		return evalYieldStatement(node, env)
+
 	case *ast.ContinueStatement:
 		return CONTINUE_OBJ_VAL
 
 	case *ast.LoadStatement:
 		return evalLoadStatement(node, env)
 
+	case *ast.ImportStatement:
+		return evalImportStatement(node, env)
+
+	case *ast.DeleteStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		switch val := val.(type) {
+		case *Pointer:
+			return val.Delete(env.task)
+		case *Null:
+			return NULL // deleting a null pointer does nothing
+		}
+		return newError("delete applied to non-pointer %s", val.Type())
+
 	case *ast.IntegerLiteral:
 		return &Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &Float{Value: node.Value}
+
 	case *ast.StringLiteral:
 		return &String{Value: node.Value}
 
+	case *ast.TemplateLiteral:
+		return evalTemplateLiteral(node, env)
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
@@ -236,9 +262,25 @@ func evalInner(node ast.Node, env *Environment) Object {
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+
+	case *ast.SelectStatement:
+		return evalSelectStatement(node, env)
+
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return throwValue(val)
+
 	case *ast.IfStatement:
 		return evalIfExpression(node, env)
 
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+
 	case *ast.WhileStatement:
 		return evalWhileStatement(node, env)
 
@@ -249,6 +291,8 @@ func evalInner(node ast.Node, env *Environment) Object {
 
 	case *ast.StructLiteral:
 		return evalStructLiteral(node, env)
+	case *ast.InterfaceLiteral:
+		return evalInterfaceLiteral(node, env)
 
 	case *ast.PropertyAccessExpression:
 		object := Eval(node.Object, env)
@@ -260,7 +304,7 @@ func evalInner(node ast.Node, env *Environment) Object {
 			if !ok {
 				return newError("-> applied to non-pointer")
 			}
-			return evalPropertyAccessExpression(ptr.Value, node.Property)
+			return ptr.Get(node.Property.Value)
 		} else {
 			if object.Type() == POINTER_OBJ {
 				return newError(". applied to pointer")
@@ -271,6 +315,9 @@ func evalInner(node ast.Node, env *Environment) Object {
 	case *ast.ForStatement:
 		return evalForStatement(node, env)
 
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 
@@ -279,19 +326,35 @@ func evalInner(node ast.Node, env *Environment) Object {
 			Parameters:  node.Parameters,
 			Body:        node.Body,
 			Env:         env,
-			GenericType: node.GenericType,
+			TypeParams:  node.TypeParams,
+			ParamTypes:  node.ParamTypes,
+			Defaults:    node.Defaults,
+			Rest:        node.Rest,
+			Generator:   node.Generator,
 		}
 
 	case *ast.CallExpression:
+		if errObj := env.task.check(); errObj != nil {
+			return errObj
+		}
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
+		args := evalArguments(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args, node.TypeArg)
+		if node.TypeArgs != nil {
+			fn, ok := function.(*Function)
+			if !ok || fn.Native != nil {
+				return newError("%s is not generic", node.Function.String())
+			}
+			if errObj := checkGenericArgs(fn, node.Function.String(), args, node.TypeArgs, env); errObj != nil {
+				return errObj
+			}
+		}
+		return applyFunctionAs(env.task, function, args)
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
@@ -342,6 +405,8 @@ func evalIndexExpression(left, index Object) Object {
 		if !ok {
 			return newError("array index must be integer")
 		}
+		contentsMu.RLock()
+		defer contentsMu.RUnlock()
 		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
 			return NULL
 		}
@@ -360,7 +425,9 @@ func evalIndexExpression(left, index Object) Object {
 		if !ok {
 			return newError("unusable as hash key: %s", index.Type())
 		}
+		contentsMu.RLock()
 		pair, ok := left.Pairs[hashable.HashKey()]
+		contentsMu.RUnlock()
 		if !ok {
 			return NULL
 		}
@@ -387,7 +454,11 @@ func evalProgram(program *ast.Program, env *Environment) Object {
 }
 
 func evalLoadStatement(node *ast.LoadStatement, env *Environment) Object {
-	content, err := os.ReadFile(node.File.Value)
+	file, err := ResolveModule(node.File.Value, env.scriptDir())
+	if err != nil {
+		return newError("could not read file: %s", node.File.Value)
+	}
+	content, err := ReadModule(file)
 	if err != nil {
 		return newError("could not read file: %s", node.File.Value)
 	}
@@ -417,8 +488,50 @@ func evalBlockStatement(block *ast.BlockStatement, env *Environment) Object {
 	return result
 }
 
+// evalTryStatement runs the try block, hands a raised error to the catch
+// block as an Exception, and always runs the finally block last. A finally
+// block that itself returns, breaks or raises wins over the earlier outcome.
+func evalTryStatement(node *ast.TryStatement, env *Environment) Object {
+	result := Eval(node.Block, env)
+
+	if errObj, ok := result.(*Error); ok && node.Catch != nil {
+		catchEnv := NewEnclosedEnvironment(env)
+		if node.CatchParam != nil {
+			catchEnv.Set(node.CatchParam.Value, &Exception{Err: errObj})
+		}
+		result = Eval(node.Catch, catchEnv)
+	}
+
+	if node.Finally != nil {
+		fin := Eval(node.Finally, env)
+		if fin != nil {
+			switch fin.Type() {
+			case RETURN_VALUE_OBJ, ERROR_OBJ, BREAK_OBJ, CONTINUE_OBJ:
+				return fin
+			}
+		}
+	}
+	return result
+}
+
+// throwValue turns the operand of throw into a raised error. Throwing a
+// caught exception re-raises the original error with its location.
+func throwValue(val Object) Object {
+	switch val := val.(type) {
+	case *Exception:
+		return val.Err
+	case *String:
+		return &Error{Kind: THROWN_ERROR, Message: val.Value, Value: val}
+	default:
+		return &Error{Kind: THROWN_ERROR, Message: val.Inspect(), Value: val}
+	}
+}
+
 func evalWhileStatement(ws *ast.WhileStatement, env *Environment) Object {
 	for {
+		if errObj := env.task.check(); errObj != nil {
+			return errObj
+		}
 		cond := Eval(ws.Condition, env)
 		if isError(cond) {
 			return cond
@@ -458,11 +571,26 @@ func evalIdentifier(node *ast.Identifier, env *Environment) Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if node.Value == "string" { return &String{Value: ""} }
-	if node.Value == "int" { return &Integer{Value: 0} }
+	if zero := typeZeroValue(node.Value); zero != nil {
+		return zero
+	}
 	return newError("identifier not found: %s", node.Value)
 }
 
+// typeZeroValue returns the starting value for a field or variable declared
+// with a primitive type name, e.g. `age: int` inside a struct literal.
+func typeZeroValue(name string) Object {
+	switch name {
+	case "int":
+		return &Integer{Value: 0}
+	case "float":
+		return &Float{Value: 0}
+	case "string":
+		return &String{Value: ""}
+	}
+	return nil
+}
+
 func evalExpressions(exps []ast.Expression, env *Environment) []Object {
 	var result []Object
 	for _, e := range exps {
@@ -475,32 +603,117 @@ func evalExpressions(exps []ast.Expression, env *Environment) []Object {
 	return result
 }
 
-func applyFunction(fn Object, args []Object, typeArg string) Object {
+// evalArguments evaluates the arguments of a call, expanding ...xs into
+// the elements of xs.
+func evalArguments(exps []ast.Expression, env *Environment) []Object {
+	var result []Object
+	for _, e := range exps {
+		spread, ok := e.(*ast.SpreadExpression)
+		if !ok {
+			evaluated := Eval(e, env)
+			if isError(evaluated) {
+				return []Object{evaluated}
+			}
+			result = append(result, evaluated)
+			continue
+		}
+		evaluated := Eval(spread.Value, env)
+		if isError(evaluated) {
+			return []Object{evaluated}
+		}
+		arr, ok := evaluated.(*Array)
+		if !ok {
+			return []Object{SpreadError(evaluated)}
+		}
+		result = append(result, arr.snapshot()...)
+	}
+	return result
+}
+
+// applyFunctionAs calls fn on behalf of task t, whose cancellation the body
+// then checks. With a nil t the body keeps the task of the scope fn was
+// defined in.
+func applyFunctionAs(t *Task, fn Object, args []Object) Object {
 	switch fn := fn.(type) {
 	case *Function:
+		if fn.TaskNative != nil {
+			return fn.TaskNative(t, args)
+		}
 		if fn.Native != nil {
 			return fn.Native(args)
 		}
-		// type-check args if generic
-		if typeArg != "" && fn.GenericType != "" {
-			for _, arg := range args {
-				if !typeMatchesGeneric(arg, typeArg) {
-					return newError("generic type mismatch: expected %s, got %s", typeArg, arg.Type())
-				}
-			}
+		if errObj := ArityError(fn.required(), len(fn.Parameters), fn.Rest != nil, len(args)); errObj != nil {
+			return errObj
 		}
 		env := NewEnclosedEnvironment(fn.Env)
+		if t != nil {
+			env.task = t
+		}
 		for i, param := range fn.Parameters {
-			env.Set(param.Value, args[i])
+			if i < len(args) {
+				env.Set(param.Value, args[i])
+				continue
+			}
+			// defaults are evaluated on each call and see the parameters
+			// before them
+			val := Eval(fn.Defaults[i], env)
+			if isError(val) {
+				return val
+			}
+			env.Set(param.Value, val)
+		}
+		if fn.Rest != nil {
+			rest := []Object{}
+			if len(args) > len(fn.Parameters) {
+				rest = append(rest, args[len(fn.Parameters):]...)
+			}
+			env.Set(fn.Rest.Value, &Array{Elements: rest})
+		}
+		if fn.Generator {
+			return newGenerator(fn, env)
 		}
 		result := unwrapReturnValue(Eval(fn.Body, env))
 		cleanupEnvironment(env, result)
 		return result
+	case Callable:
+		return fn.Call(args)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// required is the number of parameters without a default.
+func (f *Function) required() int {
+	for i := range f.Parameters {
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			return i
+		}
+	}
+	return len(f.Parameters)
+}
+
+// ArityError returns the error for a call passing got arguments to a
+// function with params parameters, the first required of which have no
+// default, that collects any further ones when variadic. It is nil when
+// the call fits.
+func ArityError(required, params int, variadic bool, got int) *Error {
+	if got >= required && (variadic || got <= params) {
+		return nil
+	}
+	want := fmt.Sprintf("=%d", params)
+	if variadic {
+		want = fmt.Sprintf(" at least %d", required)
+	} else if required < params {
+		want = fmt.Sprintf("=%d to %d", required, params)
+	}
+	return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("wrong number of arguments: want%s, got=%d", want, got)}
+}
+
+// SpreadError reports ...val in a call where val is not an array.
+func SpreadError(val Object) *Error {
+	return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("cannot spread %s; expected an array", val.Type())}
+}
+
 func unwrapReturnValue(obj Object) Object {
 	if rv, ok := obj.(*ReturnValue); ok {
 		return rv.Value
@@ -557,10 +770,14 @@ func evalBangOperatorExpression(right Object) Object {
 }
 
 func evalMinusPrefixOperatorExpression(right Object) Object {
-	if right.Type() != INTEGER_OBJ {
+	switch right := right.(type) {
+	case *Integer:
+		return &Integer{Value: -right.Value}
+	case *Float:
+		return &Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	return &Integer{Value: -right.(*Integer).Value}
 }
 
 // --- Infix Expressions ---
@@ -570,6 +787,10 @@ func evalInfixExpression(op string, left, right Object) Object {
 	case left.Type() == INTEGER_OBJ && right.Type() == INTEGER_OBJ:
 		return evalIntegerInfixExpression(op, left, right)
 
+	case isNumeric(left) && isNumeric(right):
+		// at least one side is a float, so promote the other
+		return evalFloatInfixExpression(op, left, right)
+
 	case left.Type() == STRING_OBJ && right.Type() == STRING_OBJ:
 		lstr := left.(*String).Value
 		rstr := right.(*String).Value
@@ -654,6 +875,10 @@ func evalInfixExpression(op string, left, right Object) Object {
 			return newError("unknown operator for int and char: %s", op)
 		}
 
+	case left.Type() == ENUM_OBJ && right.Type() == ENUM_OBJ && (op == "==" || op == "!="):
+		equal := left.(*EnumValue).Equal(right.(*EnumValue))
+		return nativeBoolToBooleanObject(equal == (op == "=="))
+
 	case op == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case op == "!=":
@@ -705,6 +930,59 @@ func evalIntegerInfixExpression(op string, left, right Object) Object {
 	}
 }
 
+func evalFloatInfixExpression(op string, left, right Object) Object {
+	lv := toFloat(left)
+	rv := toFloat(right)
+	switch op {
+	case "+":
+		return &Float{Value: lv + rv}
+	case "-":
+		return &Float{Value: lv - rv}
+	case "*":
+		return &Float{Value: lv * rv}
+	case "/":
+		if rv == 0 {
+			return newError("division by zero")
+		}
+		return &Float{Value: lv / rv}
+	case "%":
+		if rv == 0 {
+			return newError("modulo by zero")
+		}
+		return &Float{Value: math.Mod(lv, rv)}
+	case "<":
+		return nativeBoolToBooleanObject(lv < rv)
+	case ">":
+		return nativeBoolToBooleanObject(lv > rv)
+	case "<=":
+		return nativeBoolToBooleanObject(lv <= rv)
+	case ">=":
+		return nativeBoolToBooleanObject(lv >= rv)
+	case "==":
+		return nativeBoolToBooleanObject(lv == rv)
+	case "!=":
+		return nativeBoolToBooleanObject(lv != rv)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
+}
+
+func isNumeric(obj Object) bool {
+	t := obj.Type()
+	return t == INTEGER_OBJ || t == FLOAT_OBJ
+}
+
+// toFloat widens an Integer or Float to float64.
+func toFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *Float:
+		return obj.Value
+	}
+	return 0
+}
+
 // --- Utility ---
 
 func newError(format string, a ...interface{}) *Error {
@@ -717,12 +995,23 @@ func isError(obj Object) bool {
 
 func evalStructLiteral(node *ast.StructLiteral, env *Environment) Object {
 	properties := make(map[string]Object)
+	var genericFields map[string]string
+	if node.TypeParams != nil {
+		genericFields = make(map[string]string)
+	}
 	for key, valueNode := range node.Pairs {
 		// If this is a generic struct definition, fields whose type/value
-		// match the generic param should be evaluated as placeholders (NULL)
-		if node.GenericType != "" {
-			if ident, ok := valueNode.(*ast.Identifier); ok && ident.Value == node.GenericType {
-				properties[key] = NULL
+		// is one of the type params are evaluated as placeholders (NULL)
+		if ident, ok := valueNode.(*ast.Identifier); ok && isTypeParam(node.TypeParams, ident.Value) {
+			properties[key] = NULL
+			genericFields[key] = ident.Value
+			continue
+		}
+		// int, float and string are also conversion builtins, so a field
+		// typed with one of them must not pick up the function
+		if ident, ok := valueNode.(*ast.Identifier); ok {
+			if zero := typeZeroValue(ident.Value); zero != nil {
+				properties[key] = zero
 				continue
 			}
 		}
@@ -733,19 +1022,79 @@ func evalStructLiteral(node *ast.StructLiteral, env *Environment) Object {
 		}
 		properties[key] = value
 	}
-	return &Struct{Properties: properties}
+	strct := &Struct{Properties: properties, TypeParams: node.TypeParams, GenericFields: genericFields}
+	if node.TypeParams != nil {
+		strct.GenericTypes = make(map[string]*TypeArg)
+	}
+	for _, embedNode := range node.Embeds {
+		base := Eval(embedNode, env)
+		if isError(base) {
+			return base
+		}
+		if errObj := Embed(strct, base, EmbedName(embedNode)); errObj != nil {
+			return errObj
+		}
+	}
+	return strct
 }
 
 func evalPropertyAccessExpression(object Object, property *ast.Identifier) Object {
+	if mod, ok := object.(*Module); ok {
+		return mod.Member(property.Value)
+	}
+	if ex, ok := object.(*Exception); ok {
+		if val, ok := ex.Property(property.Value); ok {
+			return val
+		}
+		return newError("exception has no field %s", property.Value)
+	}
+	if enum, ok := object.(*EnumType); ok {
+		return enum.Member(property.Value)
+	}
+	if val, ok := object.(*EnumValue); ok {
+		if field, ok := val.Property(property.Value); ok {
+			return field
+		}
+		return newError("%s has no field %s", val.Inspect(), property.Value)
+	}
+	if obj, ok := object.(Fielded); ok {
+		if val, ok := obj.Property(property.Value); ok {
+			return val
+		}
+		return newError("%s has no field %s", strings.ToLower(string(obj.Type())), property.Value)
+	}
 	strct, ok := object.(*Struct)
 	if !ok {
 		return newError("property access not supported on %s", object.Type())
 	}
+	contentsMu.RLock()
 	val, ok := strct.Properties[property.Value]
+	destroyed := strct.destroyed
+	contentsMu.RUnlock()
+	if destroyed {
+		return strct.useAfterDestroy()
+	}
 	if !ok {
 		return NULL
 	}
-	return val
+	return bindReceiver(val, strct)
+}
+
+// bindReceiver returns a copy of a method read from receiver in which self
+// and this refer to the receiver. Other values are returned unchanged.
+func bindReceiver(val Object, receiver Object) Object {
+	if m, ok := val.(Method); ok {
+		return m.Bind(receiver)
+	}
+	fn, ok := val.(*Function)
+	if !ok || fn.Native != nil {
+		return val
+	}
+	env := NewEnclosedEnvironment(fn.Env)
+	env.Set("self", receiver)
+	env.Set("this", receiver)
+	return &Function{Parameters: fn.Parameters, Body: fn.Body, Env: env, TypeParams: fn.TypeParams, ParamTypes: fn.ParamTypes,
+		Defaults: fn.Defaults, Rest: fn.Rest, Generator: fn.Generator}
 }
 
 func evalForStatement(node *ast.ForStatement, env *Environment) Object {
@@ -755,6 +1104,10 @@ func evalForStatement(node *ast.ForStatement, env *Environment) Object {
 	}
 	var result Object
 	for {
+		if errObj := loopEnv.task.check(); errObj != nil {
+			cleanupEnvironment(loopEnv, nil)
+			return errObj
+		}
 		if node.Condition != nil {
 			cond := Eval(node.Condition, loopEnv)
 			if isError(cond) {
@@ -797,83 +1150,194 @@ func evalIncExpression(node *ast.PrefixExpression, env *Environment) Object {
 		return newError("++ only integer")
 	}
 	newVal := &Integer{Value: intVal.Value + 1}
-	env.Set(ident.Value, newVal)
+	env.Assign(ident.Value, newVal)
 	return newVal
 }
 
 func instantiateStruct(s *Struct, className string) *Struct {
+	contentsMu.RLock()
+	defer contentsMu.RUnlock()
 	newProps := make(map[string]Object)
 	for k, v := range s.Properties {
 		newProps[k] = v
 	}
 	// copy generic types map
-	var gt map[string]string
+	var gt map[string]*TypeArg
 	if s.GenericTypes != nil {
-		gt = make(map[string]string)
+		gt = make(map[string]*TypeArg)
 		for k, v := range s.GenericTypes {
 			gt[k] = v
 		}
 	}
-	return &Struct{Properties: newProps, GenericTypes: gt, ClassName: className}
+	return &Struct{Properties: newProps, TypeParams: s.TypeParams, GenericTypes: gt, GenericFields: s.GenericFields,
+		ClassName: className, Embeds: s.Embeds}
 }
 
-func callConstructor(instance *Struct, args []Object) {
+// callConstructor runs the field named after the struct's type, if any,
+// with the new instance as self, on behalf of task t.
+func callConstructor(t *Task, instance *Struct, args []Object) Object {
 	if instance.ClassName == "" {
-		return
+		return nil
 	}
-	if initProp, exists := instance.Properties[instance.ClassName]; exists {
+	contentsMu.RLock()
+	initProp, exists := instance.Properties[instance.ClassName]
+	contentsMu.RUnlock()
+	if exists {
 		if fn, ok := initProp.(*Function); ok {
-			applyFunction(fn, args, "")
+			if result := applyFunctionAs(t, bindReceiver(fn, instance), args); isError(result) {
+				return result
+			}
+			return nil
 		}
 	}
+	if len(args) > 0 {
+		return newError("%s has no constructor", instance.ClassName)
+	}
+	return nil
 }
 
+// cleanupEnvironment destroys the instances declared in env, except the one
+// being returned. Values that were passed in or assigned are left alone.
 func cleanupEnvironment(env *Environment, exclude Object) {
-	for _, obj := range env.store {
+	env.mu.RLock()
+	owned := env.owned
+	env.mu.RUnlock()
+	for _, obj := range owned {
 		if obj == exclude {
 			continue
 		}
-		clearObjectMemory(obj)
+		clearObjectMemory(env.task, obj)
 	}
 }
 
-func clearObjectMemory(obj Object) {
+// clearObjectMemory runs the destructors of a struct declared in a scope
+// that has ended. Closures can still hold the struct, so it is marked
+// destroyed rather than emptied, and reading or writing its fields is an
+// error from then on.
+func clearObjectMemory(t *Task, obj Object) {
 	switch val := obj.(type) {
 	case *Struct:
-		if val.ClassName != "" && val.Properties != nil {
-			if delProp, exists := val.Properties["~" + val.ClassName]; exists {
-				if fn, ok := delProp.(*Function); ok {
-					applyFunction(fn, []Object{}, "")
-				}
+		contentsMu.RLock()
+		destroyed := val.destroyed
+		contentsMu.RUnlock()
+		if destroyed {
+			return
+		}
+		if val.ClassName != "" {
+			runDestructors(t, val, val)
+		}
+		contentsMu.Lock()
+		val.destroyed = true
+		contentsMu.Unlock()
+	}
+}
+
+func (s *Struct) useAfterDestroy() *Error {
+	return newError("use after destroy: %s went out of scope", s.ClassName)
+}
+
+// evalAssignment stores val into an assignable expression: a variable, a
+// struct field or an element of an array, hash or string.
+func evalAssignment(left ast.Expression, val Object, env *Environment) Object {
+	switch left := left.(type) {
+	case *ast.Identifier:
+		env.Assign(left.Value, val)
+		return val
+	case *ast.PropertyAccessExpression:
+		object := Eval(left.Object, env)
+		if isError(object) {
+			return object
+		}
+		if left.Token.Literal == "->" {
+			ptr, isPtr := object.(*Pointer)
+			if !isPtr {
+				return newError("-> applied to non-pointer in assignment")
 			}
+			return ptr.Set(left.Property.Value, val)
+		}
+		if object.Type() == POINTER_OBJ {
+			return newError(". applied to pointer in assignment")
+		}
+		return evalPropertyAssignment(object, left.Property, val)
+	case *ast.IndexExpression:
+		container := Eval(left.Left, env)
+		if isError(container) {
+			return container
+		}
+		index := Eval(left.Index, env)
+		if isError(index) {
+			return index
 		}
-		val.Properties = nil
-	case *Pointer:
-		if val.Value != nil && val.Value != NULL {
-			if strct, ok := val.Value.(*Struct); ok {
-				clearObjectMemory(strct)
+		if str, ok := container.(*String); ok {
+			// strings are immutable, so build the new value and store it
+			// back into whatever held the old one
+			updated := evalStringIndexAssignment(str, index, val)
+			if isError(updated) {
+				return updated
+			}
+			if res := evalAssignment(left.Left, updated, env); isError(res) {
+				return res
 			}
-			val.Value = NULL
+			return val
 		}
+		return evalIndexAssignment(container, index, val)
 	}
+	return newError("invalid lvalue in assignment")
+}
+
+// evalIndexAssignment updates an array element or hash entry in place.
+func evalIndexAssignment(container, index, val Object) Object {
+	contentsMu.Lock()
+	defer contentsMu.Unlock()
+	switch container := container.(type) {
+	case *Array:
+		idx, ok := index.(*Integer)
+		if !ok {
+			return newError("array index must be integer")
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(container.Elements)) {
+			return newError("array index out of range: %d (len %d)", idx.Value, len(container.Elements))
+		}
+		container.Elements[idx.Value] = val
+		return val
+	case *Hash:
+		hashable, ok := index.(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		container.Pairs[hashable.HashKey()] = HashPair{Key: index, Value: val}
+		return val
+	default:
+		return newError("index assignment not supported on %s", container.Type())
+	}
+}
+
+// evalStringIndexAssignment returns a copy of str with the byte at index
+// replaced by the string val.
+func evalStringIndexAssignment(str *String, index, val Object) Object {
+	idx, ok := index.(*Integer)
+	if !ok {
+		return newError("index must be integer")
+	}
+	if idx.Value < 0 || idx.Value >= int64(len(str.Value)) {
+		return newError("string index out of range: %d (len %d)", idx.Value, len(str.Value))
+	}
+	repl, ok := val.(*String)
+	if !ok {
+		return newError("cannot assign %s into a string", val.Type())
+	}
+	return &String{Value: str.Value[:idx.Value] + repl.Value + str.Value[idx.Value+1:]}
 }
 
 func evalPropertyAssignment(object Object, property *ast.Identifier, val Object) Object {
 	if s, ok := object.(*Struct); ok {
-		// type-check against generic types
-		if s.GenericTypes != nil {
-			// check if the property's original value was a generic placeholder
-			if existing, exists := s.Properties[property.Value]; exists {
-				if existing.Type() == NULL_OBJ {
-					// property was unset (generic placeholder), check against resolved type
-					for _, resolvedType := range s.GenericTypes {
-						if resolvedType != "" && !typeMatchesGeneric(val, resolvedType) {
-							return newError("generic type mismatch: property %s expects %s, got %s",
-								property.Value, resolvedType, val.Type())
-						}
-					}
-				}
-			}
+		if errObj := checkGenericField(s, property.Value, val); errObj != nil {
+			return errObj
+		}
+		contentsMu.Lock()
+		defer contentsMu.Unlock()
+		if s.destroyed {
+			return s.useAfterDestroy()
 		}
 		s.Properties[property.Value] = val
 		return val
@@ -881,17 +1345,91 @@ func evalPropertyAssignment(object Object, property *ast.Identifier, val Object)
 	return newError("property assignment not supported on %s", object.Type())
 }
 
-// typeMatchesGeneric checks if an object matches a generic type name
-func typeMatchesGeneric(obj Object, typeName string) bool {
-	switch typeName {
-	case "int":
-		return obj.Type() == INTEGER_OBJ
-	case "string":
-		return obj.Type() == STRING_OBJ
-	case "bool":
-		return obj.Type() == BOOLEAN_OBJ
-	default:
-		return true // unknown types pass through
+// --- Shared with the bytecode VM ---
+
+// EvalInfix applies a binary operator using the tree-walker's rules, so the
+// VM can fall back to it for anything beyond its integer fast path.
+func EvalInfix(op string, left, right Object) Object {
+	return evalInfixExpression(op, left, right)
+}
+
+// EvalPrefix applies a prefix operator using the tree-walker's rules.
+func EvalPrefix(op string, right Object) Object {
+	return evalPrefixExpression(op, right)
+}
+
+// EvalIndex indexes into an array, string or hash.
+func EvalIndex(left, index Object) Object {
+	return evalIndexExpression(left, index)
+}
+
+// SetIndex stores val into an array element or hash entry in place. Strings
+// are values, so the VM handles them with EvalStringSetIndex instead.
+func SetIndex(container, index, val Object) Object {
+	return evalIndexAssignment(container, index, val)
+}
+
+// EvalStringSetIndex returns str with the byte at index replaced by val.
+func EvalStringSetIndex(str *String, index, val Object) Object {
+	return evalStringIndexAssignment(str, index, val)
+}
+
+// GetProperty reads a struct field; arrow selects -> over . access.
+func GetProperty(object Object, name string, arrow bool) Object {
+	if arrow {
+		ptr, ok := object.(*Pointer)
+		if !ok {
+			return newError("-> applied to non-pointer")
+		}
+		return ptr.Get(name)
+	}
+	if object.Type() == POINTER_OBJ {
+		return newError(". applied to pointer")
+	}
+	return evalPropertyAccessExpression(object, &ast.Identifier{Value: name})
+}
+
+// SetProperty assigns a struct field; arrow selects -> over . access.
+func SetProperty(object Object, name string, arrow bool, val Object) Object {
+	if arrow {
+		ptr, ok := object.(*Pointer)
+		if !ok {
+			return newError("-> applied to non-pointer in assignment")
+		}
+		return ptr.Set(name, val)
 	}
+	if object.Type() == POINTER_OBJ {
+		return newError(". applied to pointer in assignment")
+	}
+	return evalPropertyAssignment(object, &ast.Identifier{Value: name}, val)
+}
+
+// IsTruthy reports whether obj counts as true in a condition.
+func IsTruthy(obj Object) bool {
+	return isTruthy(obj)
+}
+
+// NativeBool returns the shared TRUE or FALSE object.
+func NativeBool(input bool) *Boolean {
+	return nativeBoolToBooleanObject(input)
+}
+
+// TypeZeroValue returns the zero value for the primitive type name, or nil.
+func TypeZeroValue(name string) Object {
+	return typeZeroValue(name)
+}
+
+// IsTypeParam reports whether name is one of the parameters of a generic.
+func IsTypeParam(params []*ast.TypeParam, name string) bool {
+	return isTypeParam(params, name)
+}
+
+// Throw converts the operand of a throw statement into a raised error.
+func Throw(val Object) *Error {
+	return throwValue(val).(*Error)
 }
 
+// NewError builds an *Error without location information.
+func NewError(format string, a ...interface{}) *Error {
+	return newError(format, a...)
+}
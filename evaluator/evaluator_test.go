@@ -3,7 +3,12 @@ package evaluator
 import (
 	"Nikium/lexer"
 	"Nikium/parser"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -283,6 +288,47 @@ addTwo(2);
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestLexicalScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"x = 1; f = fn() { x = x + 1; }; f(); f(); x", 3},
+		{"x = 1; f = fn() { let x = 10; x = x + 1; x }; f() + x", 12},
+		{"x = 1; if (true) { x = 2; } x", 2},
+		{"x = 0; for (let i = 0; i < 4; ++i) { x = x + i; } x", 6},
+		{"i = 7; for (let i = 0; i < 2; ++i) { } i", 7},
+		{"x = 1; f = fn() { y = 5; }; f(); x", 1},
+		{`
+counter = fn() {
+  let n = 0;
+  fn() { n = n + 1; n }
+};
+c = counter();
+c(); c();
+c()`, 3},
+		{`
+make = fn() {
+  let n = 0;
+  inc = fn() { ++n; };
+  get = fn() { n };
+  inc(); inc();
+  get()
+};
+make()`, 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	// y was declared inside f, so it must not be visible outside
+	evaluated := testEval("f = fn() { y = 5; }; f(); y")
+	if errObj, ok := evaluated.(*Error); !ok || !strings.HasPrefix(errObj.Message, "identifier not found: y") {
+		t.Errorf("expected y to stay local to f. got=%T (%+v)", evaluated, evaluated)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -349,6 +395,1210 @@ func TestStringIndexing(t *testing.T) {
 	}
 }
 
+func TestFloatExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1 + 2.5", 3.5},
+		{"7 / 2.0", 3.5},
+		{"7 / 2", 3},
+		{"10 % 3.5", 3.0},
+		{"0.1 * 3 > 0.3", true},
+		{"2 == 2.0", true},
+		{"1.5 < 1", false},
+		{"float(3)", 3.0},
+		{`float("2.5") * 2`, 5.0},
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{`int("42")`, 42},
+		{"round(2.345, 2)", 2.35},
+		{"round(2.5)", 3.0},
+		{"float_format(19.999, 2)", "20.00"},
+		{"str(2.0)", "2.0"},
+		{"math_sqrt(16)", 4.0},
+		{"type(1.0)", "FLOAT"},
+		{"p = struct { n: int, f: float }; p.f", 0.0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`a = [1, 2, 3]; a[0] = 10; a[0] + a[2]`, 13},
+		{`a = [1, 2, 3]; b = a; b[1] = 5; a[1]`, 5},
+		{`m = [[1, 2], [3, 4]]; m[1][0] = 30; m[1][0]`, 30},
+		{`h = {"a": 1}; h["b"] = 2; h["a"] = h["a"] + 5; h["a"] + h["b"]`, 8},
+		{`h = {"xs": [0]}; h["xs"][0] = 7; h["xs"][0]`, 7},
+		{`a = [0]; a[0] = 4`, 4},
+		{`s = "cat"; s[0] = "b"; s`, "bat"},
+		{`w = ["dog"]; w[0][2] = "t"; w[0]`, "dot"},
+		{`P = struct { items: [1, 2] }; P.items[1] = 9; P.items[1]`, 9},
+		{`a = [1]; b = a; append(a, 2); len(b) * 10 + b[1]`, 22},
+		{`a = [1, 2, 3]; b = a; pop(a) * 10 + len(b)`, 32},
+		{`a = [1]; c = push(a, 2); append(a, 3); c[1]`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`a = [1]; a[1] = 2;`, "array index out of range: 1 (len 1)"},
+		{`a = [1]; a["x"] = 2;`, "array index must be integer"},
+		{`h = {}; h[[1]] = 2;`, "unusable as hash key: ARRAY"},
+		{`s = "ab"; s[0] = 1;`, "cannot assign INTEGER into a string"},
+		{`x = 5; x[0] = 1;`, "index assignment not supported on INTEGER"},
+		{`pop([]);`, "pop: array is empty"},
+		{`append(5, 1);`, "append: first argument must be an array"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if !strings.HasPrefix(errObj.Message, tt.expectedMessage) {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 + true; } catch (e) { e.kind }`, "RuntimeError"},
+		{`try { int_parse("abc"); } catch (e) { e.kind }`, "ParseError"},
+		{`try { file_read("/no/such/file"); } catch (e) { e.kind }`, "IOError"},
+		{`try { len(1, 2); } catch (e) { e.kind }`, "ArgumentError"},
+		{`try { throw "boom"; } catch (e) { e.message }`, "boom"},
+		{`try { throw 41; } catch (e) { e.value + 1 }`, 42},
+		{`try { throw error("ValueError", "bad"); } catch (e) { e.kind + ":" + e.message }`, "ValueError:bad"},
+		{"x = 1;\ntry {\n  x = x + true;\n} catch (e) { e.line * 100 + e.column }", 309},
+		{`x = 0; try { x = 1; } finally { x = x + 10; } x`, 11},
+		{`x = 0; try { throw "a"; } catch (e) { x = 1; } finally { x = x + 10; } x`, 11},
+		{`f = fn() { try { return 1; } finally { return 2; } }; f()`, 2},
+		{`log = ""; f = fn() { try { return 1; } finally { log = "done"; } }; f(); log`, "done"},
+		{`try { try { throw "in"; } finally { 0; } } catch (e) { e.message }`, "in"},
+		{`try { try { throw "in"; } catch (e) { throw e; } } catch (e) { e.message }`, "in"},
+		{`i = 0; n = 0; while (true) { i = i + 1; try { if (i == 3) { break; } } finally { n = n + 1; } } n`, 3},
+		{`try { missing(); } catch { "caught" }`, "caught"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+
+	evaluated := testEval(`throw error("IOError", "disk gone");`)
+	errObj, ok := evaluated.(*Error)
+	if !ok {
+		t.Fatalf("uncaught throw should be an error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Inspect() != "IOError: disk gone on line 1, col 1" {
+		t.Errorf("wrong uncaught error. got=%q", errObj.Inspect())
+	}
+}
+
+func TestHeapPointers(t *testing.T) {
+	node := `
+log = "";
+Node = struct {
+  val: 0,
+  ratio: 0.5,
+  ok: true,
+  items: [],
+  Node: fn() { log = log + "c"; },
+  ~Node: fn() { log = log + "d"; }
+};
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{node + `n = new Node(); n->val = 41; n->val + 1`, 42},
+		{node + `n = new Node(); n->ratio = n->ratio * 4.0; int(n->ratio)`, 2},
+		{node + `n = new Node(); n->items = push(n->items, 7); n->items[0]`, 7},
+		{node + `n = new Node(); m = n; m->val = 5; n->val`, 5},
+		{node + `n = new Node(); f = fn(p) { p->val = 9; }; f(n); n->val`, 9},
+		{node + `n = new Node(); delete n; log`, "cd"},
+		{node + `Node* n; delete n; log`, ""},
+		{node + `n = new Node(); delete n; n->val`, "use after free: pointer to Node was deleted"},
+		{node + `n = new Node(); m = n; delete n; m->val = 1;`, "use after free: pointer to Node was deleted"},
+		{node + `n = new Node(); delete n; delete n;`, "double free: pointer to Node was already deleted"},
+		{node + `n = new Node(); n->extra = 3; n->extra * 2`, 6},
+		{node + `n = new Node(); n->cb = fn() { 2 }; n->cb()`, 2},
+		{node + `n = new Node(); n->cb = fn() { self->val + 1 }; n->val = 4; n->cb()`, 5},
+		{node + `n = new Node(); m = n; n->extra = 8; m->extra`, 8},
+		{node + `n = new Node(); n->name = "x"; match (n) { Node{name: s} => s, _ => "" }`, "x"},
+		{node + `n = new Node(); n->extra = 1; delete n; n->extra`, "use after free: pointer to Node was deleted"},
+		{`delete 5;`, "delete applied to non-pointer INTEGER"},
+		{`generic<T> Box = struct { value: T }; b = new Box<int>(); b->value = "s";`,
+			"generic type mismatch: property value expects int, got STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	counter := `
+Counter = struct {
+  n: 0,
+  add: fn(k) { self.n = self.n + k; return self; },
+  get: fn() { return this.n; },
+  adder: fn() { return fn(k) { self.n = self.n + k; }; }
+};
+`
+	node := `
+log = "";
+Node = struct {
+  val: 0,
+  Node: fn() { self.val = 10; },
+  ~Node: fn() { log = log + "bye " + str(self.val); },
+  bump: fn() { self->val = self->val + 1; return self->val; }
+};
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{counter + `c = Counter; c.add(2); c.add(3); c.get()`, 5},
+		{counter + `c = Counter; c.add(1).add(2).get()`, 3},
+		{counter + `c = Counter; f = c.adder(); f(4); c.n`, 4},
+		{counter + `c = Counter; g = c.get; c.n = 7; g()`, 7},
+		{counter + `c = Counter; d = struct { n: 100, get: c.get }; d.get()`, 100},
+		{node + `n = new Node(); n->bump()`, 11},
+		{node + `n = new Node(); n->bump(); delete n; log`, "bye 11"},
+		{node + `f = fn() { Node n; n.val = 3; }; f(); log`, "bye 3"},
+		{`f = fn() { return self; }; f()`, "identifier not found: self"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	describe := `
+Point = struct { x: 0, y: 0 };
+describe = fn(v) {
+  match v {
+    0 => "zero",
+    -1 => "minus one",
+    "hi" => "greeting",
+    true => "yes",
+    [] => "empty",
+    [x] => "one " + str(x),
+    [first, ...rest] => str(first) + " then " + str(rest),
+    {"kind": "circle", "r": r} => "circle " + str(r),
+    Point { x: 0, y } => "on y axis at " + str(y),
+    { name } => "named " + name,
+    n if type(n) == "INTEGER" && n > 100 => "big",
+    _ => { let t = type(v); "other " + t }
+  }
+};
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{describe + `describe(0)`, "zero"},
+		{describe + `describe(-1)`, "minus one"},
+		{describe + `describe("hi")`, "greeting"},
+		{describe + `describe(true)`, "yes"},
+		{describe + `describe([])`, "empty"},
+		{describe + `describe([7])`, "one 7"},
+		{describe + `describe([1, 2, 3])`, "1 then [2, 3]"},
+		{describe + `describe({"kind": "circle", "r": 2})`, "circle 2"},
+		{describe + `describe({"kind": "square"})`, "other HASH"},
+		{describe + `Point p; p.y = 5; describe(p)`, "on y axis at 5"},
+		{describe + `Point p; p.x = 1; describe(p)`, "other STRUCT"},
+		{describe + `describe(struct { x: 0, y: 3 })`, "other STRUCT"},
+		{describe + `q = new Point(); q->y = 4; describe(q)`, "on y axis at 4"},
+		{describe + `describe(struct { name: "box" })`, "named box"},
+		{describe + `describe(500)`, "big"},
+		{describe + `describe(5)`, "other INTEGER"},
+		{describe + `describe(2.5)`, "other FLOAT"},
+		// bindings stay in their arm, and return leaves the function
+		{`x = "outer"; match 1 { x => x }; x`, "outer"},
+		{`f = fn() { match [1] { [a] => { return "early"; } }; return "late"; }; f()`, "early"},
+		{`match 3 { 1 => "a", 2 => "b" }`, "no match arm for 3"},
+		{`match 3 { n if n > 5 => "a" }`, "no match arm for 3"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*Error); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error. want=%q, got=%q", tt.expected, errObj.Message)
+			}
+			continue
+		}
+		testStringObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestGenericParams(t *testing.T) {
+	decls := `
+generic<K, V> Pair = struct { key: K, value: V };
+generic<T> Box = struct { v: T };
+generic<T: Comparable> max = fn(a: T, b: T) { if (a > b) { return a; } return b; };
+generic<K: Hashable, V> put = fn(h, k: K, v: V) { h[k] = v; return h; };
+generic<T> count = fn(xs: []T) { return len(xs); };
+Shape = interface { area() };
+Square = struct { side: 2, area: fn() { self.side * self.side } };
+Point = struct { x: 0 };
+generic<S: Shape> Holder = struct { shape: S };
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{decls + `Pair<string, int> p; p.key = "a"; p.value = 1; p.key + str(p.value)`, "a1"},
+		{decls + `Pair<string, int> p; p.key = 1;`, "generic type mismatch: property key expects string, got INTEGER"},
+		{decls + `Pair<string, int> p; p.value = 1; p.value = "x";`, "generic type mismatch: property value expects int, got STRING"},
+		{decls + `p = new Pair<int, float>(); p->value = 2; p->value`, 2},
+		{decls + `Pair<int> p;`, "Pair expects 2 type arguments, got 1"},
+		{decls + `Point<int> p;`, "Point is not generic"},
+		{decls + `Pair<string, int> p; Box<Pair<string, int>> b; b.v = p; 1`, 1},
+		{decls + `Pair<int, int> p; Box<Pair<string, int>> b; b.v = p;`, "generic type mismatch: property v expects Pair<string, int>, got STRUCT"},
+		{decls + `Box<Point> b; b.v = 1;`, "generic type mismatch: property v expects Point, got INTEGER"},
+		{decls + `Box<[]int> b; b.v = [1, 2]; len(b.v)`, 2},
+		{decls + `Box<[]int> b; b.v = [1, "a"];`, "generic type mismatch: property v expects []int, got ARRAY"},
+		{decls + `max<int>(3, 9)`, 9},
+		{decls + `max<string>("a", 1)`, "generic type mismatch: expected string, got INTEGER"},
+		{decls + `max<Point>(1, 2)`, "Point does not satisfy Comparable, required by T of max"},
+		{decls + `h = put<string, int>({}, "a", 1); h["a"]`, 1},
+		{decls + `put<string, int>({}, "a", "b")`, "generic type mismatch: expected int, got STRING"},
+		{decls + `put<[]int, int>({}, [1], 1)`, "[]int does not satisfy Hashable, required by K of put"},
+		{decls + `count<int>([1, 2, 3])`, 3},
+		{decls + `count<int>([1, "a"])`, "generic type mismatch: expected []int, got ARRAY"},
+		{decls + `Holder<Square> h; Square sq; h.shape = sq; h.shape.area()`, 4},
+		{decls + `Holder<Point> h;`, "Point does not satisfy Shape, required by S of Holder"},
+		{decls + `len<int>([1])`, "len is not generic"},
+		{`Comparable = interface { compare(o) }; generic<T: Comparable> Box = struct { v: T }; Box<int> b;`,
+			"int does not satisfy Comparable, required by T of Box"},
+		{`Comparable = interface { compare(o) }; P = struct { compare: fn(o) { 0 } }; generic<T: Comparable> Box = struct { v: T }; Box<P> b; 1`, 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`t = 0; for (x in [1, 2, 3]) { t = t + x; } t`, 6},
+		{`t = 0; for (i, x in [5, 6]) { t = t + i * x; } t`, 6},
+		{`t = 0; for (k, v in {"a": 1, "b": 2}) { t = t + v; } t`, 3},
+		{`s = ""; for (k in {"a": 1}) { s = s + k; } s`, "a"},
+		{`s = ""; for (c in "abc") { s = c + s; } s`, "cba"},
+		{`t = 0; for (i in range(5)) { t = t + i; } t`, 10},
+		{`t = 0; for (i in range(10, 0, -3)) { t = t + i; } t`, 22},
+		{`t = 0; for (i in range(1000000000)) { if (i == 3) { break; } t = t + i; } t`, 3},
+		{`t = 0; for (i in range(6)) { if (i % 2 == 0) { continue; } t = t + i; } t`, 9},
+		{`xs = [1, 2]; for (x in xs) { xs[0] = 10; xs = push(xs, x); } len(xs)`, 4},
+		{`x = 7; for (x in [1]) { } x`, 7},
+		{`f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()`, 20},
+		{`t = 0; for (a in [1, 2]) { for (b in [10, 20]) { t = t + a * b; } } t`, 90},
+		{`for (x in 5) { }`, "cannot iterate over INTEGER"},
+		{`range(1, 2, 0)`, "range: step must not be 0"},
+		{`range("a")`, "range: expected integers, got STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestTemplateStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`name = "Ada"; age = 36; "Hello ${name}, you are ${age + 1}"`, "Hello Ada, you are 37"},
+		{`"${[1, 2]} ${1.5} ${true} ${ {"a": 1}["a"] }"`, "[1, 2] 1.5 true 1"},
+		{`"outer ${"inner ${1 + 1}"}"`, "outer inner 2"},
+		{`"cost: \${price}"`, "cost: ${price}"},
+		{`"${y()}"`, "identifier not found: y"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*Error); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error. want=%q, got=%q", tt.expected, errObj.Message)
+			}
+			continue
+		}
+		testStringObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`format("%d + %d = %d", 1, 2, 3)`, "1 + 2 = 3"},
+		{`format("[%5d][%-5d][%05d]", 42, 42, -42)`, "[   42][42   ][-0042]"},
+		{`format("%x %x %04x", 255, "hi", 10)`, "ff 6869 000a"},
+		{`format("[%6s][%-6s][%.2s]", "ab", "ab", "hello")`, "[    ab][ab    ][he]"},
+		{`format("%v %v %.2v %v", [1, "a"], 2.0, 3.14159, {"k": true})`, "[1, a] 2.0 3.14 {k: true}"},
+		{`format("100%%")`, "100%"},
+		{`format("%d", "x")`, "format: %d needs an integer, got STRING"},
+		{`format("%d %d", 1)`, "format: missing argument for %d"},
+		{`format("%d", 1, 2)`, "format: 2 arguments for 1 verbs"},
+		{`format("%q", 1)`, "format: unknown verb %q"},
+		{`format("%5", 1)`, "format: unfinished verb at the end of the format"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*Error); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error. want=%q, got=%q", tt.expected, errObj.Message)
+			}
+			continue
+		}
+		testStringObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	count := `count = fn(n) { let i = 0; while (i < n) { yield i; i = i + 1; } }; `
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{count + `g = count(3); next(g) * 100 + next(g) * 10 + next(g)`, 12},
+		{count + `g = count(1); next(g); next(g); next(g)`, nil},
+		{count + `t = 0; for (x in count(5)) { t = t + x; } t`, 10},
+		{count + `t = 0; for (i, x in count(100)) { if (i == 3) { break; } t = t + x; } t`, 3},
+		{count + `t = 0; for (x in count(5)) { if (x % 2 == 0) { continue; } t = t + x; } t`, 4},
+		{count + `g = count(0); for (x in g) { } next(g)`, nil},
+		{count + `type(count(1))`, "GENERATOR"},
+		{`g = fn() { yield 1; return 5; yield 2; }; t = 0; for (x in g()) { t = t + x; } t`, 1},
+		{`g = fn(xs, ...more) { for (x in xs) { yield x; } for (x in more) { yield x; } }; t = 0; for (x in g([1, 2], 3)) { t = t + x; } t`, 6},
+		{`outer = fn() { yield 1; f = fn() { 10 }; yield f(); }; t = 0; for (x in outer()) { t = t + x; } t`, 11},
+		{`g = fn() { let a = 0; let b = 1; while (true) { yield a; let c = a + b; a = b; b = c; } }; f = g(); for (let i = 0; i < 10; ++i) { next(f); } next(f)`, 55},
+		{count + `g = count(10); next(g); task = spawn(fn() { next(g) + next(g) }); await(task)`, 3},
+		{`g = fn() { yield 1; throw "boom"; }; t = 0; try { for (x in g()) { t = t + x; } } catch (e) { t = t + 10; } t`, 11},
+		{`g = fn() { yield 1; throw "boom"; }; it = g(); next(it); next(it)`, "boom"},
+		{`it = struct { next: fn() { throw "bad"; } }; for (x in it) { }`, "bad"},
+		{`next(5)`, "expected a generator or a struct with a next method, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestIteratorProtocol(t *testing.T) {
+	counter := `counter = fn(n) { let i = 0; return struct { next: fn() { if (i >= n) { return; } i = i + 1; return i; } }; }; `
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{counter + `t = 0; for (x in counter(4)) { t = t + x; } t`, 10},
+		{counter + `c = counter(3); next(c); next(c)`, 2},
+		{counter + `t = 0; for (i, x in counter(3)) { t = t + i; } t`, 3},
+		{`S = struct { k: 0, next: fn() { if (self.k >= 3) { return; } self.k = self.k + 1; return self.k; } }; S s; t = 0; for (x in s) { t = t + x; } t`, 6},
+		{`P = struct { k: 0, next: fn() { if (self->k >= 3) { return; } self->k = self->k + 1; return self->k; } }; p = new P(); t = 0; for (x in p) { t = t + x; } t`, 6},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+// An abandoned generator parked at a yield must not keep its goroutine.
+func TestGeneratorGoroutineLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	testEval(`
+		naturals = fn() { let i = 0; while (true) { yield i; i = i + 1; } };
+		take = fn() { g = naturals(); next(g); next(g) };
+		for (let i = 0; i < 50; ++i) { take(); }
+	`)
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("generator goroutines leaked. before=%d, after=%d", before, n)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`ch = channel(2); send(ch, 1); send(ch, 2); recv(ch) * 10 + recv(ch)`, 12},
+		{`ch = channel(); spawn(fn() { send(ch, 7) }); recv(ch)`, 7},
+		{`ch = channel(1); send(ch, 1); close(ch); recv(ch); recv(ch)`, nil},
+		{`ch = channel(); spawn(fn() { for (i in range(1, 5)) { send(ch, i); } close(ch); }); t = 0; for (v in ch) { t = t + v; } t`, 10},
+		{`ch = channel(3); send(ch, 5); send(ch, 6); close(ch); t = 0; for (i, v in ch) { t = t + i * v; } t`, 6},
+		{`
+			jobs = channel(); results = channel(10);
+			workers = [];
+			for (w in range(3)) {
+				workers = push(workers, spawn(fn() { for (j in jobs) { send(results, j * j); } }));
+			}
+			for (i in range(10)) { send(jobs, i); }
+			close(jobs);
+			for (id in workers) { await(id); }
+			close(results);
+			t = 0; for (r in results) { t = t + r; } t
+		`, 285},
+		{`type(channel())`, "CHANNEL"},
+		{`str(channel(4))`, "channel(4)"},
+		{`ch = channel(); close(ch); send(ch, 1)`, "send: channel is closed"},
+		{`ch = channel(); close(ch); close(ch)`, "close: channel is already closed"},
+		{`channel(-1)`, "channel: capacity must not be negative, got -1"},
+		{`recv(5)`, "recv: expected a channel, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`a = channel(1); r = 0; select { case v = recv(a): r = 1; default: r = 2; } r`, 2},
+		{`a = channel(1); send(a, 5); r = 0; select { case v = recv(a): r = v; default: r = 2; } r`, 5},
+		{`a = channel(1); r = 0; select { case send(a, 3): r = 1; default: r = 2; } r + recv(a)`, 4},
+		{`a = channel(1); send(a, 1); r = 0; select { case send(a, 3): r = 1; default: r = 2; } r`, 2},
+		{`a = channel(); b = channel(); spawn(fn() { send(b, 9) }); r = 0; select { case v = recv(a): r = 1; case v = recv(b): r = v; } r`, 9},
+		{`a = channel(); close(a); r = 0; select { case v = recv(a): r = v; } r`, nil},
+		{`a = channel(1); send(a, 1); v = 7; select { case v = recv(a): v; } v`, 7},
+		{`f = fn(a) { select { case v = recv(a): return v * 2; } 0 }; a = channel(1); send(a, 4); f(a)`, 8},
+		{`a = channel(5); for (i in range(5)) { send(a, i); } t = 0; for (let n = 0; n < 10; ++n) { select { case v = recv(a): t = t + v; default: break; } } t`, 10},
+		{`a = channel(); close(a); select { case send(a, 1): 0; }`, "select: send on a closed channel"},
+		{`select { case v = recv(5): v; }`, "select: expected a channel, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+// Run with -race: tasks assign globals, hash entries, array elements,
+// struct fields and heap fields that the script and the other tasks read
+// at the same time, through len, push, match, implements, embedding and
+// struct declarations as well as plain reads.
+func TestSpawnSharedState(t *testing.T) {
+	input := `
+		shared = {}; counter = 0; slots = [0, 0, 0, 0];
+		Stats = struct { last: 0 }; Stats stats;
+		Node = struct { name: "" }; node = new Node();
+		Lasting = interface { get() };
+		worker = fn(n) {
+			return fn() {
+				for (j in range(20)) {
+					shared[n] = j;
+					counter = counter + 1;
+					slots[n % 4] = slots[(n + 1) % 4] + j;
+					stats.last = n;
+					node->name = str(j);
+					seen = str(shared) + str(slots) + node->name;
+					Stats.last = j;
+					stats.get = fn() { n };
+					Stats fresh;
+					wrapped = struct { ...stats, extra: 1 };
+					grown = push(slots, j);
+					size = len(slots) + len(shared) + len(grown);
+					m = match (stats) { Stats{last: l} => l, _ => 0 };
+					first = match (slots) { [a, ...rest] => a, _ => 0 };
+					isLasting = implements(stats, Lasting) && implements(node, Lasting);
+				}
+				n
+			};
+		};
+		ids = [];
+		for (let i = 0; i < 50; ++i) {
+			ids = push(ids, spawn(worker(i)));
+			counter = counter + 1;
+			peek = len(keys(shared)) + stats.last;
+		}
+		total = 0;
+		for (id in ids) { total = total + await(id); }
+		total * 1000 + len(keys(shared))
+	`
+	testIntegerObject(t, testEval(input), 1225*1000+50)
+}
+
+func TestTaskCancellation(t *testing.T) {
+	spin := `spin = fn() { n = 0; while (true) { n = n + 1; } }; `
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{spin + `id = spawn(spin); try { await_timeout(id, 10); } catch (e) { cancel(id); e.kind }`, "TimeoutError"},
+		{spin + `id = spawn(spin); try { await_timeout(id, 10); } catch (e) { cancel(id); e.message == "await_timeout: task " + str(id.id) + " did not finish within 10 ms" }`, true},
+		{spin + `id = spawn(spin); cancel(id); try { await(id); } catch (e) { e.kind + " " + e.message }`, "CancelledError task cancelled"},
+		{`id = spawn(fn() { for (x in range(1000000000)) { } }); cancel(id); try { await(id); } catch (e) { e.kind }`, "CancelledError"},
+		{`f = fn() { f() }; id = spawn(fn() { time_sleep(20); f() }); cancel(id); try { await(id); } catch (e) { e.kind }`, "CancelledError"},
+		// functions called back by natives, iterators, constructors and
+		// destructors run as part of the calling task
+		{spin + `m = mutex(); id = spawn(fn() { with_lock(m, spin) }); time_sleep(20); cancel(id); try { await(id); } catch (e) { m.lock(); m.unlock(); e.kind }`, "CancelledError"},
+		{spin + `rw = rwmutex(); id = spawn(fn() { with_rlock(rw, spin) }); time_sleep(20); cancel(id); try { await(id); } catch (e) { e.kind }`, "CancelledError"},
+		{spin + `It = struct { next: fn() { spin() } }; It it; id = spawn(fn() { next(it) }); time_sleep(20); cancel(id); try { await(id); } catch (e) { e.kind }`, "CancelledError"},
+		{spin + `It = struct { next: fn() { spin() } }; It it; id = spawn(fn() { for (x in it) { } }); time_sleep(20); cancel(id); try { await(id); } catch (e) { e.kind }`, "CancelledError"},
+		{spin + `Slow = struct { Slow: fn() { spin() } }; id = spawn(fn() { Slow s(); }); time_sleep(20); cancel(id); try { await(id); } catch (e) { e.kind }`, "CancelledError"},
+		{spin + `Slow = struct { ~Slow: fn() { spin() } }; id = spawn(fn() { Slow s; }); time_sleep(20); cancel(id); await(id); id.status`, "done"},
+		{`id = spawn(fn() { 1 }); await_timeout(id, 1000)`, 1},
+		{`id = spawn(fn() { 1 }); await(id); cancel(id)`, false},
+		{`id = spawn(fn() { 1 }); time_sleep(20); cancel(id) == false`, true},
+		{`ids = [spawn(fn() { 1 }), spawn(fn() { 2 }), spawn(fn() { 3 })]; t = 0; for (r in await_all(ids)) { t = t * 10 + r; } t`, 123},
+		{`ids = [spawn(fn() { 1 }), spawn(fn() { throw "no"; })]; try { await_all(ids); } catch (e) { e.message }`, "no"},
+		{`a = spawn(fn() { time_sleep(200); 1 }); b = spawn(fn() { 2 }); await(await_any([a, b]))`, 2},
+		{`await_any([])`, "await_any: no tasks to wait for"},
+		{`task_group([fn() { 1 }, fn() { 2 }])[1]`, 2},
+		{`
+			done = [];
+			slow = fn() { for (let i = 0; i < 1000000000; ++i) { } done = push(done, 1); };
+			try {
+				task_group([slow, fn() { throw "boom"; }, slow]);
+			} catch (e) {
+				e.message + " " + str(len(done))
+			}
+		`, "boom 0"},
+		{`task_group([1])`, "task_group: expected an array of functions, got INTEGER at index 0"},
+		{`await_all(1)`, "await_all: expected an array of tasks, got INTEGER"},
+		{`cancel(1)`, "cancel: expected a task, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestTasks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`add = fn(a, b) { a + b }; await(spawn(add, 2, 3))`, 5},
+		{`sum = fn(...xs) { t = 0; for (x in xs) { t = t + x; } t }; spawn(sum, 1, 2, 3).result()`, 6},
+		{`t = spawn(fn(n) { n * 2 }, 21); t.result() + await(t)`, 84},
+		{`t = spawn(fn() { 1 }); await(t); t.status`, "done"},
+		{`t = spawn(fn() { throw "no"; }); try { await(t); } catch (e) { 0 } t.status`, "failed"},
+		{`ch = channel(); t = spawn(recv, ch); s = t.status; send(ch, 1); s + " " + str(t.result())`, "pending 1"},
+		{`t = spawn(fn() { 1 }); await(t); str(t) == "<task " + str(t.id) + " done>"`, true},
+		{`type(spawn(fn() { 1 }))`, "TASK"},
+		{`spawn(fn(a, b) { a }, 1)`, "spawn: wrong number of arguments: want=2, got=1"},
+		{`spawn(5)`, "spawn: expected a function, got INTEGER"},
+		{`spawn(fn() { 1 }).nope`, "task has no field nope"},
+		{`t = spawn(fn() { 1 }); t.result(2)`, "result: expected 0 arguments, got 1"},
+		{`await(await_any([spawn(fn() { time_sleep(200); 1 }), spawn(fn() { 2 })]))`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+// An error from a task keeps the location it was raised at, not the one
+// of the await that reports it.
+func TestTaskErrorLocation(t *testing.T) {
+	input := `t = spawn(fn() {
+	1;
+	throw "bad";
+});
+await(t)`
+	errObj, ok := testEval(input).(*Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+	if errObj.Message != "bad" || errObj.Line != 3 || errObj.Column != 2 {
+		t.Errorf("wrong error. got=%q on line %d, col %d", errObj.Message, errObj.Line, errObj.Column)
+	}
+}
+
+func TestSyncPrimitives(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`
+			m = mutex(); count = 0; wg = waitgroup();
+			wg.add(20);
+			for (i in range(20)) {
+				spawn(fn() {
+					for (j in range(50)) { with_lock(m, fn() { count = count + 1; }); }
+					wg.done();
+				});
+			}
+			wg.wait();
+			count
+		`, 1000},
+		{`
+			m = mutex(); count = 0;
+			ts = [];
+			for (i in range(10)) {
+				ts = push(ts, spawn(fn() { for (j in range(50)) { m.lock(); count = count + 1; m.unlock(); } }));
+			}
+			await_all(ts);
+			count
+		`, 500},
+		{`
+			a = atomic(); ts = [];
+			for (i in range(10)) { ts = push(ts, spawn(fn() { for (j in range(100)) { a.add(1); } })); }
+			await_all(ts);
+			a.get()
+		`, 1000},
+		{`
+			a = atomic(0); ts = [];
+			inc = fn() { for (let done = false; !done; ) { v = a.get(); done = a.cas(v, v + 1); } };
+			for (i in range(10)) { ts = push(ts, spawn(fn() { for (j in range(20)) { inc(); } })); }
+			await_all(ts);
+			a.get()
+		`, 200},
+		{`a = atomic(5); a.add(-2)`, 3},
+		{`a = atomic(5); a.cas(4, 9)`, false},
+		{`a = atomic(5); a.cas(5, 9); a.get()`, 9},
+		{`a = atomic(); a.set(7); str(a)`, "atomic(7)"},
+		{`rw = rwmutex(); rw.rlock(); rw.rlock(); rw.runlock(); rw.runlock(); rw.lock(); rw.unlock(); with_rlock(rw, fn() { 1 }) + with_lock(rw, fn() { 2 })`, 3},
+		{`m = mutex(); try { with_lock(m, fn() { throw "x"; }); } catch (e) { 0 } m.lock(); m.unlock(); 1`, 1},
+		{`m = mutex(); m.unlock()`, "unlock: mutex is not locked"},
+		{`rw = rwmutex(); rw.runlock()`, "runlock: rwmutex is not read-locked"},
+		{`rw = rwmutex(); rw.rlock(); rw.unlock()`, "unlock: rwmutex is not locked"},
+		{`wg = waitgroup(); wg.done()`, "waitgroup: count would go below zero"},
+		{`wg = waitgroup(); wg.add(2); str(wg)`, "waitgroup(2)"},
+		{`
+			wg = waitgroup(); wg.add(5); failed = atomic(); ts = [];
+			for (i in range(10)) {
+				ts = push(ts, spawn(fn() { try { wg.done(); } catch (e) { failed.add(1); } }));
+			}
+			await_all(ts);
+			str(failed.get()) + " " + str(wg)
+		`, "5 waitgroup(0)"},
+		{`with_lock(1, fn() { 1 })`, "with_lock: expected a mutex, got INTEGER"},
+		{`with_rlock(mutex(), fn() { 1 })`, "with_rlock: expected an rwmutex, got MUTEX"},
+		{`atomic().add("x")`, "add: expected an integer, got STRING"},
+		{`mutex().lock(1)`, "lock: expected 0 arguments, got 1"},
+		{`mutex().nope`, "mutex has no field nope"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+// A finished task must not stay in asyncJobs.
+func TestTaskJobsLeak(t *testing.T) {
+	jobs := func() int {
+		asyncMu.Lock()
+		defer asyncMu.Unlock()
+		return len(asyncJobs)
+	}
+	before := jobs()
+	testEval(`
+		ids = [];
+		for (let i = 0; i < 50; ++i) { ids = push(ids, spawn(fn() { i })); }
+		await_all(ids);
+	`)
+	deadline := time.Now().Add(5 * time.Second)
+	for jobs() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := jobs(); n > before {
+		t.Errorf("finished tasks leaked in asyncJobs. before=%d, after=%d", before, n)
+	}
+}
+
+func TestFunctionArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`greet = fn(name, greeting = "hi") { greeting + " " + name }; greet("bo")`, "hi bo"},
+		{`greet = fn(name, greeting = "hi") { greeting + " " + name }; greet("bo", "yo")`, "yo bo"},
+		{`f = fn(a, b = a * 2) { a + b }; f(3)`, 9},
+		{`n = 1; f = fn(a = n) { a }; n = 5; f()`, 5},
+		{`sum = fn(...xs) { t = 0; for (let i = 0; i < len(xs); ++i) { t = t + xs[i]; } t }; sum(1, 2, 3)`, 6},
+		{`f = fn(a, ...rest) { len(rest) }; f(1)`, 0},
+		{`f = fn(a, b = 2, ...rest) { a + b + len(rest) }; f(1)`, 3},
+		{`f = fn(a, b, c) { a * 100 + b * 10 + c }; xs = [2, 3]; f(1, ...xs)`, 123},
+		{`f = fn(...xs) { len(xs) }; f(...[1, 2], 3, ...[])`, 3},
+		{`f = fn(a) { a }; f(1, 2)`, "wrong number of arguments: want=1, got=2"},
+		{`f = fn(a, b = 1) { a }; f()`, "wrong number of arguments: want=1 to 2, got=0"},
+		{`f = fn(a, ...rest) { a }; f()`, "wrong number of arguments: want at least 1, got=0"},
+		{`f = fn(a) { a }; f(...5)`, "cannot spread INTEGER; expected an array"},
+		{`Shape = interface { area(scale) }; Sq = struct { area: fn(scale = 1) { scale } }; Sq s; implements(s, Shape)`, true},
+		{`Shape = interface { area() }; Sq = struct { area: fn(scale) { scale } }; Sq s; implements(s, Shape)`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestEnums(t *testing.T) {
+	decls := `
+enum Color { Red, Green, Blue }
+enum Result { Ok(value), Err(msg) }
+describe = fn(r) {
+  match r {
+    Result.Ok(v) if v > 10 => "big " + str(v),
+    Result.Ok(v) => "ok " + str(v),
+    Result.Err(m) => "failed: " + m
+  }
+};
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{decls + `Color.Red == Color.Red`, true},
+		{decls + `Color.Red == Color.Blue`, false},
+		{decls + `Color.Red != Color.Blue`, true},
+		{decls + `Result.Ok(1) == Result.Ok(1)`, true},
+		{decls + `Result.Ok(1) == Result.Ok(2)`, false},
+		{decls + `Result.Ok(1) == Result.Err(1)`, false},
+		{decls + `Result.Ok(1).value`, 1},
+		{decls + `h = {Color.Green: 1, Result.Err("x"): 2}; h[Color.Green] + h[Result.Err("x")]`, 3},
+		{decls + `describe(Result.Ok(42))`, "big 42"},
+		{decls + `describe(Result.Ok(3))`, "ok 3"},
+		{decls + `describe(Result.Err("nope"))`, "failed: nope"},
+		{decls + `c = Color.Blue; if (c == Color.Blue) { "blue" } else { "other" }`, "blue"},
+		{decls + `match Color.Green { Color.Red => 1, Color.Green => 2, Color.Blue => 3 }`, 2},
+		{decls + `match Result.Ok(1) { Result.Ok => "any ok", _ => "other" }`, "any ok"},
+		{decls + `Result.Ok(1, 2)`, "Result.Ok expects 1 argument, got 2"},
+		{decls + `Color.Purple`, "enum Color has no variant Purple"},
+		{decls + `Result.Ok(1).msg`, "Result.Ok(1) has no field msg"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+
+	for input, expected := range map[string]string{
+		decls + `Color.Green`:     "Color.Green",
+		decls + `Result.Err("x")`: "Result.Err(x)",
+		decls + `Color`:           "enum Color { Red, Green, Blue }",
+		decls + `Result`:          "enum Result { Ok(value), Err(msg) }",
+	} {
+		if got := testEval(input).Inspect(); got != expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", expected, got)
+		}
+	}
+}
+
+func TestInterfacesAndEmbedding(t *testing.T) {
+	shapes := `
+log = "";
+Sized = interface { length() };
+Sequence = interface { ...Sized, at(i) };
+Base = struct {
+  name: "",
+  describe: fn() { return "I am " + self.name; },
+  ~Base: fn() { log = log + "~Base"; }
+};
+Box = struct {
+  ...Base,
+  items: [],
+  Box: fn(name) { self.name = name; },
+  length: fn() { return len(self.items); },
+  at: fn(i) { return self.items[i]; },
+  ~Box: fn() { log = log + "~Box"; }
+};
+Bad = struct { length: fn(a, b) { return 0; } };
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{shapes + `Box b("box"); b.describe()`, "I am box"},
+		{shapes + `Box b("b"); b.items = [1, 2]; b.length()`, 2},
+		{shapes + `Box b("b"); implements(b, Sequence)`, true},
+		{shapes + `Base b; implements(b, Sized)`, false},
+		{shapes + `Bad b; implements(b, Sized)`, false},
+		{shapes + `implements([1], Sized)`, false},
+		{shapes + `p = new Box("p"); implements(p, Sequence)`, true},
+		{shapes + `p = new Box("p"); delete p; log`, "~Box~Base"},
+		{shapes + `f = fn() { Box b("b"); }; f(); log`, "~Box~Base"},
+		{shapes + `implements(Box, Base)`, "implements: second argument must be an interface"},
+		{`X = struct { ...5 };`, "cannot embed INTEGER"},
+		{`A = interface { f(a) }; B = interface { ...A, f() };`, "method f declared with 0 and 1 parameters"},
+		// a struct passed to a function is not destroyed when it returns
+		{shapes + `Box b("b"); b.items = [7]; n = fn(s) { return s.length(); }; n(b); b.at(0)`, 7},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestConstructors(t *testing.T) {
+	point := `
+log = "";
+Point = struct {
+  x: 0,
+  y: 0,
+  Point: fn(x, y) { self.x = x; self.y = y; },
+  ~Point: fn() { log = log + "~" + str(self.x + self.y); }
+};
+Plain = struct { v: 1 };
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{point + `Point p(3, 4); p.x * p.y`, 12},
+		{point + `n = new Point(5, 6); n->y`, 6},
+		{point + `f = fn() { Point p(1, 2); }; f(); log`, "~3"},
+		{point + `n = new Point(7, 1); delete n; log`, "~8"},
+		// a closure can outlive the scope that declared the struct
+		{point + `f = fn() { Point p(1, 2); return fn() { p.x = 5; }; }; f()();`, "use after destroy: Point went out of scope"},
+		{point + `f = fn() { Point p(1, 2); return fn() { p.x }; }; f()();`, "use after destroy: Point went out of scope"},
+		{point + `f = fn() { Point p(1, 2); return fn() { p.x }; }; g = f(); try { g(); } catch (e) { }; log`, "~3"},
+		{point + `Plain p; p.v`, 1},
+		{point + `Plain p(1);`, "Plain has no constructor"},
+		{point + `n = new Plain(1);`, "Plain has no constructor"},
+		{point + `int n(1);`, "int has no constructor"},
+		{point + `Point* p(1, 2);`, "cannot construct pointer p; use new Point(...)"},
+		{`Bad = struct { Bad: fn() { throw "no"; } }; Bad b();`, "no"},
+		{`generic<T> Box = struct { v: T, Box: fn(v) { self.v = v; } }; Box<int> b("s");`,
+			"generic type mismatch: property v expects int, got STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, dir, "lib/counter.nik", `
+import "helper";
+loads = 0;
+_hidden = 1;
+count = 0;
+bump = fn() { count = count + 1; count };
+twice = fn(x) { helper.double(x) };
+`)
+	writeModule(t, dir, "lib/helper.nik", `double = fn(x) { x * 2 };`)
+	writeModule(t, dir, "lib/a.nik", `import "b";`)
+	writeModule(t, dir, "lib/b.nik", `import "a";`)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "lib/counter" as c; c.twice(21)`, 42},
+		{`import "lib/counter"; counter.bump()`, 1},
+		{`import "lib/counter" as a; import "lib/counter.nik" as b; a.bump(); b.bump()`, 2},
+		{`import "lib/counter" as c; c.count = 5;`, "property assignment"},
+		{`import "lib/counter" as c; c._hidden`, "_hidden is not exported by module counter"},
+		{`import "lib/counter" as c; c.missing`, "module counter has no member missing"},
+		{`import "lib/counter" as c; double`, "identifier not found: double"},
+		{`import "lib/nope";`, "module not found: lib/nope.nik"},
+		{`import "lib/a";`, "import cycle: a -> b -> a"},
+		{`try { import "lib/nope"; } catch (e) { e.kind }`, "ImportError"},
+	}
+
+	for _, tt := range tests {
+		env := NewEnvironment()
+		env.SetDir(dir)
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*Error); ok {
+				if !strings.HasPrefix(errObj.Message, expected) {
+					t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+
+	t.Setenv("NIKIUM_PATH", filepath.Join(dir, "lib"))
+	evaluated := testEval(`import "helper" as h; h.double(4)`)
+	testIntegerObject(t, evaluated, 8)
+}
+
+func TestEmbeddedStdlib(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`import "stdlib/math" as m; m.max(2, 3)`, 3},
+		{`import "stdlib/linkedlist" as ll; l = ll.LinkedList_push(ll.LinkedList(), 4); l.size`, 1},
+		{`load "stdlib/stack.nik"; s = Stack_push(Stack(), 1); s.size`, 1},
+		{`load "stdlib/stack.nik"; s = Stack_push(Stack_push(Stack(), 1), 2); s = Stack_pop(s); s.popped * 10 + len(s.data)`, 21},
+		{`import "stdlib/priorityqueue" as pq; q = pq.PriorityQueue(); for (p in [5, 1, 4, 2, 3]) { q = pq.PriorityQueue_push(q, p * 10, p); } out = 0; while (pq.PriorityQueue_size(q) > 0) { q = pq.PriorityQueue_pop(q); out = out * 10 + q.popped / 10; } out`, 12345},
+		{`import "stdlib/bst" as b; t = b.BST(); for (v in [5, 2, 8, 1]) { t = b.BST_insert(t, v); } t = b.BST_inorder(t); t.result[0] * 1000 + t.result[1] * 100 + t.result[2] * 10 + t.result[3]`, 1258},
+		{`import "stdlib/trie" as tr; t = tr.Trie_insert(tr.Trie_insert(tr.Trie(), "to"), "tea"); t = tr.Trie_search(t, "tea"); a = t.result; t = tr.Trie_search(t, "te"); if (a && !t.result) { 1 } else { 0 }`, 1},
+		{`import "stdlib/graph" as gm; g = gm.Graph_addEdge(gm.Graph_addEdge(gm.Graph(), 1, "b"), 1, 2); g = gm.Graph_addEdge(g, 1, 2); g = gm.Graph_getNeighbors(g, 1); len(g.result) * 10 + len(g.nodes)`, 23},
+	}
+	for _, tt := range tests {
+		env := NewEnvironment()
+		env.SetDir(dir)
+		testIntegerObject(t, Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env), tt.expected)
+	}
+
+	// a local copy wins over the embedded one
+	writeModule(t, dir, "override/math.nik", `max = fn(a, b) { 99 };`)
+	t.Setenv("NIKIUM_STDLIB", filepath.Join(dir, "override"))
+	env := NewEnvironment()
+	env.SetDir(dir)
+	evaluated := Eval(parser.New(lexer.New(`import "stdlib/math" as m; m.max(2, 3)`)).ParseProgram(), env)
+	testIntegerObject(t, evaluated, 99)
+}
+
+func writeModule(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func testEval(input string) Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -373,6 +1623,21 @@ func testIntegerObject(t *testing.T, obj Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj Object, expected float64) bool {
+	result, ok := obj.(*Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj Object, expected bool) bool {
 	result, ok := obj.(*Boolean)
 	if !ok {
@@ -0,0 +1,173 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Interpolate joins the values of a template string's parts, each as its
+// Inspect would print it.
+func Interpolate(parts []Object) *String {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(part.Inspect())
+	}
+	return &String{Value: out.String()}
+}
+
+func evalTemplateLiteral(node *ast.TemplateLiteral, env *Environment) Object {
+	parts := make([]Object, len(node.Parts))
+	for i, part := range node.Parts {
+		val := Eval(part, env)
+		if isError(val) {
+			return val
+		}
+		parts[i] = val
+	}
+	return Interpolate(parts)
+}
+
+// verb is one %[flags][width][.precision]verb of a format string.
+type verb struct {
+	char      byte
+	left      bool // '-': pad on the right
+	zero      bool // '0': pad numbers with zeros
+	width     int
+	precision int // -1 when not given
+}
+
+// nativeFormat is format(fmt, args...). It understands %d, %s, %x, %v and
+// %%, with Go's '-' and '0' flags, a width and a precision.
+func nativeFormat(args []Object) Object {
+	if len(args) < 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: "format: expected at least 1 argument, got 0"}
+	}
+	f, ok := args[0].(*String)
+	if !ok {
+		return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("format: expected a string, got %s", args[0].Type())}
+	}
+	args = args[1:]
+	s := f.Value
+	var out strings.Builder
+	used := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i < len(s) && s[i] == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		v := verb{precision: -1}
+		for ; i < len(s) && (s[i] == '-' || s[i] == '0'); i++ {
+			if s[i] == '-' {
+				v.left = true
+			} else {
+				v.zero = true
+			}
+		}
+		v.width, i = readDigits(s, i)
+		if i < len(s) && s[i] == '.' {
+			v.precision, i = readDigits(s, i+1)
+		}
+		if i >= len(s) {
+			return &Error{Kind: VALUE_ERROR, Message: "format: unfinished verb at the end of the format"}
+		}
+		v.char = s[i]
+		if used >= len(args) {
+			return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("format: missing argument for %%%c", v.char)}
+		}
+		text, errObj := v.format(args[used])
+		if errObj != nil {
+			return errObj
+		}
+		used++
+		out.WriteString(text)
+	}
+	if used < len(args) {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("format: %d arguments for %d verbs", len(args), used)}
+	}
+	return &String{Value: out.String()}
+}
+
+// readDigits reads the number starting at s[i], 0 if there is none, and
+// returns it with the index just past it.
+func readDigits(s string, i int) (int, int) {
+	n := 0
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		n = n*10 + int(s[i]-'0')
+	}
+	return n, i
+}
+
+func (v verb) format(arg Object) (string, *Error) {
+	var text string
+	numeric := false
+	switch v.char {
+	case 'd':
+		n, ok := arg.(*Integer)
+		if !ok {
+			return "", &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("format: %%d needs an integer, got %s", arg.Type())}
+		}
+		text, numeric = strconv.FormatInt(n.Value, 10), true
+	case 'x':
+		switch arg := arg.(type) {
+		case *Integer:
+			text, numeric = strconv.FormatInt(arg.Value, 16), true
+		case *String:
+			text = hex.EncodeToString([]byte(arg.Value))
+		default:
+			return "", &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("format: %%x needs an integer or a string, got %s", arg.Type())}
+		}
+	case 's':
+		text = truncate(arg.Inspect(), v.precision)
+	case 'v':
+		switch arg := arg.(type) {
+		case *Integer:
+			text, numeric = arg.Inspect(), true
+		case *Float:
+			// the precision of a float is its number of decimals
+			text, numeric = arg.Inspect(), true
+			if v.precision >= 0 {
+				text = strconv.FormatFloat(arg.Value, 'f', v.precision, 64)
+			}
+		default:
+			text = truncate(arg.Inspect(), v.precision)
+		}
+	default:
+		return "", &Error{Kind: VALUE_ERROR, Message: fmt.Sprintf("format: unknown verb %%%c", v.char)}
+	}
+	return v.pad(text, numeric), nil
+}
+
+// pad widens text to the verb's width, keeping a number's sign in front
+// of any zeros.
+func (v verb) pad(text string, numeric bool) string {
+	n := v.width - utf8.RuneCountInString(text)
+	switch {
+	case n <= 0:
+		return text
+	case v.left:
+		return text + strings.Repeat(" ", n)
+	case v.zero && numeric && strings.HasPrefix(text, "-"):
+		return "-" + strings.Repeat("0", n) + text[1:]
+	case v.zero && numeric:
+		return strings.Repeat("0", n) + text
+	}
+	return strings.Repeat(" ", n) + text
+}
+
+// truncate keeps the first precision characters of s, all of them when
+// precision is negative.
+func truncate(s string, precision int) string {
+	if precision < 0 || utf8.RuneCountInString(s) <= precision {
+		return s
+	}
+	return string([]rune(s)[:precision])
+}
@@ -0,0 +1,152 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Generator is what calling a function that yields returns. Its body runs on
+// a goroutine of its own, one yield at a time: each next hands the goroutine
+// control until the following yield or the end of the body.
+//
+// The goroutine only holds the inner state, so once the program drops the
+// Generator a finalizer can stop a body that is parked at a yield.
+type Generator struct {
+	*generator
+}
+
+type generator struct {
+	fn  *Function
+	env *Environment
+
+	mu      sync.Mutex // one next at a time, from any task
+	started bool
+	done    bool
+
+	resume chan struct{}
+	yields chan Object
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (g *Generator) Type() ObjectType { return GENERATOR_OBJ }
+func (g *Generator) Inspect() string  { return "generator" }
+
+func newGenerator(fn *Function, env *Environment) *Generator {
+	inner := &generator{
+		fn:     fn,
+		env:    env,
+		resume: make(chan struct{}),
+		yields: make(chan Object),
+		stop:   make(chan struct{}),
+	}
+	env.gen = inner
+	g := &Generator{inner}
+	runtime.SetFinalizer(g, func(g *Generator) { g.close() })
+	return g
+}
+
+// Next runs the body to its next yield. ok is false once the body has
+// finished; val is then an error if the body failed.
+func (g *generator) Next() (val Object, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return nil, false
+	}
+	if !g.started {
+		g.started = true
+		go g.run()
+	} else {
+		g.resume <- struct{}{}
+	}
+	val, ok = <-g.yields
+	if !ok || isError(val) {
+		g.done = true
+		return val, false
+	}
+	return val, true
+}
+
+func (g *generator) run() {
+	defer close(g.yields)
+	result := unwrapReturnValue(Eval(g.fn.Body, g.env))
+	cleanupEnvironment(g.env, result)
+	if isError(result) {
+		select {
+		case g.yields <- result:
+		case <-g.stop:
+		}
+	}
+}
+
+// close makes a body parked at a yield return, running its finally blocks
+// on the way out.
+func (g *generator) close() {
+	g.once.Do(func() { close(g.stop) })
+}
+
+// evalYieldStatement hands val to the pending next and waits for the one
+// after it. A stopped generator unwinds as if the body had returned.
+func evalYieldStatement(node *ast.YieldStatement, env *Environment) Object {
+	g := env.generator()
+	if g == nil {
+		return newError("yield outside a generator")
+	}
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+	select {
+	case g.yields <- val:
+	case <-g.stop:
+		return &ReturnValue{Value: NULL}
+	}
+	select {
+	case <-g.resume:
+		return NULL
+	case <-g.stop:
+		return &ReturnValue{Value: NULL}
+	}
+}
+
+// nativeNext is next(g): the next value of a generator or of a struct with
+// a next method, null once a generator is exhausted.
+func nativeNext(t *Task, args []Object) Object {
+	if len(args) != 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("next: expected 1 argument, got %d", len(args))}
+	}
+	if g, ok := args[0].(*Generator); ok {
+		val, ok := g.Next()
+		if !ok && val == nil {
+			return NULL
+		}
+		return val
+	}
+	method, errObj := nextMethod(args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return applyFunctionAs(t, method, []Object{})
+}
+
+// nextMethod returns the next method of a struct or pointer, the one for-in
+// loops and next call to step through it.
+func nextMethod(obj Object) (Object, *Error) {
+	var method Object = NULL
+	switch obj := obj.(type) {
+	case *Struct:
+		method = GetProperty(obj, "next", false)
+	case *Pointer:
+		method = GetProperty(obj, "next", true)
+	}
+	switch m := method.(type) {
+	case *Error:
+		return nil, m
+	case *Function, Callable:
+		return method, nil
+	}
+	return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("expected a generator or a struct with a next method, got %s", obj.Type())}
+}
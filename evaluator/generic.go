@@ -0,0 +1,338 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"fmt"
+	"strings"
+)
+
+// TypeArg is a type argument of a generic instance or call, resolved in the
+// scope that instantiated it: a primitive, array or hash type, a struct,
+// enum or interface, or an instance of another generic struct.
+type TypeArg struct {
+	Name string
+	Args []*TypeArg // type arguments, or the element type of an array
+	// def is the struct, enum or interface Name refers to, nil for
+	// built-in types and for names that were not found
+	def Object
+}
+
+func (t *TypeArg) String() string {
+	if t.Name == "[]" {
+		return "[]" + t.Args[0].String()
+	}
+	if len(t.Args) == 0 {
+		return t.Name
+	}
+	args := make([]string, len(t.Args))
+	for i, arg := range t.Args {
+		args[i] = arg.String()
+	}
+	return t.Name + "<" + strings.Join(args, ", ") + ">"
+}
+
+// builtinTypes maps the type names that need no declaration to the objects
+// their values are.
+var builtinTypes = map[string][]ObjectType{
+	"int":    {INTEGER_OBJ},
+	"i8":     {INTEGER_OBJ},
+	"i16":    {INTEGER_OBJ},
+	"i32":    {INTEGER_OBJ},
+	"i64":    {INTEGER_OBJ},
+	"u8":     {INTEGER_OBJ},
+	"u16":    {INTEGER_OBJ},
+	"u32":    {INTEGER_OBJ},
+	"u64":    {INTEGER_OBJ},
+	"uint":   {INTEGER_OBJ},
+	"float":  {FLOAT_OBJ, INTEGER_OBJ},
+	"f32":    {FLOAT_OBJ, INTEGER_OBJ},
+	"f64":    {FLOAT_OBJ, INTEGER_OBJ},
+	"string": {STRING_OBJ},
+	"bool":   {BOOLEAN_OBJ},
+	"array":  {ARRAY_OBJ},
+	"hash":   {HASH_OBJ},
+}
+
+// Constraints that are not interfaces, by the object types they admit.
+var builtinConstraints = map[string][]ObjectType{
+	"Comparable": {INTEGER_OBJ, FLOAT_OBJ, STRING_OBJ},
+	"Numeric":    {INTEGER_OBJ, FLOAT_OBJ},
+	"Hashable":   {INTEGER_OBJ, STRING_OBJ, BOOLEAN_OBJ, ENUM_OBJ},
+}
+
+// resolveTypeArg looks up the names in expr. Type parameters bound in
+// params stand for their binding; other names that are not declared are
+// left unresolved and accept any value, as a type parameter of an
+// enclosing generic would.
+func resolveTypeArg(expr *ast.TypeExpr, params map[string]*TypeArg, env *Environment) (*TypeArg, *Error) {
+	if bound, ok := params[expr.Name]; ok && len(expr.Args) == 0 {
+		return bound, nil
+	}
+	t := &TypeArg{Name: expr.Name}
+	for _, arg := range expr.Args {
+		resolved, errObj := resolveTypeArg(arg, params, env)
+		if errObj != nil {
+			return nil, errObj
+		}
+		t.Args = append(t.Args, resolved)
+	}
+	if expr.Name == "[]" {
+		return t, nil
+	}
+	if _, ok := builtinTypes[expr.Name]; ok {
+		if len(t.Args) > 0 {
+			return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s is not generic", expr.Name)}
+		}
+		return t, nil
+	}
+	def, ok := env.Get(expr.Name)
+	if !ok {
+		return t, nil
+	}
+	switch def := def.(type) {
+	case *Struct:
+		if _, errObj := bindTypeParams(expr.Name, def.TypeParams, t.Args, env); errObj != nil {
+			return nil, errObj
+		}
+	case *EnumType, *Interface:
+		if len(t.Args) > 0 {
+			return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s is not generic", expr.Name)}
+		}
+	default:
+		return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s is not a type", expr.Name)}
+	}
+	t.def = def
+	return t, nil
+}
+
+// resolveTypeArgs resolves the type arguments of an instantiation of the
+// generic called name and binds them to its parameters in order.
+func resolveTypeArgs(name string, typeParams []*ast.TypeParam, exprs []*ast.TypeExpr, env *Environment) (map[string]*TypeArg, *Error) {
+	args := make([]*TypeArg, len(exprs))
+	for i, expr := range exprs {
+		arg, errObj := resolveTypeArg(expr, nil, env)
+		if errObj != nil {
+			return nil, errObj
+		}
+		args[i] = arg
+	}
+	return bindTypeParams(name, typeParams, args, env)
+}
+
+// bindTypeParams pairs type arguments with the parameters of the generic
+// called name, checking their number and each parameter's constraint.
+func bindTypeParams(name string, typeParams []*ast.TypeParam, args []*TypeArg, env *Environment) (map[string]*TypeArg, *Error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if len(typeParams) == 0 {
+		return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s is not generic", name)}
+	}
+	if len(args) != len(typeParams) {
+		return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s expects %d type %s, got %d",
+			name, len(typeParams), plural(len(typeParams), "argument"), len(args))}
+	}
+	bindings := make(map[string]*TypeArg, len(args))
+	for i, param := range typeParams {
+		if param.Constraint != "" && !satisfies(args[i], param.Constraint, env) {
+			return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s does not satisfy %s, required by %s of %s",
+				args[i], param.Constraint, param.Name, name)}
+		}
+		bindings[param.Name] = args[i]
+	}
+	return bindings, nil
+}
+
+// satisfies reports whether values of type t meet constraint, an interface
+// or one of the builtin constraints. An interface declared with the name of
+// a builtin constraint replaces it. Unresolved types are not known well
+// enough to reject.
+func satisfies(t *TypeArg, constraint string, env *Environment) bool {
+	obj, _ := env.Get(constraint)
+	iface, isIface := obj.(*Interface)
+	if admitted, ok := builtinConstraints[constraint]; ok && !isIface {
+		if types, ok := builtinTypes[t.Name]; ok {
+			return admitsAll(admitted, types)
+		}
+		if _, isEnum := t.def.(*EnumType); isEnum {
+			return admitsAll(admitted, []ObjectType{ENUM_OBJ})
+		}
+		return t.def == nil && t.Name != "[]"
+	}
+	if !isIface {
+		return false
+	}
+	switch def := t.def.(type) {
+	case *Struct:
+		return Implements(def, iface)
+	case *Interface:
+		for name, n := range iface.Methods {
+			if have, ok := def.Methods[name]; !ok || have != n {
+				return false
+			}
+		}
+		return true
+	case nil:
+		_, builtin := builtinTypes[t.Name]
+		return !builtin && t.Name != "[]"
+	}
+	return false
+}
+
+func isTypeParam(params []*ast.TypeParam, name string) bool {
+	for _, param := range params {
+		if param.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func admitsAll(admitted, types []ObjectType) bool {
+	for _, typ := range types {
+		found := false
+		for _, a := range admitted {
+			found = found || a == typ
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// typeMatchesGeneric checks if an object is a value of the type t.
+func typeMatchesGeneric(obj Object, t *TypeArg) bool {
+	if t.Name == "[]" {
+		arr, ok := obj.(*Array)
+		if !ok {
+			return false
+		}
+		for _, el := range arr.snapshot() {
+			if !typeMatchesGeneric(el, t.Args[0]) {
+				return false
+			}
+		}
+		return true
+	}
+	if types, ok := builtinTypes[t.Name]; ok {
+		for _, typ := range types {
+			if obj.Type() == typ {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch def := t.def.(type) {
+	case *Interface:
+		return Implements(obj, def)
+	case *EnumType:
+		val, ok := obj.(*EnumValue)
+		return ok && val.Enum == def
+	case *Struct:
+		var instance *Struct
+		switch obj := obj.(type) {
+		case *Struct:
+			instance = obj
+		case *Pointer:
+			instance = obj.alloc.class
+		default:
+			return false
+		}
+		if !instanceOf(instance, t.Name) {
+			return false
+		}
+		for i, arg := range t.Args {
+			bound := instance.GenericTypes[def.TypeParams[i].Name]
+			if bound == nil || bound.String() != arg.String() {
+				return false
+			}
+		}
+		return true
+	}
+	return true // unresolved types pass through
+}
+
+// instanceOf reports whether s was instantiated from the struct called
+// name, or embeds it.
+func instanceOf(s *Struct, name string) bool {
+	if s.ClassName == name {
+		return true
+	}
+	for _, embed := range s.Embeds {
+		if embed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// instantiateGeneric binds the type arguments of `Pair<int, string> p` or
+// `new Pair<int, string>()` on a fresh instance of the struct called name.
+func instantiateGeneric(instance *Struct, name string, exprs []*ast.TypeExpr, env *Environment) *Error {
+	bindings, errObj := resolveTypeArgs(name, instance.TypeParams, exprs, env)
+	if errObj != nil {
+		return errObj
+	}
+	for param, arg := range bindings {
+		instance.GenericTypes[param] = arg
+	}
+	return nil
+}
+
+// checkGenericArgs type-checks the arguments of a call such as
+// `put<string, int>("a", 1)`. Parameters annotated with a type that uses the
+// type parameters (`k: K`, `items: []T`) must receive values of that type;
+// with a single type parameter, unannotated parameters take it too.
+func checkGenericArgs(fn *Function, name string, args []Object, exprs []*ast.TypeExpr, env *Environment) *Error {
+	bindings, errObj := resolveTypeArgs(name, fn.TypeParams, exprs, env)
+	if errObj != nil || bindings == nil {
+		return errObj
+	}
+	for i, arg := range args {
+		var expr *ast.TypeExpr
+		if i < len(fn.ParamTypes) {
+			expr = fn.ParamTypes[i]
+		}
+		if expr == nil && len(fn.TypeParams) == 1 {
+			expr = &ast.TypeExpr{Name: fn.TypeParams[0].Name}
+		}
+		if expr == nil || !usesTypeParams(expr, bindings) {
+			continue
+		}
+		want, errObj := resolveTypeArg(expr, bindings, fn.Env)
+		if errObj != nil {
+			return errObj
+		}
+		if !typeMatchesGeneric(arg, want) {
+			return newError("generic type mismatch: expected %s, got %s", want, arg.Type())
+		}
+	}
+	return nil
+}
+
+func usesTypeParams(expr *ast.TypeExpr, params map[string]*TypeArg) bool {
+	if _, ok := params[expr.Name]; ok {
+		return true
+	}
+	for _, arg := range expr.Args {
+		if usesTypeParams(arg, params) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGenericField type-checks an assignment to a field that was declared
+// with a type parameter against the type the struct was instantiated with.
+func checkGenericField(s *Struct, name string, val Object) *Error {
+	param, ok := s.GenericFields[name]
+	if !ok {
+		return nil
+	}
+	if want := s.GenericTypes[param]; want != nil && !typeMatchesGeneric(val, want) {
+		return newError("generic type mismatch: property %s expects %s, got %s", name, want, val.Type())
+	}
+	return nil
+}
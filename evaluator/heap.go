@@ -0,0 +1,311 @@
+package evaluator
+
+import (
+	"Nikium/memory"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Struct instances created with new keep their data fields in a block from
+// the memory allocator. Each field takes a 16-byte slot: a kind word, then
+// either the value itself (integers, floats, booleans) or nothing, for
+// values that stay Go objects and are kept in the allocation's refs.
+const slotSize = 16
+
+const (
+	slotNull uint64 = iota
+	slotInt
+	slotFloat
+	slotBool
+	slotRef
+)
+
+// heapMu guards the allocator, which is not safe for concurrent use, the
+// arenas that slot reads and writes go through, and the refs of every
+// allocation.
+var heapMu sync.Mutex
+
+// allocation is one struct instance on the heap. Every Pointer copied from
+// the same new shares it, so deleting through one alias is seen by all.
+type allocation struct {
+	addr   uintptr
+	class  *Struct // the constructed instance: methods, destructor, generics
+	fields []string
+	slots  map[string]int
+	refs   []Object
+	extra  map[string]Object // fields assigned after new that have no slot
+	freed  atomic.Bool
+}
+
+// newAllocation moves the data fields of a constructed instance into a
+// fresh heap block. Functions stay on the instance, which acts as the
+// class of the allocation.
+func newAllocation(instance *Struct) (*allocation, *Error) {
+	a := &allocation{class: instance, slots: make(map[string]int)}
+	values := make(map[string]Object)
+	contentsMu.RLock()
+	for name, val := range instance.Properties {
+		if _, isFn := val.(*Function); !isFn {
+			a.fields = append(a.fields, name)
+			values[name] = val
+		}
+	}
+	contentsMu.RUnlock()
+	sort.Strings(a.fields)
+	for i, name := range a.fields {
+		a.slots[name] = i
+	}
+	a.refs = make([]Object, len(a.fields))
+
+	addr, err := heapMalloc(uint(max(len(a.fields)*slotSize, 8)))
+	if err != nil {
+		return nil, err
+	}
+	a.addr = addr
+	for i, name := range a.fields {
+		a.store(i, values[name])
+	}
+
+	// blocks nobody can reach any more go back to the allocator; only
+	// delete runs the destructor
+	runtime.SetFinalizer(a, func(a *allocation) {
+		if a.freed.CompareAndSwap(false, true) {
+			heapFree(a.addr)
+		}
+	})
+	return a, nil
+}
+
+func heapMalloc(size uint) (addr uintptr, errObj *Error) {
+	heapMu.Lock()
+	defer heapMu.Unlock()
+	defer func() {
+		if r := recover(); r == memory.ErrTooLarge {
+			errObj = newError("cannot allocate %d bytes: over the heap's block limit", size)
+		} else if r != nil {
+			errObj = newError("out of memory: cannot allocate %d bytes", size)
+		}
+	}()
+	return memory.Malloc(size), nil
+}
+
+func heapFree(addr uintptr) {
+	heapMu.Lock()
+	defer heapMu.Unlock()
+	memory.Free(addr)
+}
+
+func (a *allocation) load(slot int) Object {
+	base := a.addr + uintptr(slot*slotSize)
+	heapMu.Lock()
+	kind, word := memory.Load64(base), memory.Load64(base+8)
+	ref := a.refs[slot]
+	heapMu.Unlock()
+	switch kind {
+	case slotInt:
+		return &Integer{Value: int64(word)}
+	case slotFloat:
+		return &Float{Value: math.Float64frombits(word)}
+	case slotBool:
+		return nativeBoolToBooleanObject(word == 1)
+	case slotRef:
+		return ref
+	}
+	return NULL
+}
+
+func (a *allocation) store(slot int, val Object) {
+	base := a.addr + uintptr(slot*slotSize)
+	kind, word := slotRef, uint64(0)
+	var ref Object
+	switch val := val.(type) {
+	case *Integer:
+		kind, word = slotInt, uint64(val.Value)
+	case *Float:
+		kind, word = slotFloat, math.Float64bits(val.Value)
+	case *Boolean:
+		kind = slotBool
+		if val.Value {
+			word = 1
+		}
+	case *Null, nil:
+		kind = slotNull
+	default:
+		ref = val
+	}
+	heapMu.Lock()
+	memory.Store64(base, kind)
+	memory.Store64(base+8, word)
+	a.refs[slot] = ref
+	heapMu.Unlock()
+}
+
+// heapStats reports the allocator's usage to scripts.
+func heapStats() Object {
+	heapMu.Lock()
+	s := memory.Stats()
+	heapMu.Unlock()
+	pairs := map[string]Object{
+		"arenas":        &Integer{Value: int64(s.Arenas)},
+		"size":          &Integer{Value: int64(s.HeapSize)},
+		"used":          &Integer{Value: int64(s.Used)},
+		"free":          &Integer{Value: int64(s.Free)},
+		"free_blocks":   &Integer{Value: int64(s.FreeBlocks)},
+		"largest_free":  &Integer{Value: int64(s.LargestFree)},
+		"fragmentation": &Float{Value: s.Fragmentation},
+	}
+	hash := &Hash{Pairs: make(map[HashKey]HashPair, len(pairs))}
+	for k, v := range pairs {
+		key := &String{Value: k}
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: v}
+	}
+	return hash
+}
+
+// extraField returns a field that was assigned through the pointer but is
+// not in the slot layout, such as a new field or a replaced method.
+func (a *allocation) extraField(name string) (Object, bool) {
+	heapMu.Lock()
+	defer heapMu.Unlock()
+	val, ok := a.extra[name]
+	return val, ok
+}
+
+// extraFields copies the fields outside the slot layout.
+func (a *allocation) extraFields() map[string]Object {
+	heapMu.Lock()
+	defer heapMu.Unlock()
+	fields := make(map[string]Object, len(a.extra))
+	for name, val := range a.extra {
+		fields[name] = val
+	}
+	return fields
+}
+
+// has reports whether the struct has a field or method called name.
+func (a *allocation) has(name string) bool {
+	if _, ok := a.extraField(name); ok {
+		return true
+	}
+	contentsMu.RLock()
+	defer contentsMu.RUnlock()
+	_, ok := a.class.Properties[name]
+	return ok
+}
+
+func (a *allocation) useAfterFree() *Error {
+	return newError("use after free: pointer to %s was deleted", a.class.ClassName)
+}
+
+// Get reads a field or method of the struct the pointer refers to.
+func (p *Pointer) Get(name string) Object {
+	a := p.alloc
+	if a.freed.Load() {
+		return a.useAfterFree()
+	}
+	if slot, ok := a.slots[name]; ok {
+		return a.load(slot)
+	}
+	if val, ok := a.extraField(name); ok {
+		return bindReceiver(val, p)
+	}
+	contentsMu.RLock()
+	val, ok := a.class.Properties[name]
+	contentsMu.RUnlock()
+	if ok {
+		return bindReceiver(val, p)
+	}
+	return NULL
+}
+
+// Set writes a field of the struct the pointer refers to. Fields that
+// were data fields when it was created go to their slots; any other field,
+// like a method the script replaces, is kept in extra.
+func (p *Pointer) Set(name string, val Object) Object {
+	a := p.alloc
+	if a.freed.Load() {
+		return a.useAfterFree()
+	}
+	if errObj := checkGenericField(a.class, name, val); errObj != nil {
+		return errObj
+	}
+	if slot, ok := a.slots[name]; ok {
+		a.store(slot, val)
+		return val
+	}
+	heapMu.Lock()
+	if a.extra == nil {
+		a.extra = make(map[string]Object)
+	}
+	a.extra[name] = val
+	heapMu.Unlock()
+	return val
+}
+
+// Delete runs the destructor, on behalf of task t, and returns the block to
+// the allocator.
+func (p *Pointer) Delete(t *Task) Object {
+	a := p.alloc
+	if a.freed.Load() {
+		return newError("double free: pointer to %s was already deleted", a.class.ClassName)
+	}
+	if errObj := runDestructors(t, a.class, a.view()); errObj != nil {
+		return errObj
+	}
+	if !a.freed.CompareAndSwap(false, true) {
+		return newError("double free: pointer to %s was already deleted", a.class.ClassName)
+	}
+	heapMu.Lock()
+	defer heapMu.Unlock()
+	memory.Free(a.addr)
+	for i := range a.refs {
+		a.refs[i] = nil
+	}
+	a.extra = nil
+	return NULL
+}
+
+// view copies the instance into a struct value, which is what the
+// destructor sees as self: constructors and destructors run on struct
+// values whether the instance lives on the heap or not.
+func (a *allocation) view() *Struct {
+	s := instantiateStruct(a.class, a.class.ClassName)
+	for i, name := range a.fields {
+		s.Properties[name] = a.load(i)
+	}
+	for name, val := range a.extraFields() {
+		s.Properties[name] = val
+	}
+	return s
+}
+
+func (p *Pointer) inspectFields() string {
+	a := p.alloc
+	if a.freed.Load() {
+		return fmt.Sprintf("<deleted %s>", a.class.ClassName)
+	}
+	out := "struct{"
+	for i, name := range a.fields {
+		if i > 0 {
+			out += ", "
+		}
+		out += name + ": " + a.load(i).Inspect()
+	}
+	extra := a.extraFields()
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if out != "struct{" {
+			out += ", "
+		}
+		out += name + ": " + extra[name].Inspect()
+	}
+	return out + "}"
+}
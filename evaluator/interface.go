@@ -0,0 +1,182 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Interface is the set of methods, with their parameter counts, that a
+// struct needs to implement it. Interfaces are checked when asked, with
+// implements; structs never declare the interfaces they satisfy.
+type Interface struct {
+	Name    string
+	Methods map[string]int
+}
+
+func (i *Interface) Type() ObjectType { return INTERFACE_OBJ }
+func (i *Interface) Inspect() string {
+	names := make([]string, 0, len(i.Methods))
+	for name := range i.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for j, name := range names {
+		names[j] = fmt.Sprintf("%s/%d", name, i.Methods[name])
+	}
+	return "interface " + i.Name + "{" + strings.Join(names, ", ") + "}"
+}
+
+// NewInterface returns the methods declared by an interface literal, before
+// the interfaces it embeds are added by ExtendInterface.
+func NewInterface(node *ast.InterfaceLiteral) *Interface {
+	iface := &Interface{Name: node.Name, Methods: make(map[string]int)}
+	for _, m := range node.Methods {
+		iface.Methods[m.Name.Value] = len(m.Parameters)
+	}
+	return iface
+}
+
+// ExtendInterface copies template and adds the methods of the embedded
+// interfaces to it. A method both declare must take the same parameters.
+func ExtendInterface(template *Interface, embeds []Object) Object {
+	iface := &Interface{Name: template.Name, Methods: make(map[string]int)}
+	for name, n := range template.Methods {
+		iface.Methods[name] = n
+	}
+	for _, embed := range embeds {
+		other, ok := embed.(*Interface)
+		if !ok {
+			return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("cannot embed %s in an interface", embed.Type())}
+		}
+		for name, n := range other.Methods {
+			if have, exists := iface.Methods[name]; exists && have != n {
+				return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("method %s declared with %d and %d parameters", name, have, n)}
+			}
+			iface.Methods[name] = n
+		}
+	}
+	return iface
+}
+
+func evalInterfaceLiteral(node *ast.InterfaceLiteral, env *Environment) Object {
+	embeds := evalExpressions(node.Embeds, env)
+	if len(embeds) == 1 && isError(embeds[0]) {
+		return embeds[0]
+	}
+	return ExtendInterface(NewInterface(node), embeds)
+}
+
+// EmbedName is the name an embedded struct is known by inside the struct
+// that embeds it: Base for ...Base and ...shapes.Base.
+func EmbedName(expr ast.Expression) string {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		return expr.Value
+	case *ast.PropertyAccessExpression:
+		return expr.Property.Value
+	}
+	return expr.String()
+}
+
+// Embed promotes the fields and methods of base into target, keeping the
+// ones target declares itself. The methods run with the embedding struct as
+// self, and its destructor chains to base's.
+func Embed(target *Struct, base Object, name string) *Error {
+	strct, ok := base.(*Struct)
+	if !ok {
+		return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("cannot embed %s", base.Type())}
+	}
+	contentsMu.Lock()
+	defer contentsMu.Unlock()
+	for k, v := range strct.Properties {
+		if _, exists := target.Properties[k]; !exists {
+			target.Properties[k] = v
+			if param, ok := strct.GenericFields[k]; ok {
+				if target.GenericFields == nil {
+					target.GenericFields = make(map[string]string)
+				}
+				target.GenericFields[k] = param
+			}
+		}
+	}
+	// the base's type parameters become the embedding struct's, unless it
+	// declares its own with the same name
+	for _, param := range strct.TypeParams {
+		if !isTypeParam(target.TypeParams, param.Name) {
+			target.TypeParams = append(target.TypeParams, param)
+		}
+	}
+	if target.TypeParams != nil && target.GenericTypes == nil {
+		target.GenericTypes = make(map[string]*TypeArg)
+	}
+	target.Embeds = append(target.Embeds, name)
+	target.Embeds = append(target.Embeds, strct.Embeds...)
+	return nil
+}
+
+// runDestructors calls the destructor of class and then those of the
+// structs it embeds, each with self as the receiver, on behalf of task t.
+func runDestructors(t *Task, class *Struct, self Object) Object {
+	for _, name := range append([]string{class.ClassName}, class.Embeds...) {
+		contentsMu.RLock()
+		fn, ok := class.Properties["~"+name].(*Function)
+		contentsMu.RUnlock()
+		if ok {
+			if result := applyFunctionAs(t, bindReceiver(fn, self), []Object{}); isError(result) {
+				return result
+			}
+		}
+	}
+	return nil
+}
+
+// Arity is implemented by callables that know how many arguments they
+// accept. Natives accept any number, as they check their own arguments.
+type Arity interface {
+	Accepts(n int) bool
+}
+
+func (f *Function) Accepts(n int) bool {
+	if f.Native != nil {
+		return true
+	}
+	return ArityError(f.required(), len(f.Parameters), f.Rest != nil, n) == nil
+}
+
+// Implements reports whether obj, a struct or a pointer to one, has every
+// method of iface, accepting as many arguments as the interface declares.
+func Implements(obj Object, iface *Interface) bool {
+	var member func(name string) Object
+	switch obj := obj.(type) {
+	case *Struct:
+		member = func(name string) Object {
+			contentsMu.RLock()
+			defer contentsMu.RUnlock()
+			return obj.Properties[name]
+		}
+	case *Pointer:
+		a := obj.alloc
+		if a.freed.Load() {
+			return false
+		}
+		member = func(name string) Object {
+			if val, ok := a.extraField(name); ok {
+				return val
+			}
+			contentsMu.RLock()
+			defer contentsMu.RUnlock()
+			return a.class.Properties[name]
+		}
+	default:
+		return false
+	}
+	for name, n := range iface.Methods {
+		fn, ok := member(name).(Arity)
+		if !ok || !fn.Accepts(n) {
+			return false
+		}
+	}
+	return true
+}
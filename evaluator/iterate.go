@@ -0,0 +1,227 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"fmt"
+)
+
+// Range is the lazy sequence of integers made by range(start, stop, step).
+// Looping over it never builds an array.
+type Range struct {
+	Start, Stop, Step int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
+// nativeRange is range(stop), range(start, stop) or range(start, stop, step).
+func nativeRange(args []Object) Object {
+	if len(args) < 1 || len(args) > 3 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("range: expected 1 to 3 arguments, got %d", len(args))}
+	}
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		n, ok := arg.(*Integer)
+		if !ok {
+			return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("range: expected integers, got %s", arg.Type())}
+		}
+		bounds[i] = n.Value
+	}
+	r := &Range{Step: 1}
+	switch len(bounds) {
+	case 1:
+		r.Stop = bounds[0]
+	case 3:
+		r.Step = bounds[2]
+		fallthrough
+	case 2:
+		r.Start, r.Stop = bounds[0], bounds[1]
+	}
+	if r.Step == 0 {
+		return &Error{Kind: VALUE_ERROR, Message: "range: step must not be 0"}
+	}
+	return r
+}
+
+// Iterator walks a collection for a for-in loop. Each step yields a key
+// (the index, for sequences) and a value.
+type Iterator struct {
+	next func() (key, value Object, ok bool)
+	// keyed is set for hashes, whose one-variable loops see the keys
+	keyed bool
+	// err is why next stopped early, if it failed
+	err *Error
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// Iterate returns an iterator over an array, hash, string, range, channel,
+// generator or struct with a next method. Arrays and hashes are read as
+// they are when the loop starts, so the body may change them freely. A
+// channel is read until it is closed, and a next method ends the loop by
+// returning null.
+func Iterate(obj Object) (*Iterator, *Error) {
+	return iterateAs(nil, obj)
+}
+
+// iterateAs is Iterate for a loop run by task t, on whose behalf a next
+// method is called.
+func iterateAs(t *Task, obj Object) (*Iterator, *Error) {
+	switch obj := obj.(type) {
+	case *Array:
+		elements := obj.snapshot()
+		i := 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(elements) {
+				return nil, nil, false
+			}
+			i++
+			return &Integer{Value: int64(i - 1)}, elements[i-1], true
+		}}, nil
+
+	case *Hash:
+		pairs := obj.snapshot()
+		i := 0
+		return &Iterator{keyed: true, next: func() (Object, Object, bool) {
+			if i >= len(pairs) {
+				return nil, nil, false
+			}
+			i++
+			return pairs[i-1].Key, pairs[i-1].Value, true
+		}}, nil
+
+	case *String:
+		s := obj.Value
+		i := 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(s) {
+				return nil, nil, false
+			}
+			i++
+			return &Integer{Value: int64(i - 1)}, &String{Value: s[i-1 : i]}, true
+		}}, nil
+
+	case *Range:
+		cur, index := obj.Start, int64(0)
+		return &Iterator{next: func() (Object, Object, bool) {
+			if (obj.Step > 0 && cur >= obj.Stop) || (obj.Step < 0 && cur <= obj.Stop) {
+				return nil, nil, false
+			}
+			val := &Integer{Value: cur}
+			cur += obj.Step
+			index++
+			return &Integer{Value: index - 1}, val, true
+		}}, nil
+
+	case *Channel:
+		index := int64(0)
+		return &Iterator{next: func() (Object, Object, bool) {
+			val, ok := obj.Recv()
+			if !ok {
+				return nil, nil, false
+			}
+			index++
+			return &Integer{Value: index - 1}, val, true
+		}}, nil
+
+	case *Generator:
+		it := &Iterator{}
+		index := int64(0)
+		it.next = func() (Object, Object, bool) {
+			val, ok := obj.Next()
+			if !ok {
+				if errObj, isErr := val.(*Error); isErr {
+					it.err = errObj
+				}
+				return nil, nil, false
+			}
+			index++
+			return &Integer{Value: index - 1}, val, true
+		}
+		return it, nil
+
+	case *Struct, *Pointer:
+		method, errObj := nextMethod(obj)
+		if errObj != nil {
+			return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("cannot iterate over %s", obj.Type())}
+		}
+		it := &Iterator{}
+		index := int64(0)
+		it.next = func() (Object, Object, bool) {
+			val := applyFunctionAs(t, method, []Object{})
+			if errObj, isErr := val.(*Error); isErr {
+				it.err = errObj
+				return nil, nil, false
+			}
+			if val == nil || val == NULL {
+				return nil, nil, false
+			}
+			index++
+			return &Integer{Value: index - 1}, val, true
+		}
+		return it, nil
+	}
+	return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("cannot iterate over %s", obj.Type())}
+}
+
+// Next returns the values for n loop variables, nil once the collection is
+// exhausted or a next method has failed. One variable gets the element, or
+// the key of a hash entry; two get the key and the value.
+func (it *Iterator) Next(n int) ([]Object, *Error) {
+	key, value, ok := it.next()
+	if !ok {
+		return nil, it.err
+	}
+	if n == 2 {
+		return []Object{key, value}, nil
+	}
+	if it.keyed {
+		return []Object{key}, nil
+	}
+	return []Object{value}, nil
+}
+
+func evalForInStatement(node *ast.ForInStatement, env *Environment) Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	it, errObj := iterateAs(env.task, iterable)
+	if errObj != nil {
+		return errObj
+	}
+	names := node.Names()
+	loopEnv := NewEnclosedEnvironment(env)
+	for {
+		if errObj := loopEnv.task.check(); errObj != nil {
+			cleanupEnvironment(loopEnv, nil)
+			return errObj
+		}
+		vals, errObj := it.Next(len(names))
+		if errObj != nil {
+			cleanupEnvironment(loopEnv, nil)
+			return errObj
+		}
+		if vals == nil {
+			break
+		}
+		for i, name := range names {
+			loopEnv.Set(name.Value, vals[i])
+		}
+		result := Eval(node.Body, loopEnv)
+		if result != nil {
+			if result.Type() == RETURN_VALUE_OBJ || result.Type() == ERROR_OBJ {
+				cleanupEnvironment(loopEnv, unwrapReturnValue(result))
+				return result
+			}
+			if result.Type() == BREAK_OBJ {
+				break
+			}
+		}
+	}
+	cleanupEnvironment(loopEnv, nil)
+	return NULL
+}
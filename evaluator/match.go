@@ -0,0 +1,224 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"fmt"
+)
+
+func evalMatchExpression(node *ast.MatchExpression, env *Environment) Object {
+	subject := Eval(node.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+	for _, arm := range node.Arms {
+		bindings, ok := MatchPattern(arm.Pattern, subject)
+		if !ok {
+			continue
+		}
+		// the names a pattern binds are scoped to its arm
+		armEnv := NewEnclosedEnvironment(env)
+		for name, val := range bindings {
+			armEnv.Set(name, val)
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return Eval(arm.Body, armEnv)
+	}
+	return NoMatch(subject)
+}
+
+// NoMatch is the error raised when no arm of a match accepts the subject.
+func NoMatch(subject Object) *Error {
+	return &Error{Kind: VALUE_ERROR, Message: fmt.Sprintf("no match arm for %s", subject.Inspect())}
+}
+
+// PatternNames lists the names a pattern binds, in the order they appear.
+func PatternNames(pattern ast.Expression) []string {
+	var names []string
+	var walk func(ast.Expression)
+	walk = func(pattern ast.Expression) {
+		switch pattern := pattern.(type) {
+		case *ast.Identifier:
+			if pattern.Value != "_" {
+				names = append(names, pattern.Value)
+			}
+		case *ast.ArrayPattern:
+			for _, el := range pattern.Elements {
+				walk(el)
+			}
+			if pattern.Rest != nil {
+				walk(pattern.Rest)
+			}
+		case *ast.HashPattern:
+			for _, val := range pattern.Values {
+				walk(val)
+			}
+		case *ast.StructPattern:
+			for _, val := range pattern.Values {
+				walk(val)
+			}
+		case *ast.VariantPattern:
+			for _, arg := range pattern.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(pattern)
+	return names
+}
+
+// MatchPattern reports whether val has the shape of pattern and returns the
+// values of the names it binds.
+func MatchPattern(pattern ast.Expression, val Object) (map[string]Object, bool) {
+	bindings := make(map[string]Object)
+	if !matchPattern(pattern, val, bindings) {
+		return nil, false
+	}
+	return bindings, true
+}
+
+func matchPattern(pattern ast.Expression, val Object, bindings map[string]Object) bool {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			bindings[pattern.Value] = val
+		}
+		return true
+
+	case *ast.IntegerLiteral:
+		n, ok := val.(*Integer)
+		return ok && n.Value == pattern.Value
+
+	case *ast.FloatLiteral:
+		switch n := val.(type) {
+		case *Float:
+			return n.Value == pattern.Value
+		case *Integer:
+			return float64(n.Value) == pattern.Value
+		}
+		return false
+
+	case *ast.StringLiteral:
+		s, ok := val.(*String)
+		return ok && s.Value == pattern.Value
+
+	case *ast.Boolean:
+		b, ok := val.(*Boolean)
+		return ok && b.Value == pattern.Value
+
+	case *ast.ArrayPattern:
+		arr, ok := val.(*Array)
+		if !ok {
+			return false
+		}
+		elements := arr.snapshot()
+		if len(elements) < len(pattern.Elements) {
+			return false
+		}
+		if pattern.Rest == nil && len(elements) != len(pattern.Elements) {
+			return false
+		}
+		for i, el := range pattern.Elements {
+			if !matchPattern(el, elements[i], bindings) {
+				return false
+			}
+		}
+		if pattern.Rest != nil {
+			rest := elements[len(pattern.Elements):]
+			return matchPattern(pattern.Rest, &Array{Elements: rest}, bindings)
+		}
+		return true
+
+	case *ast.HashPattern:
+		hash, ok := val.(*Hash)
+		if !ok {
+			return false
+		}
+		for i, keyNode := range pattern.Keys {
+			contentsMu.RLock()
+			pair, ok := hash.Pairs[patternKey(keyNode)]
+			contentsMu.RUnlock()
+			if !ok || !matchPattern(pattern.Values[i], pair.Value, bindings) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.StructPattern:
+		var field func(string) (Object, bool)
+		switch obj := val.(type) {
+		case *Struct:
+			if pattern.Type != "" && obj.ClassName != pattern.Type {
+				return false
+			}
+			field = func(name string) (Object, bool) {
+				contentsMu.RLock()
+				defer contentsMu.RUnlock()
+				if obj.destroyed {
+					return nil, false
+				}
+				v, ok := obj.Properties[name]
+				return v, ok
+			}
+		case *Pointer:
+			a := obj.alloc
+			if a.freed.Load() || pattern.Type != "" && a.class.ClassName != pattern.Type {
+				return false
+			}
+			field = func(name string) (Object, bool) {
+				if !a.has(name) {
+					return nil, false
+				}
+				return obj.Get(name), true
+			}
+		default:
+			return false
+		}
+		for i, name := range pattern.Fields {
+			v, ok := field(name)
+			if !ok || !matchPattern(pattern.Values[i], v, bindings) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.VariantPattern:
+		ev, ok := val.(*EnumValue)
+		if !ok || ev.Enum.Name != pattern.Enum || ev.Variant.Name != pattern.Variant {
+			return false
+		}
+		if pattern.Args == nil {
+			return true
+		}
+		if len(pattern.Args) != len(ev.Values) {
+			return false
+		}
+		for i, arg := range pattern.Args {
+			if !matchPattern(arg, ev.Values[i], bindings) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// patternKey is the hash key of a literal in a hash pattern.
+func patternKey(node ast.Expression) HashKey {
+	switch node := node.(type) {
+	case *ast.StringLiteral:
+		return (&String{Value: node.Value}).HashKey()
+	case *ast.IntegerLiteral:
+		return (&Integer{Value: node.Value}).HashKey()
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(node.Value).HashKey()
+	}
+	return HashKey{}
+}
@@ -0,0 +1,235 @@
+package evaluator
+
+import (
+	"Nikium/ast"
+	"Nikium/lexer"
+	"Nikium/parser"
+	"Nikium/stdlib"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// embeddedPrefix marks module paths served from the standard library
+// compiled into the binary rather than from disk.
+const embeddedPrefix = "embed:"
+
+// Module is an imported file. Its top-level names live in Env; the ones
+// that do not start with an underscore are readable as module.name.
+type Module struct {
+	Name string
+	Path string
+	Env  *Environment
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "<module " + m.Name + ">" }
+
+// NewModule builds a module from a fixed set of members, for engines that
+// do not keep a module environment around.
+func NewModule(name, path string, members map[string]Object) *Module {
+	env := NewEnclosedEnvironment(nil)
+	for k, v := range members {
+		env.Set(k, v)
+	}
+	return &Module{Name: name, Path: path, Env: env}
+}
+
+// Member reads an exported top-level name of the module.
+func (m *Module) Member(name string) Object {
+	if !IsExported(name) {
+		return &Error{Kind: IMPORT_ERROR, Message: fmt.Sprintf("%s is not exported by module %s", name, m.Name)}
+	}
+	val, ok := m.Env.local(name)
+	if !ok {
+		return &Error{Kind: IMPORT_ERROR, Message: fmt.Sprintf("module %s has no member %s", m.Name, name)}
+	}
+	return val
+}
+
+// IsExported reports whether a module's top-level name is visible to
+// importers. Names starting with an underscore stay private.
+func IsExported(name string) bool {
+	return name != "" && !strings.HasPrefix(name, "_")
+}
+
+// ModuleName is the default binding for an import path: its last element
+// without the extension, so "stdlib/sql" imports as sql.
+func ModuleName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// ResolveModule finds the file for an import or load path. "stdlib/..."
+// paths come from the embedded standard library unless the directory in
+// NIKIUM_STDLIB has its own copy; a stdlib directory beside the script or
+// in the working directory is not consulted. Other relative paths are tried against
+// dir (the importing file's directory), then every directory in
+// NIKIUM_PATH, then the working directory. A missing ".nik" extension is
+// added. The result is absolute, so it can key a cache; read it with
+// ReadModule.
+func ResolveModule(path, dir string) (string, error) {
+	if filepath.Ext(path) == "" {
+		path += ".nik"
+	}
+
+	if file, ok := resolveStdlib(path, dir); ok {
+		return file, nil
+	}
+
+	var candidates []string
+	if filepath.IsAbs(path) {
+		candidates = []string{path}
+	} else {
+		if dir != "" {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+		for _, root := range filepath.SplitList(os.Getenv("NIKIUM_PATH")) {
+			if root != "" {
+				candidates = append(candidates, filepath.Join(root, path))
+			}
+		}
+		candidates = append(candidates, path)
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("module not found: %s", path)
+}
+
+// resolveStdlib looks path up in the standard library: either a
+// "stdlib/..." path from anywhere, or a path relative to a module that was
+// itself loaded from the embedded copy.
+func resolveStdlib(file, dir string) (string, bool) {
+	file = filepath.ToSlash(file)
+	if strings.HasPrefix(dir, embeddedPrefix) {
+		name := path.Join(strings.TrimPrefix(dir, embeddedPrefix), file)
+		if resolved, ok := stdlibFile(name); ok {
+			return resolved, true
+		}
+	}
+	return stdlibFile(path.Clean(file))
+}
+
+// stdlibFile finds name ("stdlib/<file>") in NIKIUM_STDLIB or the embedded
+// copy.
+func stdlibFile(name string) (string, bool) {
+	rel, ok := strings.CutPrefix(name, "stdlib/")
+	if !ok {
+		return "", false
+	}
+
+	if local := os.Getenv("NIKIUM_STDLIB"); local != "" {
+		candidate := filepath.Join(local, filepath.FromSlash(rel))
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			abs, err := filepath.Abs(candidate)
+			return abs, err == nil
+		}
+	}
+	if _, err := fs.Stat(stdlib.Files, rel); err == nil {
+		return embeddedPrefix + name, true
+	}
+	return "", false
+}
+
+// ReadModule returns the source of a file found by ResolveModule.
+func ReadModule(file string) ([]byte, error) {
+	if name, ok := strings.CutPrefix(file, embeddedPrefix); ok {
+		return stdlib.Files.ReadFile(strings.TrimPrefix(name, "stdlib/"))
+	}
+	return os.ReadFile(file)
+}
+
+// moduleCache holds the modules of one program run, so each file is
+// evaluated once, and the chain of imports in progress to detect cycles.
+type moduleCache struct {
+	mu      sync.Mutex
+	loaded  map[string]*Module
+	loading []string
+}
+
+func evalImportStatement(node *ast.ImportStatement, env *Environment) Object {
+	mod := importModule(node.Path.Value, env)
+	if isError(mod) {
+		return mod
+	}
+	name := ModuleName(node.Path.Value)
+	if node.Alias != nil {
+		name = node.Alias.Value
+	}
+	env.Set(name, mod)
+	return nil
+}
+
+func importModule(path string, env *Environment) Object {
+	file, err := ResolveModule(path, env.scriptDir())
+	if err != nil {
+		return &Error{Kind: IMPORT_ERROR, Message: err.Error()}
+	}
+
+	cache := env.moduleCache()
+	cache.mu.Lock()
+	if mod, ok := cache.loaded[file]; ok {
+		cache.mu.Unlock()
+		return mod
+	}
+	for i, loading := range cache.loading {
+		if loading == file {
+			chain := append(append([]string{}, cache.loading[i:]...), file)
+			for j := range chain {
+				chain[j] = ModuleName(chain[j])
+			}
+			cache.mu.Unlock()
+			return &Error{Kind: IMPORT_ERROR, Message: "import cycle: " + strings.Join(chain, " -> ")}
+		}
+	}
+	cache.loading = append(cache.loading, file)
+	cache.mu.Unlock()
+
+	mod, errObj := evalModule(file, cache)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for i := len(cache.loading) - 1; i >= 0; i-- {
+		if cache.loading[i] == file {
+			cache.loading = append(cache.loading[:i], cache.loading[i+1:]...)
+			break
+		}
+	}
+	if errObj != nil {
+		return errObj
+	}
+	cache.loaded[file] = mod
+	return mod
+}
+
+// evalModule runs a module file in a fresh environment that only shares
+// the builtins and the module cache with its importer.
+func evalModule(file string, cache *moduleCache) (*Module, *Error) {
+	content, err := ReadModule(file)
+	if err != nil {
+		return nil, &Error{Kind: IO_ERROR, Message: fmt.Sprintf("could not read module: %s", file)}
+	}
+
+	p := parser.New(lexer.New(string(content)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &Error{Kind: PARSE_ERROR, Message: fmt.Sprintf("failed to parse module %s: %s", file, p.Errors()[0])}
+	}
+
+	env := NewEnclosedEnvironment(NewEnvironment())
+	env.dir = filepath.Dir(file)
+	env.modules = cache
+
+	if result := Eval(program, env); isError(result) {
+		return nil, result.(*Error)
+	}
+	return &Module{Name: ModuleName(file), Path: file, Env: env}, nil
+}
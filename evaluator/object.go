@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -12,8 +14,15 @@ type ObjectType string
 
 type NativeFn func(args []Object) Object
 
+// TaskNativeFn is a native that calls script functions back. It is given
+// the task it was called from, so that those functions run as part of it;
+// the task is nil on the main program and for callers, like the VM, that
+// do not track one.
+type TaskNativeFn func(t *Task, args []Object) Object
+
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
@@ -27,6 +36,36 @@ const (
 	POINTER_OBJ      = "POINTER"
 	BREAK_OBJ        = "BREAK"
 	CONTINUE_OBJ     = "CONTINUE"
+	EXCEPTION_OBJ    = "EXCEPTION"
+	MODULE_OBJ       = "MODULE"
+	INTERFACE_OBJ    = "INTERFACE"
+	ENUM_TYPE_OBJ    = "ENUM_TYPE"
+	ENUM_OBJ         = "ENUM"
+	RANGE_OBJ        = "RANGE"
+	ITERATOR_OBJ     = "ITERATOR"
+	GENERATOR_OBJ    = "GENERATOR"
+	CHANNEL_OBJ      = "CHANNEL"
+	TASK_OBJ         = "TASK"
+	MUTEX_OBJ        = "MUTEX"
+	RWMUTEX_OBJ      = "RWMUTEX"
+	ATOMIC_OBJ       = "ATOMIC"
+	WAITGROUP_OBJ    = "WAITGROUP"
+)
+
+// Error kinds raised by the runtime and builtins. Scripts read them from a
+// caught exception's kind field, so they must stay stable.
+const (
+	RUNTIME_ERROR  = "RuntimeError"
+	ARGUMENT_ERROR = "ArgumentError"
+	TYPE_ERROR     = "TypeError"
+	VALUE_ERROR    = "ValueError"
+	IO_ERROR       = "IOError"
+	PARSE_ERROR    = "ParseError"
+	IMPORT_ERROR   = "ImportError"
+	THROWN_ERROR   = "Error"
+
+	CANCELLED_ERROR = "CancelledError"
+	TIMEOUT_ERROR   = "TimeoutError"
 )
 
 type Object interface {
@@ -59,7 +98,7 @@ func (h *Hash) Inspect() string {
 	var out strings.Builder
 	out.WriteString("{")
 	i := 0
-	for _, pair := range h.Pairs {
+	for _, pair := range h.snapshot() {
 		if i > 0 {
 			out.WriteString(", ")
 		}
@@ -72,6 +111,18 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// snapshot copies the pairs, so they can be walked while tasks change the
+// hash.
+func (h *Hash) snapshot() []HashPair {
+	contentsMu.RLock()
+	defer contentsMu.RUnlock()
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
 // --- Primitives ---
 
 type Integer struct {
@@ -84,6 +135,23 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: INTEGER_OBJ, Value: uint64(i.Value)}
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	// keep a decimal point so 2.0 does not print like the integer 2
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: FLOAT_OBJ, Value: math.Float64bits(f.Value)}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -120,20 +188,92 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Error is a raised error unwinding through evaluation. Kind is empty for
+// errors raised by the interpreter itself, which report as RuntimeError.
 type Error struct {
 	Message     string
+	Kind        string
+	Line        int
+	Column      int
 	HasLocation bool
+	Value       Object // the thrown value, if raised by throw
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "Error: " + e.Message }
+func (e *Error) Inspect() string {
+	prefix := "Error"
+	if e.Kind != "" {
+		prefix = e.Kind
+	}
+	out := prefix + ": " + e.Message
+	if e.HasLocation {
+		out += fmt.Sprintf(" on line %d, col %d", e.Line, e.Column)
+	}
+	return out
+}
+
+// ErrorKind returns the kind scripts see for e.
+func (e *Error) ErrorKind() string {
+	if e.Kind == "" {
+		return RUNTIME_ERROR
+	}
+	return e.Kind
+}
+
+// Exception is a caught error bound to a catch variable. Unlike *Error it is
+// an ordinary value, so it can be stored, passed around and thrown again.
+type Exception struct {
+	Err *Error
+}
+
+func (ex *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (ex *Exception) Inspect() string  { return ex.Err.Inspect() }
+
+// Property returns the message, kind, line, column or value field.
+func (ex *Exception) Property(name string) (Object, bool) {
+	switch name {
+	case "message":
+		return &String{Value: ex.Err.Message}, true
+	case "kind":
+		return &String{Value: ex.Err.ErrorKind()}, true
+	case "line":
+		return &Integer{Value: int64(ex.Err.Line)}, true
+	case "column":
+		return &Integer{Value: int64(ex.Err.Column)}, true
+	case "value":
+		if ex.Err.Value != nil {
+			return ex.Err.Value, true
+		}
+		return &String{Value: ex.Err.Message}, true
+	}
+	return nil, false
+}
 
 type Function struct {
 	Parameters  []*ast.Identifier
 	Body        *ast.BlockStatement
 	Env         *Environment
 	Native      NativeFn
-	GenericType string // e.g. "T" — unresolved generic param name
+	TaskNative  TaskNativeFn     // used instead of Native by callers that know the task
+	TypeParams  []*ast.TypeParam // from generic<K, V>
+	ParamTypes  []*ast.TypeExpr  // annotation of each parameter, nil if none
+	Defaults    []ast.Expression // default value of each parameter, nil if none
+	Rest        *ast.Identifier  // collects the arguments past Parameters, nil if none
+	Generator   bool             // the body yields, so calls return a *Generator
+}
+
+// Callable is a function value from outside the evaluator, such as a
+// compiled closure, that natives and iterators can still call.
+type Callable interface {
+	Object
+	Call(args []Object) Object
+}
+
+// Method is a Callable that can be bound to the struct it was read from,
+// the way bindReceiver binds a *Function.
+type Method interface {
+	Callable
+	Bind(receiver Object) Object
 }
 
 func (f *Function) Type() ObjectType {
@@ -149,8 +289,15 @@ func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
+	for i, p := range f.Parameters {
+		param := p.String()
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			param += " = " + f.Defaults[i].String()
+		}
+		params = append(params, param)
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
 	}
 
 	out.WriteString("fn")
@@ -184,9 +331,10 @@ func (a *Array) Type() ObjectType { return ARRAY_OBJ }
 func (a *Array) Inspect() string {
 	var out strings.Builder
 	out.WriteString("[")
-	for i, el := range a.Elements {
+	elements := a.snapshot()
+	for i, el := range elements {
 		out.WriteString(el.Inspect())
-		if i != len(a.Elements)-1 {
+		if i != len(elements)-1 {
 			out.WriteString(", ")
 		}
 	}
@@ -194,10 +342,22 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+// snapshot copies the elements, so they can be walked while tasks change
+// the array.
+func (a *Array) snapshot() []Object {
+	contentsMu.RLock()
+	defer contentsMu.RUnlock()
+	return append([]Object(nil), a.Elements...)
+}
+
 type Struct struct {
-	Properties   map[string]Object
-	GenericTypes map[string]string // e.g. "T" → "int" — resolved generic bindings
-	ClassName    string            // e.g. "p"
+	Properties    map[string]Object
+	TypeParams    []*ast.TypeParam    // from generic<K, V>
+	GenericTypes  map[string]*TypeArg // e.g. "T" → int — resolved generic bindings
+	GenericFields map[string]string   // fields declared with a type parameter, to its name
+	ClassName     string              // e.g. "p"
+	Embeds       []string          // names of the embedded structs, outermost first
+	destroyed     bool                // set when the scope that declared it ended; guarded by contentsMu
 }
 
 func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
@@ -205,7 +365,17 @@ func (s *Struct) Inspect() string {
 	var out strings.Builder
 	out.WriteString("struct{")
 	i := 0
+	contentsMu.RLock()
+	if s.destroyed {
+		contentsMu.RUnlock()
+		return fmt.Sprintf("<destroyed %s>", s.ClassName)
+	}
+	fields := make(map[string]Object, len(s.Properties))
 	for k, v := range s.Properties {
+		fields[k] = v
+	}
+	contentsMu.RUnlock()
+	for k, v := range fields {
 		if i > 0 {
 			out.WriteString(", ")
 		}
@@ -218,10 +388,12 @@ func (s *Struct) Inspect() string {
 	return out.String()
 }
 
+// Pointer refers to a struct instance created with new, whose fields live
+// on the memory heap until delete frees them.
 type Pointer struct {
-	Value Object
+	alloc *allocation
 }
 
 func (p *Pointer) Type() ObjectType { return POINTER_OBJ }
-func (p *Pointer) Inspect() string  { return "*" + p.Value.Inspect() }
+func (p *Pointer) Inspect() string  { return "*" + p.inspectFields() }
 
@@ -0,0 +1,293 @@
+package evaluator
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Fielded is a native object whose fields and methods scripts read with a
+// dot, such as a task or a mutex.
+type Fielded interface {
+	Object
+	Property(name string) (Object, bool)
+}
+
+// method returns a native function taking exactly arity arguments, the
+// form the fields of a Fielded object take.
+func method(name string, arity int, fn func(args []Object) Object) *Function {
+	return &Function{Native: func(args []Object) Object {
+		if len(args) != arity {
+			return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("%s: expected %d arguments, got %d", name, arity, len(args))}
+		}
+		return fn(args)
+	}}
+}
+
+// Mutex lets one task at a time through. Unlocking a mutex that is not
+// locked is a ValueError rather than the crash it is in Go.
+type Mutex struct {
+	mu     sync.Mutex
+	locked atomic.Bool
+}
+
+func (m *Mutex) Type() ObjectType { return MUTEX_OBJ }
+func (m *Mutex) Inspect() string  { return "mutex" }
+
+func (m *Mutex) Lock() {
+	m.mu.Lock()
+	m.locked.Store(true)
+}
+
+func (m *Mutex) Unlock() *Error {
+	if !m.locked.CompareAndSwap(true, false) {
+		return &Error{Kind: VALUE_ERROR, Message: "unlock: mutex is not locked"}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Property returns the lock and unlock methods.
+func (m *Mutex) Property(name string) (Object, bool) {
+	switch name {
+	case "lock":
+		return method(name, 0, func([]Object) Object { m.Lock(); return NULL }), true
+	case "unlock":
+		return method(name, 0, func([]Object) Object { return nullOr(m.Unlock()) }), true
+	}
+	return nil, false
+}
+
+// RWMutex lets any number of readers or a single writer through.
+type RWMutex struct {
+	mu      sync.RWMutex
+	readers atomic.Int64
+	writer  atomic.Bool
+}
+
+func (m *RWMutex) Type() ObjectType { return RWMUTEX_OBJ }
+func (m *RWMutex) Inspect() string  { return "rwmutex" }
+
+func (m *RWMutex) Lock() {
+	m.mu.Lock()
+	m.writer.Store(true)
+}
+
+func (m *RWMutex) Unlock() *Error {
+	if !m.writer.CompareAndSwap(true, false) {
+		return &Error{Kind: VALUE_ERROR, Message: "unlock: rwmutex is not locked"}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *RWMutex) RLock() {
+	m.mu.RLock()
+	m.readers.Add(1)
+}
+
+func (m *RWMutex) RUnlock() *Error {
+	if m.readers.Add(-1) < 0 {
+		m.readers.Add(1)
+		return &Error{Kind: VALUE_ERROR, Message: "runlock: rwmutex is not read-locked"}
+	}
+	m.mu.RUnlock()
+	return nil
+}
+
+// Property returns the lock, unlock, rlock and runlock methods.
+func (m *RWMutex) Property(name string) (Object, bool) {
+	switch name {
+	case "lock":
+		return method(name, 0, func([]Object) Object { m.Lock(); return NULL }), true
+	case "unlock":
+		return method(name, 0, func([]Object) Object { return nullOr(m.Unlock()) }), true
+	case "rlock":
+		return method(name, 0, func([]Object) Object { m.RLock(); return NULL }), true
+	case "runlock":
+		return method(name, 0, func([]Object) Object { return nullOr(m.RUnlock()) }), true
+	}
+	return nil, false
+}
+
+// nullOr returns errObj, or null when there is no error.
+func nullOr(errObj *Error) Object {
+	if errObj != nil {
+		return errObj
+	}
+	return NULL
+}
+
+// Atomic is an integer that tasks can update without a lock.
+type Atomic struct {
+	value atomic.Int64
+}
+
+func (a *Atomic) Type() ObjectType { return ATOMIC_OBJ }
+func (a *Atomic) Inspect() string  { return fmt.Sprintf("atomic(%d)", a.value.Load()) }
+
+// Property returns the get, set, add and cas methods. add returns the
+// new value; cas(old, new) stores new only if the value is still old, and
+// reports whether it did.
+func (a *Atomic) Property(name string) (Object, bool) {
+	switch name {
+	case "get":
+		return method(name, 0, func([]Object) Object { return &Integer{Value: a.value.Load()} }), true
+	case "set":
+		return method(name, 1, func(args []Object) Object {
+			n, errObj := integerArg(name, args[0])
+			if errObj != nil {
+				return errObj
+			}
+			a.value.Store(n)
+			return NULL
+		}), true
+	case "add":
+		return method(name, 1, func(args []Object) Object {
+			n, errObj := integerArg(name, args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return &Integer{Value: a.value.Add(n)}
+		}), true
+	case "cas":
+		return method(name, 2, func(args []Object) Object {
+			old, errObj := integerArg(name, args[0])
+			if errObj != nil {
+				return errObj
+			}
+			n, errObj := integerArg(name, args[1])
+			if errObj != nil {
+				return errObj
+			}
+			return nativeBoolToBooleanObject(a.value.CompareAndSwap(old, n))
+		}), true
+	}
+	return nil, false
+}
+
+func integerArg(name string, arg Object) (int64, *Error) {
+	n, ok := arg.(*Integer)
+	if !ok {
+		return 0, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s: expected an integer, got %s", name, arg.Type())}
+	}
+	return n.Value, nil
+}
+
+// WaitGroup waits for a count of tasks to finish. A count that would go
+// below zero is a ValueError. mu makes checking the count and updating it
+// and wg one step, so concurrent calls cannot pass the check together.
+type WaitGroup struct {
+	mu    sync.Mutex
+	wg    sync.WaitGroup
+	count int64
+}
+
+func (w *WaitGroup) Type() ObjectType { return WAITGROUP_OBJ }
+
+func (w *WaitGroup) Inspect() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return fmt.Sprintf("waitgroup(%d)", w.count)
+}
+
+func (w *WaitGroup) Add(n int64) *Error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.count+n < 0 {
+		return &Error{Kind: VALUE_ERROR, Message: "waitgroup: count would go below zero"}
+	}
+	w.count += n
+	w.wg.Add(int(n))
+	return nil
+}
+
+// Property returns the add, done and wait methods.
+func (w *WaitGroup) Property(name string) (Object, bool) {
+	switch name {
+	case "add":
+		return method(name, 1, func(args []Object) Object {
+			n, errObj := integerArg(name, args[0])
+			if errObj != nil {
+				return errObj
+			}
+			return nullOr(w.Add(n))
+		}), true
+	case "done":
+		return method(name, 0, func([]Object) Object { return nullOr(w.Add(-1)) }), true
+	case "wait":
+		return method(name, 0, func([]Object) Object { w.wg.Wait(); return NULL }), true
+	}
+	return nil, false
+}
+
+func nativeMutex(args []Object) Object {
+	if len(args) != 0 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("mutex: expected 0 arguments, got %d", len(args))}
+	}
+	return &Mutex{}
+}
+
+func nativeRWMutex(args []Object) Object {
+	if len(args) != 0 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("rwmutex: expected 0 arguments, got %d", len(args))}
+	}
+	return &RWMutex{}
+}
+
+// nativeAtomic is atomic() or atomic(n), an atomic integer starting at n.
+func nativeAtomic(args []Object) Object {
+	if len(args) > 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("atomic: expected 0 to 1 arguments, got %d", len(args))}
+	}
+	a := &Atomic{}
+	if len(args) == 1 {
+		n, errObj := integerArg("atomic", args[0])
+		if errObj != nil {
+			return errObj
+		}
+		a.value.Store(n)
+	}
+	return a
+}
+
+func nativeWaitGroup(args []Object) Object {
+	if len(args) != 0 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("waitgroup: expected 0 arguments, got %d", len(args))}
+	}
+	return &WaitGroup{}
+}
+
+// nativeWithLock is with_lock(m, fn): it calls fn holding m, a mutex or the
+// write side of an rwmutex, and unlocks m however fn returns.
+func nativeWithLock(t *Task, args []Object) Object {
+	if len(args) != 2 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("with_lock: expected 2 arguments (mutex, function), got %d", len(args))}
+	}
+	switch m := args[0].(type) {
+	case *Mutex:
+		m.Lock()
+		defer m.Unlock()
+	case *RWMutex:
+		m.Lock()
+		defer m.Unlock()
+	default:
+		return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("with_lock: expected a mutex, got %s", args[0].Type())}
+	}
+	return applyFunctionAs(t, args[1], []Object{})
+}
+
+// nativeWithRLock is with_rlock(m, fn): with_lock for the read side of an
+// rwmutex.
+func nativeWithRLock(t *Task, args []Object) Object {
+	if len(args) != 2 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("with_rlock: expected 2 arguments (rwmutex, function), got %d", len(args))}
+	}
+	m, ok := args[0].(*RWMutex)
+	if !ok {
+		return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("with_rlock: expected an rwmutex, got %s", args[0].Type())}
+	}
+	m.RLock()
+	defer m.RUnlock()
+	return applyFunctionAs(t, args[1], []Object{})
+}
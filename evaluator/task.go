@@ -0,0 +1,283 @@
+package evaluator
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Task is the handle spawn returns for one run of a function. done is
+// closed once result is set.
+type Task struct {
+	ID        int64
+	done      chan struct{}
+	result    Object
+	cancelled atomic.Bool
+}
+
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+func (t *Task) Inspect() string  { return fmt.Sprintf("<task %d %s>", t.ID, t.Status()) }
+
+// Status is pending until the function returns, then done, or failed if it
+// returned an error.
+func (t *Task) Status() string {
+	select {
+	case <-t.done:
+		if isError(t.result) {
+			return "failed"
+		}
+		return "done"
+	default:
+		return "pending"
+	}
+}
+
+// Wait blocks until the task has finished and returns its result.
+func (t *Task) Wait() Object {
+	<-t.done
+	return t.result
+}
+
+// Property returns the id or status field, or the result method, which
+// waits for the task like await.
+func (t *Task) Property(name string) (Object, bool) {
+	switch name {
+	case "id":
+		return &Integer{Value: t.ID}, true
+	case "status":
+		return &String{Value: t.Status()}, true
+	case "result":
+		return method(name, 0, func([]Object) Object { return t.Wait() }), true
+	}
+	return nil, false
+}
+
+// spawnTask runs fn on a goroutine of its own. The task is in asyncJobs
+// while it runs.
+func spawnTask(fn Object, args []Object) *Task {
+	t := &Task{done: make(chan struct{})}
+	asyncMu.Lock()
+	asyncNextID++
+	t.ID = asyncNextID
+	asyncJobs[t.ID] = t
+	asyncMu.Unlock()
+
+	go func() {
+		if errObj := t.check(); errObj != nil {
+			t.result = errObj
+		} else {
+			t.result = applyFunctionAs(t, fn, args)
+		}
+		asyncMu.Lock()
+		delete(asyncJobs, t.ID)
+		asyncMu.Unlock()
+		close(t.done)
+	}()
+	return t
+}
+
+// cancel asks the task to stop, and reports whether it was still running.
+// The task notices at its next loop iteration or call.
+func (t *Task) cancel() bool {
+	t.cancelled.Store(true)
+	return t.Status() == "pending"
+}
+
+// check returns the error a cancelled task unwinds with, nil if it has not
+// been cancelled.
+func (t *Task) check() *Error {
+	if t == nil || !t.cancelled.Load() {
+		return nil
+	}
+	return &Error{Kind: CANCELLED_ERROR, Message: "task cancelled"}
+}
+
+// taskNative makes a builtin of fn. Callers that only know Native run it
+// with a nil task.
+func taskNative(fn TaskNativeFn) *Function {
+	return &Function{Native: func(args []Object) Object { return fn(nil, args) }, TaskNative: fn}
+}
+
+func taskArg(name string, arg Object) (*Task, *Error) {
+	t, ok := arg.(*Task)
+	if !ok {
+		return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s: expected a task, got %s", name, arg.Type())}
+	}
+	return t, nil
+}
+
+func taskArgs(name string, arg Object) ([]*Task, *Error) {
+	arr, ok := arg.(*Array)
+	if !ok {
+		return nil, &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("%s: expected an array of tasks, got %s", name, arg.Type())}
+	}
+	elements := arr.snapshot()
+	tasks := make([]*Task, len(elements))
+	for i, elem := range elements {
+		t, errObj := taskArg(name, elem)
+		if errObj != nil {
+			return nil, errObj
+		}
+		tasks[i] = t
+	}
+	return tasks, nil
+}
+
+// nativeSpawn is spawn(fn, args...): it calls fn with args on a task of
+// its own and returns the task.
+func nativeSpawn(args []Object) Object {
+	if len(args) < 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: "spawn: expected at least 1 argument (function)"}
+	}
+	fn, ok := args[0].(*Function)
+	if !ok {
+		return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("spawn: expected a function, got %s", args[0].Type())}
+	}
+	args = args[1:]
+	if fn.Native == nil {
+		if errObj := ArityError(fn.required(), len(fn.Parameters), fn.Rest != nil, len(args)); errObj != nil {
+			errObj.Message = "spawn: " + errObj.Message
+			return errObj
+		}
+	}
+	return spawnTask(fn, args)
+}
+
+// nativeAwait is await(task): the task's result, once it has finished.
+func nativeAwait(args []Object) Object {
+	if len(args) != 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: "await: expected 1 argument (task)"}
+	}
+	t, errObj := taskArg("await", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return t.Wait()
+}
+
+// nativeAwaitTimeout is await_timeout(task, ms): await that gives up with
+// a TimeoutError after ms milliseconds. The task keeps running.
+func nativeAwaitTimeout(args []Object) Object {
+	if len(args) != 2 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("await_timeout: expected 2 arguments (task, ms), got %d", len(args))}
+	}
+	t, errObj := taskArg("await_timeout", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	ms, ok := args[1].(*Integer)
+	if !ok {
+		return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("await_timeout: expected integer milliseconds, got %s", args[1].Type())}
+	}
+	timer := time.NewTimer(time.Duration(ms.Value) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return t.result
+	case <-timer.C:
+		return &Error{Kind: TIMEOUT_ERROR, Message: fmt.Sprintf("await_timeout: task %d did not finish within %d ms", t.ID, ms.Value)}
+	}
+}
+
+// nativeCancel is cancel(task). It returns whether the task was still
+// running; await then gives its CancelledError.
+func nativeCancel(args []Object) Object {
+	if len(args) != 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("cancel: expected 1 argument (task), got %d", len(args))}
+	}
+	t, errObj := taskArg("cancel", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return nativeBoolToBooleanObject(t.cancel())
+}
+
+// nativeAwaitAll is await_all(tasks): an array of the tasks' results, in
+// order. It stops at the first task, in that order, that failed and
+// returns its error.
+func nativeAwaitAll(args []Object) Object {
+	if len(args) != 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("await_all: expected 1 argument (array of tasks), got %d", len(args))}
+	}
+	tasks, errObj := taskArgs("await_all", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	results := make([]Object, len(tasks))
+	for i, t := range tasks {
+		if results[i] = t.Wait(); isError(results[i]) {
+			return results[i]
+		}
+	}
+	return &Array{Elements: results}
+}
+
+// nativeAwaitAny is await_any(tasks): the first of the tasks to finish.
+func nativeAwaitAny(args []Object) Object {
+	if len(args) != 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("await_any: expected 1 argument (array of tasks), got %d", len(args))}
+	}
+	tasks, errObj := taskArgs("await_any", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	if len(tasks) == 0 {
+		return &Error{Kind: VALUE_ERROR, Message: "await_any: no tasks to wait for"}
+	}
+	chosen, _, _ := reflect.Select(doneCases(tasks))
+	return tasks[chosen]
+}
+
+// nativeTaskGroup is task_group(fns): it runs each function as a task and
+// returns their results in order. When one fails the others are cancelled,
+// and once they have all stopped the group returns the first error.
+func nativeTaskGroup(args []Object) Object {
+	if len(args) != 1 {
+		return &Error{Kind: ARGUMENT_ERROR, Message: fmt.Sprintf("task_group: expected 1 argument (array of functions), got %d", len(args))}
+	}
+	fns, ok := args[0].(*Array)
+	if !ok {
+		return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("task_group: expected an array of functions, got %s", args[0].Type())}
+	}
+	elements := fns.snapshot()
+	for i, fn := range elements {
+		if _, ok := fn.(*Function); !ok {
+			return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("task_group: expected an array of functions, got %s at index %d", fn.Type(), i)}
+		}
+	}
+	tasks := make([]*Task, len(elements))
+	for i, fn := range elements {
+		tasks[i] = spawnTask(fn, []Object{})
+	}
+
+	var failed Object
+	cases := doneCases(tasks)
+	for range tasks {
+		chosen, _, _ := reflect.Select(cases)
+		cases[chosen].Chan = reflect.Value{}
+		if failed == nil && isError(tasks[chosen].result) {
+			failed = tasks[chosen].result
+			for _, t := range tasks {
+				t.cancel()
+			}
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	results := make([]Object, len(tasks))
+	for i, t := range tasks {
+		results[i] = t.result
+	}
+	return &Array{Elements: results}
+}
+
+// doneCases waits on the done channel of each task.
+func doneCases(tasks []*Task) []reflect.SelectCase {
+	cases := make([]reflect.SelectCase, len(tasks))
+	for i, t := range tasks {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.done)}
+	}
+	return cases
+}
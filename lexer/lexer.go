@@ -14,7 +14,13 @@ type Lexer struct {
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input, line: 1, column: 0}
+	return NewAt(input, 1, 1)
+}
+
+// NewAt lexes input as if it started at line and column of a larger
+// source, so the positions of its tokens point into that source.
+func NewAt(input string, line, column int) *Lexer {
+	l := &Lexer{input: input, line: line, column: column - 1}
 	l.readChar()
 	return l
 }
@@ -51,6 +57,10 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Line: tokLine, Column: tokCol, Type: token.EQ, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Line: tokLine, Column: tokCol, Type: token.FAT_ARROW, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = l.newToken(token.ASSIGN, l.ch)
 		}
@@ -113,7 +123,13 @@ func (l *Lexer) NextToken() token.Token {
 			tok = l.newToken(token.GT, l.ch)
 		}
 	case '.':
-		tok = l.newToken(token.DOT, l.ch)
+		if l.peekChar() == '.' && l.readPosition+1 < len(l.input) && l.input[l.readPosition+1] == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Line: tokLine, Column: tokCol, Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = l.newToken(token.DOT, l.ch)
+		}
 	case ';':
 		tok = l.newToken(token.SEMICOLON, l.ch)
 	case ':':
@@ -133,8 +149,15 @@ func (l *Lexer) NextToken() token.Token {
 	case ']':
 		tok = l.newToken(token.RBRACKET, l.ch)
 	case '"':
+		start := l.position + 1
+		str, template := l.readString()
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = str
+		if template {
+			// the parser splits the raw source with SplitTemplate
+			tok.Type = token.TEMPLATE
+			tok.Literal = l.input[start:min(l.position, len(l.input))]
+		}
 		tok.Line = tokLine
 		tok.Column = tokCol
 	case '&':
@@ -167,8 +190,11 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Column = tokCol
 			return tok
 		} else if isDigit(l.ch) {
-			lit := l.readNumber()
+			lit, isFloat := l.readNumber()
 			tok.Type = token.INT
+			if isFloat {
+				tok.Type = token.FLOAT
+			}
 			tok.Literal = lit
 			tok.Line = tokLine
 			tok.Column = tokCol
@@ -196,42 +222,125 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[pos:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or, when a '.' is followed by a digit, a
+// decimal float; "1.x" still lexes as 1 followed by a property access.
+func (l *Lexer) readNumber() (string, bool) {
 	pos := l.position
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[pos:l.position]
+	isFloat := false
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		isFloat = true
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	return l.input[pos:l.position], isFloat
 }
 
-func (l *Lexer) readString() string {
-	var str string
+// readString reads a string literal up to its closing quote. template
+// reports whether it interpolates ${...} expressions, whose source is
+// skipped here.
+func (l *Lexer) readString() (str string, template bool) {
 	for {
 		l.readChar()
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
-		if l.ch == '\\' {
+		if l.ch == '$' && l.peekChar() == '{' {
+			template = true
 			l.readChar()
-			switch l.ch {
-			case 'n':
-				str += "\n"
-			case 't':
-				str += "\t"
-			case '\\':
-				str += "\\"
-			case '"':
-				str += "\""
-			case 0:
-				break
-			default:
-				str += "\\" + string(l.ch)
-			}
+			l.skipInterpolation()
+		} else if l.ch == '\\' {
+			l.readChar()
+			str += escape(l.ch)
 		} else {
 			str += string(l.ch)
 		}
 	}
-	return str
+	return str, template
+}
+
+// escape returns what the escape sequence ending in ch stands for.
+func escape(ch byte) string {
+	switch ch {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case '\\', '"', '$':
+		return string(ch)
+	case 0:
+		return ""
+	}
+	return "\\" + string(ch)
+}
+
+// skipInterpolation moves from the '{' of a ${ to its closing '}', past
+// nested braces and strings.
+func (l *Lexer) skipInterpolation() {
+	depth := 1
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			return
+		case '"':
+			l.readString()
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// TemplatePart is a piece of a template string: literal text, or the
+// source of a ${...} expression and the position it starts at.
+type TemplatePart struct {
+	Text   string
+	Expr   string
+	IsExpr bool
+	Line   int
+	Column int
+}
+
+// SplitTemplate splits the literal of a TEMPLATE token into its text, with
+// escapes applied, and its expressions. ok is false if a ${ is never closed.
+func SplitTemplate(tok token.Token) (parts []TemplatePart, ok bool) {
+	l := NewAt(tok.Literal, tok.Line, tok.Column+1)
+	text := ""
+	for l.ch != 0 {
+		switch {
+		case l.ch == '$' && l.peekChar() == '{':
+			if text != "" {
+				parts = append(parts, TemplatePart{Text: text})
+				text = ""
+			}
+			l.readChar()
+			start, line, column := l.readPosition, l.line, l.column+1
+			l.skipInterpolation()
+			if l.ch == 0 {
+				return parts, false
+			}
+			parts = append(parts, TemplatePart{Expr: l.input[start:l.position], IsExpr: true, Line: line, Column: column})
+		case l.ch == '\\':
+			l.readChar()
+			text += escape(l.ch)
+		default:
+			text += string(l.ch)
+		}
+		l.readChar()
+	}
+	if text != "" {
+		parts = append(parts, TemplatePart{Text: text})
+	}
+	return parts, true
 }
 
 func (l *Lexer) skipLineComment() {
@@ -30,6 +30,9 @@ if (5 < 10) {
 1 <= 2 >= 3 % 4;
 "foo\n\t\\\"bar"
 break continue
+3.14 p.x 1.y
+match [a, ...r] => a
+enum E
 `
 
 	tests := []struct {
@@ -122,6 +125,24 @@ break continue
 		{"STRING", "foo\n\t\\\"bar"},
 		{"BREAK", "break"},
 		{"CONTINUE", "continue"},
+		{"FLOAT", "3.14"},
+		{"IDENT", "p"},
+		{".", "."},
+		{"IDENT", "x"},
+		{"INT", "1"},
+		{".", "."},
+		{"IDENT", "y"},
+		{"MATCH", "match"},
+		{"[", "["},
+		{"IDENT", "a"},
+		{",", ","},
+		{"...", "..."},
+		{"IDENT", "r"},
+		{"]", "]"},
+		{"=>", "=>"},
+		{"IDENT", "a"},
+		{"ENUM", "enum"},
+		{"IDENT", "E"},
 		{"EOF", ""},
 	}
 
@@ -141,3 +162,33 @@ break continue
 		}
 	}
 }
+
+func TestTemplateString(t *testing.T) {
+	l := New(`"a ${x + "}"} \${b}" "plain \$"`)
+	tok := l.NextToken()
+	if tok.Type != "TEMPLATE" || tok.Literal != `a ${x + "}"} \${b}` {
+		t.Fatalf("wrong template token. got=%q %q", tok.Type, tok.Literal)
+	}
+	if next := l.NextToken(); next.Type != "STRING" || next.Literal != "plain $" {
+		t.Fatalf("wrong string token. got=%q %q", next.Type, next.Literal)
+	}
+
+	parts, ok := SplitTemplate(tok)
+	expected := []TemplatePart{
+		{Text: "a "},
+		{Expr: `x + "}"`, IsExpr: true, Line: 1, Column: 6},
+		{Text: " ${b}"},
+	}
+	if !ok || len(parts) != len(expected) {
+		t.Fatalf("wrong parts. got=%+v", parts)
+	}
+	for i, part := range parts {
+		if part != expected[i] {
+			t.Errorf("parts[%d] wrong. want=%+v, got=%+v", i, expected[i], part)
+		}
+	}
+
+	if _, ok := SplitTemplate(New(`"${x"`).NextToken()); ok {
+		t.Errorf("unclosed ${ was accepted")
+	}
+}
@@ -1,37 +1,55 @@
 package main
 
 import (
+	"Nikium/ast"
+	"Nikium/compiler"
 	"Nikium/evaluator"
 	"Nikium/lexer"
 	"Nikium/parser"
 	"Nikium/repl"
+	"Nikium/typecheck"
+	"Nikium/vm"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 func main() {
-	env := evaluator.NewEnvironment()
+	useVM := flag.Bool("vm", false, "run the script on the bytecode VM instead of the tree-walking evaluator")
+	flag.Parse()
 
-	if len(os.Args) > 1 {
-		filePath := os.Args[1]
-
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
+	if flag.Arg(0) == "check" {
+		if flag.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "usage: nikium check <file.nik>...")
+			os.Exit(2)
+		}
+		failed := false
+		for _, filePath := range flag.Args()[1:] {
+			if !check(filePath) {
+				failed = true
+			}
+		}
+		if failed {
 			os.Exit(1)
 		}
+		return
+	}
 
-		l := lexer.New(string(content))
-		p := parser.New(l)
-		program := p.ParseProgram()
-
-		if len(p.Errors()) != 0 {
-			for _, err := range p.Errors() {
-				fmt.Fprintln(os.Stderr, "Parser error:", err)
-			}
+	if flag.NArg() > 0 {
+		filePath := flag.Arg(0)
+		program, ok := parseFile(filePath)
+		if !ok {
 			os.Exit(1)
 		}
 
+		if *useVM && runVM(program, filepath.Dir(filePath)) {
+			return
+		}
+
+		env := evaluator.NewEnvironment()
+		env.SetDir(filepath.Dir(filePath))
 		result := evaluator.Eval(program, env)
 		if result != nil && result.Type() == evaluator.ERROR_OBJ {
 			fmt.Fprintln(os.Stderr, result.Inspect())
@@ -41,3 +59,65 @@ func main() {
 		repl.Start(os.Stdin, os.Stdout)
 	}
 }
+
+// runVM compiles and runs a program on the bytecode VM. It reports false,
+// without running anything, when the program uses a feature the compiler
+// does not support, so the caller can use the evaluator instead.
+func runVM(program *ast.Program, dir string) bool {
+	comp := compiler.New()
+	comp.SetDir(dir)
+	if err := comp.Compile(program); err != nil {
+		var unsupported *compiler.UnsupportedError
+		if errors.As(err, &unsupported) {
+			fmt.Fprintf(os.Stderr, "Note: %s; running on the evaluator\n", err)
+			return false
+		}
+		fmt.Fprintln(os.Stderr, "Compiler error:", err)
+		os.Exit(1)
+	}
+
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// parseFile reads and parses a script, reporting any errors.
+func parseFile(filePath string) (*ast.Program, bool) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
+		return nil, false
+	}
+
+	l := lexer.New(string(content))
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		for _, err := range p.Errors() {
+			fmt.Fprintln(os.Stderr, "Parser error:", err)
+		}
+		return nil, false
+	}
+	for _, warning := range p.Warnings() {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+	return program, true
+}
+
+// check type-checks a script without running it and reports whether it
+// is clean.
+func check(filePath string) bool {
+	program, ok := parseFile(filePath)
+	if !ok {
+		return false
+	}
+	errs := typecheck.Check(program)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filePath, err)
+	}
+	return len(errs) == 0
+}
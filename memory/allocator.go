@@ -1,37 +1,106 @@
 package memory
 
 import (
-	"syscall"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"sort"
 	"unsafe"
 )
 
 // the structure
-// header of size 8 bytes | user data | footer of size 8 bytes
+// header of size 4 bytes | user data | footer of size 4 bytes
+//
+// Both tags hold the block size (a multiple of 8, tags included) with the
+// lowest bit set while the block is free. A free block keeps the addresses
+// of its neighbours in its size class list in the first 16 bytes of its
+// user data, so every block is at least minBlock bytes.
 
-type ttags struct {
-	ptr  uintptr
-	size uint32
-}
+const (
+	tagSize  = 4
+	minBlock = 24
+	// numClasses covers block sizes up to the 32-bit tag limit
+	numClasses = 33
+)
+
+var N int32 = 100 * 1024 * 1024 // size of each arena the heap maps in bytes
+
+// MaxAlloc is the largest request Malloc accepts, so that the block that
+// holds it, tags included, still fits in a 32-bit tag.
+const MaxAlloc = math.MaxUint32&^7 - 2*tagSize
 
-var N int32 = 100 * 1024 * 1024 //first allocation of the heap in bytes
-var heap []byte                 //heap memory
+// ErrTooLarge is the error Malloc panics with for requests over MaxAlloc,
+// as it does with the error from mapping an arena.
+var ErrTooLarge = errors.New("memory: allocation larger than MaxAlloc")
 
-var freememory = make(map[ttags]bool) //first element points to the starting index of the block and
+// arena is one contiguous region of the heap. The heap grows by mapping
+// another arena, so addresses handed out earlier stay valid.
+type arena struct {
+	mem   []byte
+	start uintptr
+	end   uintptr
+	top   uintptr // blocks are carved from [start, top); [top, end) is untouched
+}
+
+var arenas []*arena // sorted by start address
 
-var heapstrtindex uintptr = 0
-var heapendindex uintptr = 0
-var currheapidx uintptr = 0
+// freelists[c] is the address of the first free block whose size has bit
+// length c, or 0. New free blocks are pushed at the front, so placement
+// only depends on the order of Malloc and Free calls.
+var freelists [numClasses]uintptr
 
 func initheap(size int) {
-	var err error
-	heap, err = syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	arenas = nil
+	freelists = [numClasses]uintptr{}
+	grow(size)
+}
 
+// grow maps a new arena of at least size bytes. The unused tail of the
+// previous arena goes on a free list so it is not lost.
+func grow(size int) *arena {
+	if len(arenas) > 0 {
+		last := arenas[len(arenas)-1]
+		if rest := last.end - last.top; rest >= minBlock {
+			rest &^= 7
+			block := last.top
+			last.top += rest
+			write_tag(uint32(rest), block, true)
+			write_tag(uint32(rest), block+rest-tagSize, true)
+			push_free(block, uint32(rest))
+		}
+	}
+
+	mem, err := mapHeap(size)
 	if err != nil {
 		panic(err)
 	}
-	heapstrtindex = uintptr(unsafe.Pointer(&heap[0]))
-	heapendindex = heapstrtindex + uintptr(size)
-	currheapidx = heapstrtindex
+	start := uintptr(unsafe.Pointer(&mem[0]))
+	a := &arena{mem: mem, start: start, end: start + uintptr(size), top: start}
+	arenas = append(arenas, a)
+	sort.Slice(arenas, func(i, j int) bool { return arenas[i].start < arenas[j].start })
+	return a
+}
+
+// arena_of returns the arena holding addr.
+func arena_of(addr uintptr) *arena {
+	i := sort.Search(len(arenas), func(i int) bool { return arenas[i].end > addr })
+	if i == len(arenas) || addr < arenas[i].start {
+		panic("memory: address outside the heap")
+	}
+	return arenas[i]
+}
+
+func load32(addr uintptr) uint32 {
+	a := arena_of(addr)
+	off := addr - a.start
+	return binary.LittleEndian.Uint32(a.mem[off : off+4])
+}
+
+func store32(addr uintptr, val uint32) {
+	a := arena_of(addr)
+	off := addr - a.start
+	binary.LittleEndian.PutUint32(a.mem[off:off+4], val)
 }
 
 // write the tag of the block
@@ -44,94 +113,190 @@ func write_tag(sz uint32, addr uintptr, isfree bool) {
 	} else {
 		val &= ^uint32(1)
 	}
-	// binary.LittleEndian.PutUint32(heap[cur_byte_idx:cur_byte_idx+4], val)
+	store32(addr, val)
+}
+
+func read_tag(addr uintptr) (uint32, bool) {
+	val := load32(addr)
+	return val &^ 1, val&1 == 1
+}
+
+// size_class is the free list a block of sz bytes belongs to.
+func size_class(sz uint32) int {
+	return bits.Len32(sz)
+}
 
-	// cur_byte_idx += 4
-	curr := unsafe.Pointer(addr)
-	if uintptr(curr)+4 > heapendindex {
-		panic("out of memory")
+// the list links of a free block live right after its header
+func next_free(block uintptr) uintptr { return uintptr(Load64(block + tagSize)) }
+func prev_free(block uintptr) uintptr { return uintptr(Load64(block + tagSize + 8)) }
+func set_next(block, next uintptr)    { Store64(block+tagSize, uint64(next)) }
+func set_prev(block, prev uintptr)    { Store64(block+tagSize+8, uint64(prev)) }
+
+func push_free(block uintptr, sz uint32) {
+	c := size_class(sz)
+	head := freelists[c]
+	set_next(block, head)
+	set_prev(block, 0)
+	if head != 0 {
+		set_prev(head, block)
 	}
-	*(*uint32)(curr) = val
+	freelists[c] = block
 }
 
-// for now we will do a linear search to find the memory(req)
-func find_mem(size uint32) ttags {
-	for x := range freememory {
-		if x.size >= size {
-			return x
-		}
+func remove_free(block uintptr, sz uint32) {
+	next, prev := next_free(block), prev_free(block)
+	if prev != 0 {
+		set_next(prev, next)
+	} else {
+		freelists[size_class(sz)] = next
+	}
+	if next != 0 {
+		set_prev(next, prev)
 	}
-	return ttags{}
 }
 
-func Malloc(size uint) uintptr {
-	if (size % 8) != 0 {
-		var rem = size / 8
-		size = (rem + 1) * 8
+// find_mem returns the first free block of at least size bytes, searching
+// the size's own class first and then the larger ones.
+func find_mem(size uint32) (uintptr, uint32) {
+	for c := size_class(size); c < numClasses; c++ {
+		for block := freelists[c]; block != 0; block = next_free(block) {
+			if sz, _ := read_tag(block); sz >= size {
+				return block, sz
+			}
+		}
 	}
+	return 0, 0
+}
 
-	size += 8
+// block_size turns a request into the size of the block that holds it.
+// Requests over MaxAlloc would wrap around the 32-bit tag.
+func block_size(size uint) (uint32, error) {
+	if size > MaxAlloc {
+		return 0, ErrTooLarge
+	}
+	if size < minBlock-2*tagSize {
+		size = minBlock - 2*tagSize
+	}
+	size = (size + 7) &^ 7
+	return uint32(size + 2*tagSize), nil
+}
 
-	tag := find_mem(uint32(size))
+// Malloc returns the address of size bytes of heap memory.
+func Malloc(size uint) uintptr {
+	if arenas == nil {
+		initheap(int(N))
+	}
+	sz, err := block_size(size)
+	if err != nil {
+		panic(err)
+	}
 
-	if tag.ptr != 0 {
-		delete(freememory, tag)
+	if block, free := find_mem(sz); block != 0 {
+		remove_free(block, free)
 		// Splitting
-		if tag.size >= uint32(size)+16 {
-			new_sz := tag.size - uint32(size)
-			rem_ptr := tag.ptr + uintptr(size)
-
-			write_tag(uint32(size), tag.ptr-4, false)
-			write_tag(uint32(size), tag.ptr-4+uintptr(size)-4, false)
-
-			write_tag(new_sz, rem_ptr-4, true)
-			write_tag(new_sz, rem_ptr-4+uintptr(new_sz)-4, true)
-			freememory[ttags{ptr: rem_ptr, size: new_sz}] = true
-			return tag.ptr
+		if free-sz >= minBlock {
+			rest := block + uintptr(sz)
+			write_tag(free-sz, rest, true)
+			write_tag(free-sz, rest+uintptr(free-sz)-tagSize, true)
+			push_free(rest, free-sz)
+		} else {
+			sz = free
 		}
-		write_tag(tag.size, tag.ptr-4, false)
-		write_tag(tag.size, tag.ptr-4+uintptr(tag.size)-4, false)
-		return tag.ptr
+		write_tag(sz, block, false)
+		write_tag(sz, block+uintptr(sz)-tagSize, false)
+		return block + tagSize
 	}
 
-	if currheapidx+uintptr(size) > heapendindex {
-		panic("out of memory")
+	a := arenas[len(arenas)-1]
+	if a.top+uintptr(sz) > a.end {
+		a = grow(max(int(N), int(sz)))
 	}
-
-	ansptr := currheapidx + 4
-	write_tag(uint32(size), currheapidx, false)
-	currheapidx += uintptr(size)
-	write_tag(uint32(size), currheapidx-4, false)
-
-	return ansptr
+	block := a.top
+	a.top += uintptr(sz)
+	write_tag(sz, block, false)
+	write_tag(sz, block+uintptr(sz)-tagSize, false)
+	return block + tagSize
 }
 
 func Free(ptr uintptr) {
-	szptr := ptr - 4
-	sz := *(*uint32)(unsafe.Pointer(szptr))
-	sz = sz & ^uint32(1)
+	block := ptr - tagSize
+	sz, _ := read_tag(block)
+	a := arena_of(block)
 
 	// left
-	footerleftptr := szptr - 4
-	if footerleftptr >= heapstrtindex && (*(*uint32)(unsafe.Pointer(footerleftptr))&1) == 1 {
-		leftsz := *(*uint32)(unsafe.Pointer(footerleftptr)) & ^uint32(1)
-		leftptr := szptr - uintptr(leftsz) + 4
-		delete(freememory, ttags{ptr: leftptr, size: leftsz})
-		sz += leftsz
-		szptr -= uintptr(leftsz)
+	if block > a.start {
+		if leftsz, free := read_tag(block - tagSize); free {
+			left := block - uintptr(leftsz)
+			remove_free(left, leftsz)
+			block = left
+			sz += leftsz
+		}
 	}
 
 	// right
-	hi := szptr + uintptr(sz)
-	headerrightptr := hi
-	if headerrightptr < currheapidx && (*(*uint32)(unsafe.Pointer(headerrightptr))&1) == 1 {
-		rightsz := *(*uint32)(unsafe.Pointer(headerrightptr)) & ^uint32(1)
-		rightptr := headerrightptr + 4
-		delete(freememory, ttags{ptr: rightptr, size: rightsz})
-		sz += rightsz
-	}
-
-	write_tag(sz, szptr, true)
-	write_tag(sz, szptr+uintptr(sz)-4, true)
-	freememory[ttags{ptr: szptr + 4, size: sz}] = true
+	if right := block + uintptr(sz); right < a.top {
+		if rightsz, free := read_tag(right); free {
+			remove_free(right, rightsz)
+			sz += rightsz
+		}
+	}
+
+	write_tag(sz, block, true)
+	write_tag(sz, block+uintptr(sz)-tagSize, true)
+	push_free(block, sz)
+}
+
+// Load64 reads the 8-byte word at addr, which must lie inside a block
+// returned by Malloc.
+func Load64(addr uintptr) uint64 {
+	a := arena_of(addr)
+	off := addr - a.start
+	return binary.LittleEndian.Uint64(a.mem[off : off+8])
+}
+
+// Store64 writes the 8-byte word at addr, which must lie inside a block
+// returned by Malloc.
+func Store64(addr uintptr, val uint64) {
+	a := arena_of(addr)
+	off := addr - a.start
+	binary.LittleEndian.PutUint64(a.mem[off:off+8], val)
+}
+
+// HeapStats describes how the heap is used. Sizes are in bytes and count
+// block tags.
+type HeapStats struct {
+	Arenas      int
+	HeapSize    uint64 // bytes mapped in all arenas
+	Used        uint64 // bytes in allocated blocks
+	Free        uint64 // bytes in free blocks and untouched arena space
+	FreeBlocks  int
+	LargestFree uint64 // largest free block or untouched arena tail
+	// Fragmentation is 1 - LargestFree/Free: 0 when all free memory is in
+	// one piece, close to 1 when it is scattered across small blocks.
+	Fragmentation float64
+}
+
+// Stats walks the free lists and arenas and reports the heap's usage.
+func Stats() HeapStats {
+	var s HeapStats
+	s.Arenas = len(arenas)
+	for _, a := range arenas {
+		s.HeapSize += uint64(a.end - a.start)
+		tail := uint64(a.end - a.top)
+		s.Free += tail
+		s.LargestFree = max(s.LargestFree, tail)
+	}
+	for c := range freelists {
+		for block := freelists[c]; block != 0; block = next_free(block) {
+			sz, _ := read_tag(block)
+			s.Free += uint64(sz)
+			s.FreeBlocks++
+			s.LargestFree = max(s.LargestFree, uint64(sz))
+		}
+	}
+	s.Used = s.HeapSize - s.Free
+	if s.Free > 0 {
+		s.Fragmentation = 1 - float64(s.LargestFree)/float64(s.Free)
+	}
+	return s
 }
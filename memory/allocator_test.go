@@ -6,10 +6,6 @@ import (
 
 func TestAllocator(t *testing.T) {
 	initheap(int(N))
-	
-	// Reset global state
-	freememory = make(map[ttags]bool)
-	currheapidx = heapstrtindex
 
 	// 1. Basic Malloc/Free
 	p1 := Malloc(100)
@@ -83,3 +79,156 @@ func TestAllocator(t *testing.T) {
 		t.Errorf("Expected p14 to take remainder of p12 at %x, got %x", p12+208, p14)
 	}
 }
+
+func TestLoadStore(t *testing.T) {
+	p := Malloc(16)
+	Store64(p, 42)
+	Store64(p+8, ^uint64(0))
+	if got := Load64(p); got != 42 {
+		t.Errorf("Load64 wrong. want=42, got=%d", got)
+	}
+	if got := Load64(p + 8); got != ^uint64(0) {
+		t.Errorf("Load64 wrong. want=%d, got=%d", ^uint64(0), got)
+	}
+	Free(p)
+}
+
+func TestSizeClasses(t *testing.T) {
+	initheap(int(N))
+
+	small := Malloc(24)
+	Malloc(8) // keeps the two free blocks apart
+	large := Malloc(4000)
+	Malloc(8)
+
+	Free(large)
+	Free(small)
+
+	// a small request comes from the small block's class, not the front
+	// of the first list that happens to have room
+	if p := Malloc(20); p != small {
+		t.Errorf("Expected the small block %x, got %x", small, p)
+	}
+	if p := Malloc(3000); p != large {
+		t.Errorf("Expected the large block %x, got %x", large, p)
+	}
+}
+
+func TestDeterministicPlacement(t *testing.T) {
+	run := func() []uintptr {
+		initheap(int(N))
+		base := arenas[0].start
+		var ptrs, offsets []uintptr
+		for i := 1; i <= 50; i++ {
+			ptrs = append(ptrs, Malloc(uint(i*24)))
+		}
+		for i := 0; i < len(ptrs); i += 3 {
+			Free(ptrs[i])
+		}
+		for i := 1; i <= 20; i++ {
+			offsets = append(offsets, Malloc(uint(i*40))-base)
+		}
+		return offsets
+	}
+
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("placement %d differs between runs: %x vs %x", i, first[i], second[i])
+		}
+	}
+}
+
+func TestGrowth(t *testing.T) {
+	saved := N
+	N = 4096
+	defer func() { N = saved }()
+	initheap(int(N))
+
+	var ptrs []uintptr
+	for i := 0; i < 100; i++ {
+		p := Malloc(200)
+		Store64(p, uint64(i))
+		ptrs = append(ptrs, p)
+	}
+	big := Malloc(10000) // larger than an arena
+	Store64(big+9992, 7)
+
+	if s := Stats(); s.Arenas < 2 {
+		t.Fatalf("expected the heap to grow, got %d arenas", s.Arenas)
+	}
+	for i, p := range ptrs {
+		if got := Load64(p); got != uint64(i) {
+			t.Fatalf("block %d lost its data after growth: got %d", i, got)
+		}
+	}
+	if Load64(big+9992) != 7 {
+		t.Errorf("large block lost its data")
+	}
+
+	for _, p := range ptrs {
+		Free(p)
+	}
+	Free(big)
+	if s := Stats(); s.Used != 0 {
+		t.Errorf("expected an empty heap after freeing everything, used=%d", s.Used)
+	}
+}
+
+func TestStats(t *testing.T) {
+	initheap(int(N))
+
+	s := Stats()
+	if s.HeapSize != uint64(N) || s.Used != 0 || s.Free != uint64(N) || s.Fragmentation != 0 {
+		t.Fatalf("fresh heap stats wrong: %+v", s)
+	}
+
+	var ptrs []uintptr
+	for i := 0; i < 10; i++ {
+		ptrs = append(ptrs, Malloc(100)) // 112-byte blocks
+	}
+	if s := Stats(); s.Used != 1120 {
+		t.Errorf("expected 1120 bytes used, got %d", s.Used)
+	}
+
+	// every other block: five separate holes
+	for i := 0; i < len(ptrs); i += 2 {
+		Free(ptrs[i])
+	}
+	s = Stats()
+	if s.Used != 560 || s.FreeBlocks != 5 {
+		t.Errorf("expected 560 bytes used in 5 holes, got %+v", s)
+	}
+	if s.Fragmentation <= 0 {
+		t.Errorf("expected some fragmentation, got %f", s.Fragmentation)
+	}
+
+	// freeing the rest coalesces the holes into one block
+	for i := 1; i < len(ptrs); i += 2 {
+		Free(ptrs[i])
+	}
+	s = Stats()
+	if s.Used != 0 || s.FreeBlocks != 1 {
+		t.Errorf("expected one coalesced free block, got %+v", s)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	initheap(int(N))
+
+	if sz, err := block_size(MaxAlloc); err != nil || sz != 0xFFFFFFF8 {
+		t.Errorf("block_size(MaxAlloc) = %d, %v; want %d", sz, err, uint32(0xFFFFFFF8))
+	}
+	for _, size := range []uint{MaxAlloc + 1, 1 << 32, 1<<32 + 16} {
+		if sz, err := block_size(size); err != ErrTooLarge {
+			t.Errorf("block_size(%d) = %d, %v; want ErrTooLarge", size, sz, err)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != ErrTooLarge {
+			t.Errorf("Malloc(1<<32) panicked with %v, want ErrTooLarge", r)
+		}
+	}()
+	Malloc(1 << 32)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package memory
+
+// mapHeap falls back to a Go-allocated slice where mmap is unavailable.
+func mapHeap(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package memory
+
+import "syscall"
+
+// mapHeap reserves the heap as anonymous private memory outside the Go heap.
+func mapHeap(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+}
@@ -6,6 +6,7 @@ import (
 	"Nikium/token"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -60,6 +61,16 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// enums declared so far and the matches to check against them once the
+	// whole program is parsed
+	enums    map[string][]string
+	matches  []*ast.MatchExpression
+	warnings []string
+
+	// function literals being parsed, and whether the innermost yields
+	fnDepth int
+	yields  bool
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -71,7 +82,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE, p.parseTemplateLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
@@ -85,6 +98,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.STRUCT, p.parseStructLiteral)
+	p.registerPrefix(token.INTERFACE, p.parseInterfaceLiteral)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.NEW, p.parseNewExpression)
 	p.registerPrefix(token.INC, p.parsePrefixExpression)
 
@@ -117,6 +132,10 @@ func New(l *lexer.Lexer) *Parser {
 
 func (p *Parser) Errors() []string { return p.errors }
 
+// Warnings lists problems that do not stop the program from running, such
+// as a match over an enum that leaves out some of its variants.
+func (p *Parser) Warnings() []string { return p.warnings }
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
@@ -131,6 +150,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 		}
 		p.nextToken()
 	}
+	p.checkExhaustive()
 	return program
 }
 func (p *Parser) parseArrayLiteral() ast.Expression {
@@ -163,11 +183,26 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
+	case token.ENUM:
+		return p.parseEnumStatement()
 	case token.GENERIC:
 		// generic<T> name = struct{...}; or generic<T> name = fn(...){};
 		return p.parseGenericLetStatement()
+	case token.LET:
+		// let name = value; or let name: type = value;
+		letTok := p.curToken
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt := p.parseLetStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Token = letTok
+		stmt.Declare = true
+		return stmt
 	case token.IDENT:
-		if p.peekToken.Type == token.LT {
+		if p.peekToken.Type == token.LT && !p.genericCallAhead() {
 			// p<int>* name or p<int> name
 			return p.parseVarDeclaration(false)
 		}
@@ -185,12 +220,24 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parsePrintStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.YIELD:
+		return p.parseYieldStatement()
 	case token.BREAK:
 		return p.parseBreakStatement()
 	case token.CONTINUE:
 		return p.parseContinueStatement()
+	case token.TRY:
+		return p.parseTryStatement()
+	case token.SELECT:
+		return p.parseSelectStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	case token.LOAD:
 		return p.parseLoadStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.DELETE:
+		return p.parseDeleteStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -213,6 +260,42 @@ func (p *Parser) parseLoadStatement() *ast.LoadStatement {
 	return stmt
 }
 
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = p.parseStringLiteral().(*ast.StringLiteral)
+
+	if p.peekTokenIs(token.AS) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseDeleteStatement() *ast.DeleteStatement {
+	stmt := &ast.DeleteStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
@@ -221,6 +304,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		p.nextToken()
 		p.nextToken()
 		stmt.Type = p.curToken.Literal
+		stmt.Declare = true
 	}
 
 	if !p.expectPeek(token.ASSIGN) {
@@ -229,25 +313,27 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
+	// interfaces take the name they are declared with, for messages
+	if iface, ok := stmt.Value.(*ast.InterfaceLiteral); ok {
+		iface.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
 }
 
-// parseGenericLetStatement handles: generic<T> name = struct{...}; or generic<T> name = fn(...){};
+// parseGenericLetStatement handles: generic<T> name = struct{...}; or
+// generic<K, V: Comparable> name = fn(...){};
 func (p *Parser) parseGenericLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := &ast.LetStatement{Token: p.curToken, Declare: true}
 
-	// consume < T >
 	if !p.expectPeek(token.LT) {
 		return nil
 	}
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
-	stmt.GenericType = p.curToken.Literal // e.g. "T"
-	if !p.expectPeek(token.GT) {
+	stmt.TypeParams = p.parseTypeParams()
+	if stmt.TypeParams == nil {
 		return nil
 	}
 
@@ -263,11 +349,11 @@ func (p *Parser) parseGenericLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
-	// propagate generic type to the parsed value node
+	// propagate the type parameters to the parsed value node
 	if sl, ok := stmt.Value.(*ast.StructLiteral); ok {
-		sl.GenericType = stmt.GenericType
+		sl.TypeParams = stmt.TypeParams
 	} else if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
-		fl.GenericType = stmt.GenericType
+		fl.TypeParams = stmt.TypeParams
 	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -276,18 +362,116 @@ func (p *Parser) parseGenericLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// parseTypeParams parses the parameters of generic<...>, each a name with
+// an optional constraint, and leaves the closing > as the current token.
+func (p *Parser) parseTypeParams() []*ast.TypeParam {
+	params := []*ast.TypeParam{}
+	seen := make(map[string]bool)
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		param := &ast.TypeParam{Name: p.curToken.Literal}
+		if seen[param.Name] {
+			p.errors = append(p.errors, fmt.Sprintf("type parameter %s declared twice", param.Name))
+			return nil
+		}
+		seen[param.Name] = true
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			param.Constraint = p.curToken.Literal
+		}
+		params = append(params, param)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.GT) {
+		return nil
+	}
+	return params
+}
+
+// parseTypeArgs parses the type arguments of Pair<int, string>, starting
+// on the < and leaving the closing > as the current token.
+func (p *Parser) parseTypeArgs() []*ast.TypeExpr {
+	args := []*ast.TypeExpr{}
+	for {
+		p.nextToken()
+		arg := p.parseTypeExpr()
+		if arg == nil {
+			return nil
+		}
+		args = append(args, arg)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.closeTypeArgs() {
+		return nil
+	}
+	return args
+}
+
+// parseTypeExpr parses a type: a name, a generic instance such as
+// Box<int>, or an array type, []int.
+func (p *Parser) parseTypeExpr() *ast.TypeExpr {
+	t := &ast.TypeExpr{Token: p.curToken}
+	switch p.curToken.Type {
+	case token.LBRACKET:
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		p.nextToken()
+		elem := p.parseTypeExpr()
+		if elem == nil {
+			return nil
+		}
+		t.Name = "[]"
+		t.Args = []*ast.TypeExpr{elem}
+	case token.IDENT:
+		t.Name = p.curToken.Literal
+		if p.peekTokenIs(token.LT) {
+			p.nextToken()
+			t.Args = p.parseTypeArgs()
+			if t.Args == nil {
+				return nil
+			}
+		}
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("expected type, got %s", p.curToken.Type))
+		return nil
+	}
+	return t
+}
+
+// closeTypeArgs moves onto the > that ends a type argument list. The lexer
+// reads the >> ending two nested lists as one token, so it is split and
+// the second > is left for the outer list.
+func (p *Parser) closeTypeArgs() bool {
+	if p.peekTokenIs(token.RSHIFT) {
+		shift := p.peekToken
+		p.curToken = token.Token{Type: token.GT, Literal: ">", Line: shift.Line, Column: shift.Column}
+		p.peekToken = token.Token{Type: token.GT, Literal: ">", Line: shift.Line, Column: shift.Column + 1}
+		return true
+	}
+	return p.expectPeek(token.GT)
+}
+
 func (p *Parser) parseVarDeclaration(isPointer bool) *ast.VarDeclaration {
 	decl := &ast.VarDeclaration{Token: p.curToken, Type: p.curToken.Literal, IsPointer: isPointer}
 
-	// Check for type args: p<int> or p<int>*
+	// Check for type args: p<int> or Pair<int, string>*
 	if p.peekTokenIs(token.LT) {
 		p.nextToken() // <
-		if p.peekTokenIs(token.IDENT) {
-			p.nextToken() // type arg e.g. "int"
-			decl.GenericType = p.curToken.Literal
-		}
-		if p.peekTokenIs(token.GT) {
-			p.nextToken() // >
+		decl.TypeArgs = p.parseTypeArgs()
+		if decl.TypeArgs == nil {
+			return nil
 		}
 		// allow pointer after >: p<int>*
 		if p.peekTokenIs(token.ASTERISK) {
@@ -303,8 +487,10 @@ func (p *Parser) parseVarDeclaration(isPointer bool) *ast.VarDeclaration {
 	decl.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
 	if p.peekTokenIs(token.LPAREN) {
+		// constructor arguments: Type name(a, b);
 		p.nextToken()
-		if !p.expectPeek(token.RPAREN) {
+		decl.Arguments = p.parseCallArguments()
+		if decl.Arguments == nil {
 			return nil
 		}
 	} else if p.peekTokenIs(token.ASSIGN) {
@@ -331,6 +517,13 @@ func (p *Parser) parsePrintStatement() *ast.PrintStatement {
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) {
+		// a bare return gives null
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	}
 	p.nextToken()
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -339,6 +532,24 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseYieldStatement() ast.Statement {
+	stmt := &ast.YieldStatement{Token: p.curToken}
+	if p.fnDepth == 0 {
+		p.errors = append(p.errors, "yield outside a function")
+		return nil
+	}
+	p.yields = true
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseBreakStatement() *ast.BreakStatement {
 	stmt := &ast.BreakStatement{Token: p.curToken}
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -347,6 +558,148 @@ func (p *Parser) parseBreakStatement() *ast.BreakStatement {
 	return stmt
 }
 
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			stmt.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Finally = p.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errors = append(p.errors, "try needs a catch or finally block")
+		return nil
+	}
+	return stmt
+}
+
+func (p *Parser) parseSelectStatement() ast.Statement {
+	stmt := &ast.SelectStatement{Token: p.curToken}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACE) {
+		switch p.curToken.Type {
+		case token.CASE:
+			c := p.parseSelectCase()
+			if c == nil {
+				return nil
+			}
+			stmt.Cases = append(stmt.Cases, c)
+		case token.DEFAULT:
+			if stmt.Default != nil {
+				p.errors = append(p.errors, "select has more than one default")
+				return nil
+			}
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			stmt.Default = p.parseCaseBody()
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("expected case or default in select, got %s", p.curToken.Type))
+			return nil
+		}
+	}
+	if len(stmt.Cases) == 0 && stmt.Default == nil {
+		p.errors = append(p.errors, "select needs at least one case")
+		return nil
+	}
+	return stmt
+}
+
+// parseSelectCase parses case v = recv(ch): or case send(ch, x): and the
+// statements after it.
+func (p *Parser) parseSelectCase() *ast.SelectCase {
+	c := &ast.SelectCase{Token: p.curToken}
+	p.nextToken()
+	if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN) {
+		c.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		p.nextToken()
+		p.nextToken()
+	}
+	call, _ := p.parseExpression(LOWEST).(*ast.CallExpression)
+	var op string
+	if call != nil {
+		if ident, ok := call.Function.(*ast.Identifier); ok {
+			op = ident.Value
+		}
+	}
+	switch {
+	case op == "recv" && len(call.Arguments) == 1:
+		c.Channel = call.Arguments[0]
+	case op == "send" && len(call.Arguments) == 2 && c.Name == nil:
+		c.Send = true
+		c.Channel, c.Value = call.Arguments[0], call.Arguments[1]
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("select case must be recv(ch) or send(ch, value), on line %d", c.Token.Line))
+		return nil
+	}
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	c.Body = p.parseCaseBody()
+	return c
+}
+
+// parseCaseBody parses the statements after the colon of a select case, up
+// to the next case, default or the closing brace.
+func (p *Parser) parseCaseBody() *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.curToken}
+	p.nextToken()
+	for !p.curTokenIs(token.CASE) && !p.curTokenIs(token.DEFAULT) && !p.curTokenIs(token.RBRACE) {
+		if p.curTokenIs(token.EOF) {
+			p.errors = append(p.errors, "expected } to close select")
+			return block
+		}
+		stmt := p.parseStatement()
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		p.nextToken()
+	}
+	return block
+}
+
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
 	stmt := &ast.ContinueStatement{Token: p.curToken}
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -398,10 +751,53 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return &ast.IntegerLiteral{Token: p.curToken, Value: val}
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	val, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errors = append(p.errors, "invalid float")
+		return nil
+	}
+	return &ast.FloatLiteral{Token: p.curToken, Value: val}
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseTemplateLiteral parses each ${...} of a template string as an
+// expression of its own, positioned where it sits in the source.
+func (p *Parser) parseTemplateLiteral() ast.Expression {
+	tok := p.curToken
+	lit := &ast.TemplateLiteral{Token: tok}
+	parts, ok := lexer.SplitTemplate(tok)
+	if !ok {
+		p.errors = append(p.errors, fmt.Sprintf("unterminated ${ in template string on line %d", tok.Line))
+		return nil
+	}
+	for _, part := range parts {
+		if !part.IsExpr {
+			text := token.Token{Type: token.STRING, Literal: part.Text, Line: tok.Line, Column: tok.Column}
+			lit.Parts = append(lit.Parts, &ast.StringLiteral{Token: text, Value: part.Text})
+			continue
+		}
+		sub := New(lexer.NewAt(part.Expr, part.Line, part.Column))
+		if sub.curTokenIs(token.EOF) {
+			p.errors = append(p.errors, fmt.Sprintf("empty ${} in template string on line %d", part.Line))
+			return nil
+		}
+		expr := sub.parseExpression(LOWEST)
+		if len(sub.errors) == 0 && !sub.peekTokenIs(token.EOF) {
+			sub.errors = append(sub.errors, fmt.Sprintf("expected } in template string, got %s", sub.peekToken.Type))
+		}
+		if len(sub.errors) > 0 {
+			p.errors = append(p.errors, sub.errors...)
+			return nil
+		}
+		lit.Parts = append(lit.Parts, expr)
+	}
+	return lit
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{
 		Token: p.curToken,
@@ -420,19 +816,11 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	// Detect generic function call: ident<type>(args)
-	// cur token is <, left is Identifier, peek is IDENT (type arg)
+	// Detect generic function call: ident<types>(args)
 	if p.curToken.Literal == "<" {
 		if _, isIdent := left.(*ast.Identifier); isIdent {
-			// peek ahead: if next is IDENT and then > then ( → generic call
-			if p.peekTokenIs(token.IDENT) && p.peekPeekTokenIs(token.GT) {
-				p.nextToken() // move to type arg
-				typeArg := p.curToken.Literal
-				p.nextToken() // move to >
-				if p.peekTokenIs(token.LPAREN) {
-					p.nextToken() // move to (
-					return p.parseGenericCallExpression(left, typeArg)
-				}
+			if call := p.tryGenericCall(left); call != nil {
+				return call
 			}
 		}
 	}
@@ -479,6 +867,321 @@ func (p *Parser) parseIfStatement() ast.Expression {
 	return expr
 }
 
+// parseMatchExpression handles match subject { pattern if guard => body, ... }.
+// A body is an expression, or a block whose last expression is the value;
+// a comma must follow expression bodies and may follow blocks.
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expr := &ast.MatchExpression{Token: p.curToken}
+	p.nextToken()
+	expr.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := &ast.MatchArm{Pattern: p.parsePattern()}
+		if arm.Pattern == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			p.nextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(token.FAT_ARROW) {
+			return nil
+		}
+		p.nextToken()
+
+		if p.curTokenIs(token.LBRACE) {
+			arm.Body = p.parseBlockStatement()
+			if p.peekTokenIs(token.COMMA) {
+				p.nextToken()
+			}
+		} else {
+			tok := p.curToken
+			body := &ast.ExpressionStatement{Token: tok, Expression: p.parseExpression(LOWEST)}
+			arm.Body = &ast.BlockStatement{Token: tok, Statements: []ast.Statement{body}}
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+		}
+		expr.Arms = append(expr.Arms, arm)
+	}
+	p.nextToken()
+	p.matches = append(p.matches, expr)
+	return expr
+}
+
+// parseEnumStatement handles enum Name { Unit, WithFields(a, b), ... }.
+func (p *Parser) parseEnumStatement() ast.Statement {
+	stmt := &ast.EnumStatement{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		variant := &ast.EnumVariant{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+		if seen[variant.Name.Value] {
+			p.errors = append(p.errors, fmt.Sprintf("enum %s declares %s twice", stmt.Name.Value, variant.Name.Value))
+			return nil
+		}
+		seen[variant.Name.Value] = true
+		names = append(names, variant.Name.Value)
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			variant.Fields = p.parseParameterNames()
+			if variant.Fields == nil {
+				return nil
+			}
+		}
+		stmt.Variants = append(stmt.Variants, variant)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if p.enums == nil {
+		p.enums = make(map[string][]string)
+	}
+	p.enums[stmt.Name.Value] = names
+	return stmt
+}
+
+// checkExhaustive warns about matches whose arms are all variants of one
+// enum declared in the program but that leave some of its variants out. An
+// arm with a guard, or with values that are not just names, may not match,
+// so it does not cover its variant.
+func (p *Parser) checkExhaustive() {
+	for _, m := range p.matches {
+		enum := ""
+		covered := make(map[string]bool)
+		exhaustive := false
+		for _, arm := range m.Arms {
+			if _, ok := arm.Pattern.(*ast.Identifier); ok && arm.Guard == nil {
+				exhaustive = true
+				break
+			}
+			vp, ok := arm.Pattern.(*ast.VariantPattern)
+			if !ok || (enum != "" && vp.Enum != enum) {
+				enum = ""
+				break
+			}
+			enum = vp.Enum
+			if arm.Guard == nil && irrefutable(vp.Args) {
+				covered[vp.Variant] = true
+			}
+		}
+		variants, known := p.enums[enum]
+		if exhaustive || !known {
+			continue
+		}
+		var missing []string
+		for _, name := range variants {
+			if !covered[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			p.warnings = append(p.warnings, fmt.Sprintf("match over %s is not exhaustive: missing %s on line %d, col %d",
+				enum, strings.Join(missing, ", "), m.Token.Line, m.Token.Column))
+		}
+	}
+}
+
+func irrefutable(patterns []ast.Expression) bool {
+	for _, pattern := range patterns {
+		if _, ok := pattern.(*ast.Identifier); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePattern parses the pattern of a match arm: a literal, a name that
+// binds the value (_ binds nothing), or an array, hash or struct pattern.
+func (p *Parser) parsePattern() ast.Expression {
+	switch p.curToken.Type {
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+		return p.prefixParseFns[p.curToken.Type]()
+
+	case token.MINUS:
+		minus := p.curToken
+		p.nextToken()
+		switch lit := p.parsePattern().(type) {
+		case *ast.IntegerLiteral:
+			lit.Token = token.Token{Type: lit.Token.Type, Literal: "-" + lit.Token.Literal, Line: minus.Line, Column: minus.Column}
+			lit.Value = -lit.Value
+			return lit
+		case *ast.FloatLiteral:
+			lit.Token = token.Token{Type: lit.Token.Type, Literal: "-" + lit.Token.Literal, Line: minus.Line, Column: minus.Column}
+			lit.Value = -lit.Value
+			return lit
+		}
+		p.errors = append(p.errors, "expected number after - in pattern")
+		return nil
+
+	case token.IDENT:
+		if p.peekTokenIs(token.DOT) {
+			return p.parseVariantPattern()
+		}
+		if p.peekTokenIs(token.LBRACE) {
+			name := p.curToken
+			p.nextToken()
+			return p.parseStructPattern(name, name.Literal)
+		}
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+
+	case token.LBRACE:
+		// identifier keys name struct fields, literal keys hash keys
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseStructPattern(p.curToken, "")
+		}
+		return p.parseHashPattern()
+	}
+	p.errors = append(p.errors, fmt.Sprintf("unexpected %s in pattern", p.curToken.Type))
+	return nil
+}
+
+// parseVariantPattern parses Enum.Variant, optionally followed by patterns
+// for the values it carries in parentheses.
+func (p *Parser) parseVariantPattern() ast.Expression {
+	pattern := &ast.VariantPattern{Token: p.curToken, Enum: p.curToken.Literal}
+	p.nextToken()
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	pattern.Variant = p.curToken.Literal
+	if !p.peekTokenIs(token.LPAREN) {
+		return pattern
+	}
+	p.nextToken()
+	pattern.Args = []ast.Expression{}
+	for !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		arg := p.parsePattern()
+		if arg == nil {
+			return nil
+		}
+		pattern.Args = append(pattern.Args, arg)
+		if !p.peekTokenIs(token.RPAREN) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+	return pattern
+}
+
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.peekTokenIs(token.RBRACKET) {
+				p.errors = append(p.errors, "...rest must be the last element of an array pattern")
+				return nil
+			}
+			break
+		}
+		el := p.parsePattern()
+		if el == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, el)
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return pattern
+}
+
+func (p *Parser) parseHashPattern() ast.Expression {
+	pattern := &ast.HashPattern{Token: p.curToken}
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parsePattern()
+		switch key.(type) {
+		case *ast.StringLiteral, *ast.IntegerLiteral, *ast.Boolean:
+		default:
+			p.errors = append(p.errors, "hash pattern keys must be string, integer or bool literals")
+			return nil
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parsePattern()
+		if value == nil {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return pattern
+}
+
+// parseStructPattern parses { field: pattern, other } after an optional
+// type name; a field on its own binds the field to a name of its own.
+func (p *Parser) parseStructPattern(tok token.Token, typeName string) ast.Expression {
+	pattern := &ast.StructPattern{Token: tok, Type: typeName}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		field := p.curToken
+		var value ast.Expression = &ast.Identifier{Token: field, Value: field.Literal}
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			if value = p.parsePattern(); value == nil {
+				return nil
+			}
+		}
+		pattern.Fields = append(pattern.Fields, field.Literal)
+		pattern.Values = append(pattern.Values, value)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return pattern
+}
+
 func (p *Parser) parseWhileStatement() ast.Expression {
 	expr := &ast.WhileStatement{Token: p.curToken}
 	p.nextToken()
@@ -513,49 +1216,98 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	lit.Parameters = p.parseFunctionParameters()
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
+	outerYields := p.yields
+	p.fnDepth++
+	p.yields = false
 	lit.Body = p.parseBlockStatement()
+	lit.Generator = p.yields
+	p.fnDepth--
+	p.yields = outerYields
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	params := []*ast.Identifier{}
+// parseFunctionParameters parses the parameter list of lit: names with an
+// optional type annotation and default value, then an optional ...rest.
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
+	lit.ParamTypes = []*ast.TypeExpr{}
+	lit.Defaults = []ast.Expression{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return params
+		return true
 	}
 
-	p.nextToken()
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	params = append(params, ident)
-
-	// Allow optional :type
-	if p.peekTokenIs(token.COLON) {
-		p.nextToken() // :
-		p.nextToken() // type name
-	}
+	for {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken() // ...
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken() // ,
 		p.nextToken() // param name
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		params = append(params, ident)
+		lit.Parameters = append(lit.Parameters, ident)
 
+		// Allow optional :type
+		var typ *ast.TypeExpr
 		if p.peekTokenIs(token.COLON) {
 			p.nextToken() // :
-			p.nextToken() // type name
+			p.nextToken() // type
+			if typ = p.parseTypeExpr(); typ == nil {
+				return false
+			}
 		}
+		lit.ParamTypes = append(lit.ParamTypes, typ)
+
+		var def ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken() // =
+			p.nextToken()
+			def = p.parseExpression(LOWEST)
+		} else if len(lit.Defaults) > 0 && lit.Defaults[len(lit.Defaults)-1] != nil {
+			p.errors = append(p.errors, fmt.Sprintf("parameter %s without a default follows one with a default", ident.Value))
+			return false
+		}
+		lit.Defaults = append(lit.Defaults, def)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken() // ,
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	return p.expectPeek(token.RPAREN)
+}
+
+// parseParameterNames parses a parameter list that only takes names, as
+// enum variant fields and interface methods do.
+func (p *Parser) parseParameterNames() []*ast.Identifier {
+	lit := &ast.FunctionLiteral{Token: p.curToken}
+	if !p.parseFunctionParameters(lit) {
 		return nil
 	}
-	return params
+	for _, def := range lit.Defaults {
+		if def != nil {
+			p.errors = append(p.errors, "default values are only allowed on function parameters")
+			return nil
+		}
+	}
+	if lit.Rest != nil {
+		p.errors = append(p.errors, "rest parameters are only allowed on functions")
+		return nil
+	}
+	return lit.Parameters
 }
 
 func (p *Parser) parseCallExpression(fn ast.Expression) ast.Expression {
@@ -564,10 +1316,28 @@ func (p *Parser) parseCallExpression(fn ast.Expression) ast.Expression {
 	return exp
 }
 
-// parseGenericCallExpression handles: func<int>(args...)
-// Called from parseInfixExpression when we detect identifier < type > (
-func (p *Parser) parseGenericCallExpression(fn ast.Expression, typeArg string) ast.Expression {
-	exp := &ast.CallExpression{Token: p.curToken, Function: fn, TypeArg: typeArg}
+// genericCallAhead reports whether the < after the current name opens the
+// type arguments of a call, id<int>(5), rather than of a declaration.
+func (p *Parser) genericCallAhead() bool {
+	lexer, cur, peek, errs := *p.l, p.curToken, p.peekToken, len(p.errors)
+	p.nextToken()
+	call := p.parseTypeArgs() != nil && p.peekTokenIs(token.LPAREN)
+	*p.l, p.curToken, p.peekToken, p.errors = lexer, cur, peek, p.errors[:errs]
+	return call
+}
+
+// tryGenericCall handles: func<int, string>(args...), starting on the <.
+// The same tokens may be a comparison, so when they do not read as type
+// arguments followed by ( the parser is rewound and nil returned.
+func (p *Parser) tryGenericCall(fn ast.Expression) ast.Expression {
+	lexer, cur, peek, errs := *p.l, p.curToken, p.peekToken, len(p.errors)
+	typeArgs := p.parseTypeArgs()
+	if typeArgs == nil || !p.peekTokenIs(token.LPAREN) {
+		*p.l, p.curToken, p.peekToken, p.errors = lexer, cur, peek, p.errors[:errs]
+		return nil
+	}
+	p.nextToken() // move to (
+	exp := &ast.CallExpression{Token: p.curToken, Function: fn, TypeArgs: typeArgs}
 	exp.Arguments = p.parseCallArguments()
 	return exp
 }
@@ -581,12 +1351,12 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	}
 
 	p.nextToken()
-	args = append(args, p.parseExpression(LOWEST))
+	args = append(args, p.parseCallArgument())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		args = append(args, p.parseCallArgument())
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -595,6 +1365,17 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	return args
 }
 
+// parseCallArgument parses one argument, which may spread an array: ...xs.
+func (p *Parser) parseCallArgument() ast.Expression {
+	if p.curTokenIs(token.ELLIPSIS) {
+		spread := &ast.SpreadExpression{Token: p.curToken}
+		p.nextToken()
+		spread.Value = p.parseExpression(LOWEST)
+		return spread
+	}
+	return p.parseExpression(LOWEST)
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Left: left}
 	p.nextToken()
@@ -686,6 +1467,15 @@ func (p *Parser) parseStructLiteral() ast.Expression {
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
+		// embedding: ...Base promotes the fields and methods of Base
+		if p.curTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			strct.Embeds = append(strct.Embeds, p.parseExpression(LOWEST))
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+			continue
+		}
 		if p.curToken.Type != token.IDENT {
 			return nil
 		}
@@ -709,6 +1499,45 @@ func (p *Parser) parseStructLiteral() ast.Expression {
 	return strct
 }
 
+// parseInterfaceLiteral handles interface { push(value), pop(), ...Sized }.
+func (p *Parser) parseInterfaceLiteral() ast.Expression {
+	iface := &ast.InterfaceLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			iface.Embeds = append(iface.Embeds, p.parseExpression(LOWEST))
+		} else {
+			if p.curToken.Type != token.IDENT {
+				p.errors = append(p.errors, fmt.Sprintf("expected method name in interface, got %s", p.curToken.Type))
+				return nil
+			}
+			method := &ast.InterfaceMethod{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+			if !p.expectPeek(token.LPAREN) {
+				return nil
+			}
+			method.Parameters = p.parseParameterNames()
+			if method.Parameters == nil {
+				return nil
+			}
+			iface.Methods = append(iface.Methods, method)
+		}
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return iface
+}
+
 func (p *Parser) parseNewExpression() ast.Expression {
 	exp := &ast.NewExpression{Token: p.curToken}
 	if !p.expectPeek(token.IDENT) {
@@ -716,15 +1545,12 @@ func (p *Parser) parseNewExpression() ast.Expression {
 	}
 	exp.Class = p.curToken.Literal
 
-	// parse type args: new p<int>()
+	// parse type args: new Pair<int, string>()
 	if p.peekTokenIs(token.LT) {
 		p.nextToken() // <
-		if p.peekTokenIs(token.IDENT) {
-			p.nextToken() // type arg e.g. "int"
-			exp.GenericType = p.curToken.Literal
-		}
-		if p.peekTokenIs(token.GT) {
-			p.nextToken() // >
+		exp.TypeArgs = p.parseTypeArgs()
+		if exp.TypeArgs == nil {
+			return nil
 		}
 	}
 
@@ -760,6 +1586,9 @@ func (p *Parser) parseForStatement() ast.Expression {
 		return nil
 	}
 	p.nextToken()
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		return p.parseForInStatement(expr.Token)
+	}
 	
 	if !p.curTokenIs(token.SEMICOLON) {
 		expr.Init = p.parseStatement()
@@ -795,3 +1624,31 @@ func (p *Parser) parseForStatement() ast.Expression {
 
 	return expr
 }
+
+// parseForInStatement parses the rest of for (v in xs) or
+// for (k, v in xs), from the first loop variable.
+func (p *Parser) parseForInStatement(tok token.Token) ast.Expression {
+	expr := &ast.ForInStatement{Token: tok}
+	expr.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expr.Key = expr.Value
+		expr.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	expr.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expr.Body = p.parseBlockStatement()
+	return expr
+}
@@ -108,6 +108,25 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	input := "2.75;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.Value != 2.75 {
+		t.Errorf("literal.Value not %g. got=%g", 2.75, literal.Value)
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 
@@ -270,6 +289,547 @@ func TestCallExpressionParsing(t *testing.T) {
 	}
 }
 
+func TestGenericDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Pair<int, string> p;", "Pair<int, string> p;"},
+		{"Box<Pair<int, []string>>* b;", "Box<Pair<int, []string>>* b;"},
+		{"x = new Pair<int, Box<bool>>();", "x = new Pair<int, Box<bool>>();"},
+		{"put<string, int>(h, \"a\", 1);", "put<string, int>(h, a, 1)"},
+		{"f(a < b, c > d);", "f((a < b), (c > d))"},
+		{"x = a < b;", "x = (a < b);"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	p := New(lexer.New("generic<K: Hashable, V> Map = struct { key: K, value: V };"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.LetStatement)
+	lit := stmt.Value.(*ast.StructLiteral)
+	if len(lit.TypeParams) != 2 || lit.TypeParams[0].String() != "K: Hashable" || lit.TypeParams[1].String() != "V" {
+		t.Errorf("wrong type params: %v", lit.TypeParams)
+	}
+
+	p = New(lexer.New("generic<T, T> Box = struct { v: T };"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "type parameter T declared twice" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestForInStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`for (x in xs) { print x; }`, "for(x in xs) print x;"},
+		{`for (k, v in h) { k; }`, "for(k, v in h) k"},
+		{`for (i in range(0, n, 2)) { }`, "for(i in range(0, n, 2)) "},
+		{`for (let i = 0; i < 3; ++i) { }`, "for(let i = 0; (i < 3); (++i)) "},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	p := New(lexer.New(`for (k, in h) { }`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "expected next token IDENT, got IN" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestTemplateLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"Hello ${name}, you are ${age + 1}"`, `"Hello ${name}, you are ${(age + 1)}"`},
+		{`"${f("x")}!"`, `"${f(x)}!"`},
+		{`"a \${b}"`, `a ${b}`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`"${}"`, "empty ${} in template string on line 1"},
+		{`"${a b}"`, "expected } in template string, got IDENT"},
+		{`"${a`, "unterminated ${ in template string on line 1"},
+	}
+
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestSelectStatement(t *testing.T) {
+	input := `select {
+	case v = recv(a):
+		print v;
+		v;
+	case recv(b):
+	case send(c, x + 1):
+		x;
+	default:
+		0;
+	}`
+	expected := "select { case v = recv(a): print v;v case recv(b):  case send(c, (x + 1)): x default: 0 }"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if got := program.String(); got != expected {
+		t.Errorf("wrong String(). want=%q, got=%q", expected, got)
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`select { }`, "select needs at least one case"},
+		{`select { case f(a): 1; }`, "select case must be recv(ch) or send(ch, value), on line 1"},
+		{`select { case v = send(a, 1): 1; }`, "select case must be recv(ch) or send(ch, value), on line 1"},
+		{`select { default: 1; default: 2; }`, "select has more than one default"},
+		{`select { x; }`, "expected case or default in select, got IDENT"},
+	}
+
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestYieldStatement(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		generator bool
+	}{
+		{`g = fn() { yield 1; yield x + 1; };`, "g = fn() yield 1;yield (x + 1);;", true},
+		{`g = fn() { f = fn() { yield 1; }; f };`, "g = fn() f = fn() yield 1;;f;", false},
+		{`g = fn() { return; };`, "g = fn() return ;;", false},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, got)
+		}
+		stmt := program.Statements[0].(*ast.LetStatement)
+		if fn := stmt.Value.(*ast.FunctionLiteral); fn.Generator != tt.generator {
+			t.Errorf("%q: wrong Generator. want=%t, got=%t", tt.input, tt.generator, fn.Generator)
+		}
+	}
+
+	p := New(lexer.New(`yield 1;`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "yield outside a function" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestFunctionParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`f = fn(a, b = 2) { a };`, "f = fn(a, b = 2) a;"},
+		{`f = fn(a, ...rest) { a };`, "f = fn(a, ...rest) a;"},
+		{`f = fn(...rest) { rest };`, "f = fn(...rest) rest;"},
+		{`f(a, ...xs);`, "f(a, ...xs)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`fn(a = 1, b) {}`, "parameter b without a default follows one with a default"},
+		{`fn(...a, b) {}`, "expected next token ), got ,"},
+		{`I = interface { m(a = 1) };`, "default values are only allowed on function parameters"},
+		{`enum E { V(...xs) }`, "rest parameters are only allowed on functions"},
+	}
+
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want first=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		declare  bool
+		expected string
+	}{
+		{"x = 5;", "x", false, "x = 5;"},
+		{"let x = 5;", "x", true, "let x = 5;"},
+		{"let x: int = 5;", "x", true, "let x:int = 5;"},
+		{"x: int = 5;", "x", true, "x:int = 5;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected 1 statement for %q. got=%d", tt.input, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("s not *ast.LetStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Name.Value != tt.name || stmt.Declare != tt.declare {
+			t.Errorf("%q: wrong declaration. got name=%s declare=%t", tt.input, stmt.Name.Value, stmt.Declare)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("%q: String() wrong. got=%q", tt.input, stmt.String())
+		}
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input      string
+		param      string
+		hasCatch   bool
+		hasFinally bool
+	}{
+		{"try { x; } catch (e) { y; }", "e", true, false},
+		{"try { x; } catch { y; }", "", true, false},
+		{"try { x; } finally { z; }", "", false, true},
+		{"try { x; } catch (err) { y; } finally { z; }", "err", true, true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("s not *ast.TryStatement. got=%T", program.Statements[0])
+		}
+		param := ""
+		if stmt.CatchParam != nil {
+			param = stmt.CatchParam.Value
+		}
+		if param != tt.param || (stmt.Catch != nil) != tt.hasCatch || (stmt.Finally != nil) != tt.hasFinally {
+			t.Errorf("%q: wrong try statement. got param=%q catch=%t finally=%t",
+				tt.input, param, stmt.Catch != nil, stmt.Finally != nil)
+		}
+	}
+
+	l := lexer.New("throw x + 1;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("s not *ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Value.String() != "(x + 1)" {
+		t.Errorf("wrong thrown value. got=%q", stmt.Value.String())
+	}
+
+	p = New(lexer.New("try { x; }"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for try without catch or finally")
+	}
+}
+
+func TestImportStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		path     string
+		alias    string
+		expected string
+	}{
+		{`import "stdlib/sql";`, "stdlib/sql", "", `import "stdlib/sql";`},
+		{`import "stdlib/sql" as q`, "stdlib/sql", "q", `import "stdlib/sql" as q;`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ImportStatement)
+		if !ok {
+			t.Fatalf("s not *ast.ImportStatement. got=%T", program.Statements[0])
+		}
+		alias := ""
+		if stmt.Alias != nil {
+			alias = stmt.Alias.Value
+		}
+		if stmt.Path.Value != tt.path || alias != tt.alias {
+			t.Errorf("%q: wrong import. got path=%q alias=%q", tt.input, stmt.Path.Value, alias)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("%q: String() wrong. got=%q", tt.input, stmt.String())
+		}
+	}
+}
+
+func TestDeleteStatement(t *testing.T) {
+	l := lexer.New("delete nodes[0];")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.DeleteStatement)
+	if !ok {
+		t.Fatalf("s not *ast.DeleteStatement. got=%T", program.Statements[0])
+	}
+	if stmt.String() != "delete (nodes[0]);" {
+		t.Errorf("String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestVarDeclarationArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		args     int
+	}{
+		{"Point p(1, x + 2);", "Point p(1, (x + 2));", 2},
+		{"Point p();", "Point p();", 0},
+		{"Box<int> b(5);", "Box<int> b(5);", 1},
+		{"Point p;", "Point p;", -1},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		decl, ok := program.Statements[0].(*ast.VarDeclaration)
+		if !ok {
+			t.Fatalf("s not *ast.VarDeclaration. got=%T", program.Statements[0])
+		}
+		if decl.String() != tt.expected {
+			t.Errorf("String() wrong. want=%q, got=%q", tt.expected, decl.String())
+		}
+		if tt.args < 0 {
+			if decl.Arguments != nil {
+				t.Errorf("expected no argument list, got %d arguments", len(decl.Arguments))
+			}
+		} else if len(decl.Arguments) != tt.args {
+			t.Errorf("wrong number of arguments. want=%d, got=%d", tt.args, len(decl.Arguments))
+		}
+	}
+}
+
+func TestInterfaceLiteral(t *testing.T) {
+	input := `Stack = interface { ...Sized, push(value), pop() };`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	iface, ok := stmt.Value.(*ast.InterfaceLiteral)
+	if !ok {
+		t.Fatalf("value not *ast.InterfaceLiteral. got=%T", stmt.Value)
+	}
+	if iface.Name != "Stack" {
+		t.Errorf("name wrong. want=%q, got=%q", "Stack", iface.Name)
+	}
+	if len(iface.Methods) != 2 || len(iface.Methods[0].Parameters) != 1 || len(iface.Methods[1].Parameters) != 0 {
+		t.Fatalf("wrong methods: %s", iface.String())
+	}
+	if want := "interface{...Sized, push(value), pop()}"; iface.String() != want {
+		t.Errorf("String() wrong. want=%q, got=%q", want, iface.String())
+	}
+}
+
+func TestStructEmbedding(t *testing.T) {
+	input := `Box = struct { ...Base, ...shapes.Shape, size: 0 };`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	lit, ok := program.Statements[0].(*ast.LetStatement).Value.(*ast.StructLiteral)
+	if !ok {
+		t.Fatalf("value not *ast.StructLiteral. got=%T", program.Statements[0].(*ast.LetStatement).Value)
+	}
+	if len(lit.Embeds) != 2 || lit.Embeds[0].String() != "Base" || lit.Embeds[1].String() != "(shapes.Shape)" {
+		t.Errorf("wrong embeds: %v", lit.Embeds)
+	}
+	if len(lit.Pairs) != 1 {
+		t.Errorf("wrong number of fields. want=1, got=%d", len(lit.Pairs))
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	input := `match v {
+  -1 => "neg",
+  [first, ...rest] if first > 0 => first,
+  {"kind": k} => k,
+  Point { x: 0, y } => y,
+  { name } => { name },
+  _ => null
+}`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	match, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("not *ast.MatchExpression. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	patterns := []string{"-1", "[first, ...rest]", "{kind: k}", "Point {x: 0, y: y}", "{name: name}", "_"}
+	if len(match.Arms) != len(patterns) {
+		t.Fatalf("wrong number of arms. want=%d, got=%d", len(patterns), len(match.Arms))
+	}
+	for i, want := range patterns {
+		if got := match.Arms[i].Pattern.String(); got != want {
+			t.Errorf("arm %d: pattern wrong. want=%q, got=%q", i, want, got)
+		}
+	}
+	if match.Arms[1].Guard == nil || match.Arms[1].Guard.String() != "(first > 0)" {
+		t.Errorf("guard wrong: %v", match.Arms[1].Guard)
+	}
+	if _, ok := match.Arms[3].Pattern.(*ast.StructPattern); !ok {
+		t.Errorf("arm 3 not a struct pattern. got=%T", match.Arms[3].Pattern)
+	}
+}
+
+func TestMatchPatternErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match v { [...rest, x] => 1 }`, "...rest must be the last element of an array pattern"},
+		{`match v { {"a": 1, b: 2} => 1 }`, "hash pattern keys must be string, integer or bool literals"},
+		{`match v { -"a" => 1 }`, "expected number after - in pattern"},
+		{`match v { (1) => 1 }`, "unexpected ( in pattern"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want first=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestEnumStatement(t *testing.T) {
+	input := `enum Result { Ok(value), Err(msg) }
+match r { Result.Ok(v) => v, Result.Err => 0 }`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.EnumStatement)
+	if !ok {
+		t.Fatalf("not *ast.EnumStatement. got=%T", program.Statements[0])
+	}
+	if got := stmt.String(); got != "enum Result { Ok(value), Err(msg) }" {
+		t.Errorf("stmt.String() wrong. got=%q", got)
+	}
+	match := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	for i, want := range []string{"Result.Ok(v)", "Result.Err"} {
+		if got := match.Arms[i].Pattern.String(); got != want {
+			t.Errorf("arm %d: pattern wrong. want=%q, got=%q", i, want, got)
+		}
+	}
+	if len(p.Warnings()) != 0 {
+		t.Errorf("unexpected warnings: %q", p.Warnings())
+	}
+
+	p = New(lexer.New(`enum Color { Red, Red }`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "enum Color declares Red twice" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestMatchExhaustiveness(t *testing.T) {
+	decls := "enum Color { Red, Green, Blue }\nenum Result { Ok(value), Err(msg) }\n"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match c { Color.Red => 1, Color.Green => 2, Color.Blue => 3 }`, ""},
+		{`match c { Color.Red => 1, _ => 2 }`, ""},
+		{`match c { Color.Red => 1, Color.Blue => 3 }`, "match over Color is not exhaustive: missing Green on line 3, col 1"},
+		{`match c { Color.Red => 1 }`, "match over Color is not exhaustive: missing Green, Blue on line 3, col 1"},
+		{`match r { Result.Ok(v) if v > 1 => 1, Result.Err(m) => 2 }`, "match over Result is not exhaustive: missing Ok on line 3, col 1"},
+		{`match r { Result.Ok(1) => 1, Result.Ok(v) => 1, Result.Err => 2 }`, ""},
+		{`match r { Result.Ok(1) => 1, Result.Err => 2 }`, "match over Result is not exhaustive: missing Ok on line 3, col 1"},
+		{`match x { Other.A => 1 }`, ""},
+		{`match x { 1 => 1, Color.Red => 2 }`, ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(decls + tt.input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+		warnings := p.Warnings()
+		if tt.expected == "" {
+			if len(warnings) != 0 {
+				t.Errorf("%q: unexpected warnings: %q", tt.input, warnings)
+			}
+			continue
+		}
+		if len(warnings) != 1 || warnings[0] != tt.expected {
+			t.Errorf("%q: wrong warnings. want=%q, got=%q", tt.input, tt.expected, warnings)
+		}
+	}
+}
+
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	stmt, ok := s.(*ast.LetStatement)
 	if !ok {
@@ -170,6 +170,9 @@ func Start(in io.Reader, out io.Writer) {
 			fmt.Fprint(out, ColorReset)
 			continue
 		}
+		for _, warning := range p.Warnings() {
+			fmt.Fprintln(out, ColorYellow+"  ! Warning:", warning+ColorReset)
+		}
 
 		evaluated := evaluator.Eval(program, env)
 		if evaluated != nil {
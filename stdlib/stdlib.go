@@ -0,0 +1,8 @@
+// Package stdlib embeds the Nikium standard library, so `stdlib/...`
+// imports work from an installed binary without the source tree.
+package stdlib
+
+import "embed"
+
+//go:embed *.nik
+var Files embed.FS
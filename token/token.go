@@ -15,9 +15,11 @@ const (
 	EOF     = "EOF"
 
 	// Identifiers + literals
-	IDENT  = "IDENT" // add, foobar, x, y, ...
-	INT    = "INT"   // 1343456
-	STRING = "STRING"
+	IDENT    = "IDENT"    // add, foobar, x, y, ...
+	INT      = "INT"      // 1343456
+	FLOAT    = "FLOAT"    // 3.14
+	STRING   = "STRING"   // "text"
+	TEMPLATE = "TEMPLATE" // "text ${expr}", literal is the raw source
 
 	// Operators
 	ASSIGN   = "="
@@ -43,8 +45,10 @@ const (
 
 	AMPERSAND = "&"
 
-	DOT    = "."
-	ARROW  = "->"
+	DOT       = "."
+	ARROW     = "->"
+	ELLIPSIS  = "..."
+	FAT_ARROW = "=>"
 
 	// Delimiters
 	COLON     = ":"
@@ -72,9 +76,24 @@ const (
 	BREAK    = "BREAK"
 	CONTINUE = "CONTINUE"
 	LOAD     = "LOAD"
+	IMPORT   = "IMPORT"
+	DELETE   = "DELETE"
+	AS       = "AS"
 	STRUCT   = "STRUCT"
 	NEW      = "NEW"
 	GENERIC  = "GENERIC"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	INTERFACE = "INTERFACE"
+	MATCH     = "MATCH"
+	ENUM      = "ENUM"
+	IN        = "IN"
+	YIELD     = "YIELD"
+	SELECT    = "SELECT"
+	CASE      = "CASE"
+	DEFAULT   = "DEFAULT"
 )
 
 // Keywords map
@@ -92,9 +111,24 @@ var keywords = map[string]TokenType{
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"load":     LOAD,
+	"import":   IMPORT,
+	"delete":   DELETE,
+	"as":       AS,
 	"struct":   STRUCT,
 	"new":      NEW,
 	"generic":  GENERIC,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
+	"interface": INTERFACE,
+	"match":     MATCH,
+	"enum":      ENUM,
+	"in":        IN,
+	"yield":     YIELD,
+	"select":    SELECT,
+	"case":      CASE,
+	"default":   DEFAULT,
 }
 
 // Lookup function
@@ -0,0 +1,740 @@
+// Package typecheck checks a program's type annotations, generic
+// instantiations and call arities before it runs. Values the checker
+// cannot see through (parameters, imported members, most builtin results)
+// are unknown and always pass, so a clean check does not promise a clean
+// run, but every problem it reports would fail or misbehave at runtime.
+package typecheck
+
+import (
+	"Nikium/ast"
+	"Nikium/evaluator"
+	"Nikium/token"
+	"fmt"
+	"sort"
+)
+
+// Error is one problem found by Check.
+type Error struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s on line %d, col %d", evaluator.TYPE_ERROR, e.Message, e.Line, e.Column)
+}
+
+type binding struct {
+	typ *Type
+	// annotated bindings keep their declared type; others track what is
+	// assigned to them and become unknown once two assignments disagree
+	annotated bool
+}
+
+type scope struct {
+	outer *scope
+	vars  map[string]*binding
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, vars: make(map[string]*binding)}
+}
+
+func (s *scope) lookup(name string) (*binding, bool) {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.vars[name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// pending is a function body waiting to be checked. Bodies are checked
+// after the code around them, so they see every assignment to the
+// variables they capture, as they would when called later.
+type pending struct {
+	fn    *ast.FunctionLiteral
+	outer *scope
+}
+
+type checker struct {
+	errors []*Error
+	queue  []pending
+}
+
+// Check walks program and returns every problem it finds, ordered by
+// position.
+func Check(program *ast.Program) []*Error {
+	c := &checker{}
+	global := newScope(nil)
+	for _, stmt := range program.Statements {
+		c.statement(stmt, global)
+	}
+	for len(c.queue) > 0 {
+		next := c.queue[0]
+		c.queue = c.queue[1:]
+		c.body(next.fn, next.outer)
+	}
+
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		if c.errors[i].Line != c.errors[j].Line {
+			return c.errors[i].Line < c.errors[j].Line
+		}
+		return c.errors[i].Column < c.errors[j].Column
+	})
+	return c.errors
+}
+
+func (c *checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{Message: fmt.Sprintf(format, a...), Line: tok.Line, Column: tok.Column})
+}
+
+func (c *checker) body(fn *ast.FunctionLiteral, outer *scope) {
+	s := newScope(outer)
+	for i, param := range fn.Parameters {
+		// a default sees the parameters before it
+		if i < len(fn.Defaults) && fn.Defaults[i] != nil {
+			c.expr(fn.Defaults[i], s)
+		}
+		s.vars[param.Value] = &binding{typ: unknown}
+	}
+	if fn.Rest != nil {
+		s.vars[fn.Rest.Value] = &binding{typ: &Type{Kind: Array}}
+	}
+	c.block(fn.Body, s)
+}
+
+func (c *checker) block(block *ast.BlockStatement, s *scope) {
+	if block == nil {
+		return
+	}
+	for _, stmt := range block.Statements {
+		c.statement(stmt, s)
+	}
+}
+
+func (c *checker) statement(stmt ast.Statement, s *scope) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.letStatement(stmt, s)
+
+	case *ast.VarDeclaration:
+		declared := c.resolve(stmt.Token, stmt.Type, stmt.TypeArgs, stmt.IsPointer, s)
+		if stmt.Value != nil {
+			c.checkAssign(stmt.Name, declared, c.expr(stmt.Value, s))
+		} else if declared.Kind == Struct {
+			c.construct(stmt.Name.Token, declared.Struct, stmt.Arguments, s)
+		} else {
+			for _, arg := range stmt.Arguments {
+				c.expr(arg, s)
+			}
+			switch {
+			case stmt.Arguments == nil || declared.Kind == Unknown:
+			case declared.Kind == Pointer:
+				c.errorf(stmt.Name.Token, "cannot construct pointer %s; use new %s(...)", stmt.Name.Value, stmt.Type)
+			default:
+				c.errorf(stmt.Name.Token, "%s has no constructor", stmt.Type)
+			}
+		}
+		s.vars[stmt.Name.Value] = &binding{typ: declared, annotated: true}
+
+	case *ast.ExpressionStatement:
+		c.expr(stmt.Expression, s)
+
+	case *ast.PrintStatement:
+		c.expr(stmt.Value, s)
+
+	case *ast.ReturnStatement:
+		c.expr(stmt.ReturnValue, s)
+
+	case *ast.YieldStatement:
+		c.expr(stmt.Value, s)
+
+	case *ast.ThrowStatement:
+		c.expr(stmt.Value, s)
+
+	case *ast.DeleteStatement:
+		t := c.expr(stmt.Value, s)
+		if t.Kind != Unknown && t.Kind != Pointer {
+			c.errorf(stmt.Token, "delete applied to non-pointer %s", t)
+		}
+
+	case *ast.BlockStatement:
+		c.block(stmt, s)
+
+	case *ast.TryStatement:
+		c.block(stmt.Block, s)
+		if stmt.Catch != nil {
+			catch := newScope(s)
+			if stmt.CatchParam != nil {
+				catch.vars[stmt.CatchParam.Value] = &binding{typ: unknown}
+			}
+			c.block(stmt.Catch, catch)
+		}
+		c.block(stmt.Finally, s)
+
+	case *ast.SelectStatement:
+		for _, sc := range stmt.Cases {
+			c.expr(sc.Channel, s)
+			c.expr(sc.Value, s)
+			body := newScope(s)
+			if sc.Name != nil {
+				body.vars[sc.Name.Value] = &binding{typ: unknown}
+			}
+			c.block(sc.Body, body)
+		}
+		if stmt.Default != nil {
+			c.block(stmt.Default, newScope(s))
+		}
+
+	case *ast.EnumStatement:
+		s.vars[stmt.Name.Value] = &binding{typ: unknown}
+
+	case *ast.ImportStatement:
+		name := evaluator.ModuleName(stmt.Path.Value)
+		if stmt.Alias != nil {
+			name = stmt.Alias.Value
+		}
+		s.vars[name] = &binding{typ: &Type{Kind: Module}}
+	}
+}
+
+func (c *checker) letStatement(stmt *ast.LetStatement, s *scope) {
+	val := c.expr(stmt.Value, s)
+	switch val.Kind {
+	case Class:
+		if val.Struct.Name == "" {
+			val.Struct.Name = stmt.Name.Value
+		}
+	case Func:
+		if val.Fn.Name == "" {
+			val.Fn.Name = stmt.Name.Value
+		}
+	}
+
+	if stmt.Type != "" {
+		declared := c.resolve(stmt.Name.Token, stmt.Type, nil, false, s)
+		c.checkAssign(stmt.Name, declared, val)
+		s.vars[stmt.Name.Value] = &binding{typ: declared, annotated: true}
+		return
+	}
+	if stmt.Declare {
+		s.vars[stmt.Name.Value] = &binding{typ: val}
+		return
+	}
+	c.assign(stmt.Name, val, s)
+}
+
+// assign follows the evaluator: the nearest variable with the name is
+// updated, and a new one is created only when none exists.
+func (c *checker) assign(name *ast.Identifier, val *Type, s *scope) {
+	b, ok := s.lookup(name.Value)
+	if !ok {
+		s.vars[name.Value] = &binding{typ: val}
+		return
+	}
+	if b.annotated {
+		c.checkAssign(name, b.typ, val)
+		return
+	}
+	if !same(b.typ, val) {
+		b.typ = unknown
+	}
+}
+
+func (c *checker) checkAssign(name *ast.Identifier, declared, val *Type) {
+	if !assignable(declared, val) {
+		c.errorf(name.Token, "cannot assign %s to %s of type %s", val, name.Value, declared)
+	}
+}
+
+// resolve turns a type annotation into a type. args are the type arguments
+// of a generic instance (`Pair<int, string>`), if any.
+func (c *checker) resolve(tok token.Token, name string, args []*ast.TypeExpr, pointer bool, s *scope) *Type {
+	return c.resolveWith(tok, name, args, pointer, nil, s)
+}
+
+// resolveWith is resolve where the type parameters in params stand for
+// the types bound to them.
+func (c *checker) resolveWith(tok token.Token, name string, args []*ast.TypeExpr, pointer bool, params map[string]*Type, s *scope) *Type {
+	if bound, ok := params[name]; ok && len(args) == 0 {
+		return bound
+	}
+	if name == "[]" {
+		return &Type{Kind: Array, Args: []*Type{c.typeExpr(tok, args[0], params, s)}}
+	}
+	if t, ok := primitive(name); ok {
+		if len(args) > 0 {
+			c.errorf(tok, "%s is not generic", name)
+		}
+		return t
+	}
+
+	b, ok := s.lookup(name)
+	if !ok {
+		// p* is the untyped pointer of older scripts
+		if name == "p" {
+			return unknown
+		}
+		c.errorf(tok, "unknown type: %s", name)
+		return unknown
+	}
+	if b.typ.Kind == Unknown {
+		return unknown
+	}
+	if b.typ.Kind != Class {
+		c.errorf(tok, "%s is not a type", name)
+		return unknown
+	}
+
+	t := &Type{Kind: Struct, Struct: b.typ.Struct}
+	if pointer {
+		t.Kind = Pointer
+	}
+	resolved := make([]*Type, len(args))
+	for i, arg := range args {
+		resolved[i] = c.typeExpr(tok, arg, params, s)
+	}
+	t.Args = c.bindTypeArgs(tok, name, b.typ.Struct.TypeParams, resolved, s)
+	return t
+}
+
+// typeExpr resolves a type argument, reporting problems at tok.
+func (c *checker) typeExpr(tok token.Token, expr *ast.TypeExpr, params map[string]*Type, s *scope) *Type {
+	return c.resolveWith(tok, expr.Name, expr.Args, false, params, s)
+}
+
+// bindTypeArgs checks the type arguments of the generic called name
+// against its parameters, their number and their constraints, and returns
+// them if they fit. Only the builtin constraints are checked; interfaces
+// are left to the runtime, except that no primitive implements one. An
+// interface declared with a builtin constraint's name replaces it.
+func (c *checker) bindTypeArgs(tok token.Token, name string, params []*ast.TypeParam, args []*Type, s *scope) []*Type {
+	if len(args) == 0 {
+		return nil
+	}
+	if len(params) == 0 {
+		c.errorf(tok, "%s is not generic", name)
+		return nil
+	}
+	if len(args) != len(params) {
+		c.errorf(tok, "%s expects %d type %s, got %d", name, len(params), plural(len(params), "argument"), len(args))
+		return nil
+	}
+	satisfied := true
+	for i, param := range params {
+		kinds, ok := builtinConstraints[param.Constraint]
+		if _, declared := s.lookup(param.Constraint); declared {
+			kinds, ok = nil, true
+			if args[i].Kind >= Func {
+				continue
+			}
+		}
+		if !ok || args[i].Kind == Unknown {
+			continue
+		}
+		admitted := false
+		for _, kind := range kinds {
+			admitted = admitted || args[i].Kind == kind
+		}
+		if !admitted {
+			c.errorf(tok, "%s does not satisfy %s, required by %s of %s", args[i], param.Constraint, param.Name, name)
+			satisfied = false
+		}
+	}
+	if !satisfied {
+		return nil
+	}
+	return args
+}
+
+func (c *checker) expr(node ast.Expression, s *scope) *Type {
+	switch node := node.(type) {
+	case nil:
+		return unknown
+
+	case *ast.IntegerLiteral:
+		return &Type{Kind: Int}
+	case *ast.FloatLiteral:
+		return &Type{Kind: Float}
+	case *ast.StringLiteral:
+		return &Type{Kind: String}
+	case *ast.TemplateLiteral:
+		for _, part := range node.Parts {
+			c.expr(part, s)
+		}
+		return &Type{Kind: String}
+	case *ast.Boolean:
+		return &Type{Kind: Bool}
+
+	case *ast.Identifier:
+		if b, ok := s.lookup(node.Value); ok {
+			return b.typ
+		}
+		return unknown
+
+	case *ast.PrefixExpression:
+		right := c.expr(node.Right, s)
+		switch node.Operator {
+		case "!":
+			return &Type{Kind: Bool}
+		case "-", "++", "--":
+			if right.Kind == Int || right.Kind == Float {
+				return right
+			}
+		}
+		return unknown
+
+	case *ast.BinaryExpression:
+		return binaryResult(node.Operator, c.expr(node.Left, s), c.expr(node.Right, s))
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			c.expr(el, s)
+		}
+		return &Type{Kind: Array}
+
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			c.expr(k, s)
+			c.expr(v, s)
+		}
+		return &Type{Kind: Hash}
+
+	case *ast.IndexExpression:
+		c.expr(node.Left, s)
+		c.expr(node.Index, s)
+		return unknown
+
+	case *ast.StructLiteral:
+		return c.structLiteral(node, s)
+
+	case *ast.InterfaceLiteral:
+		for _, embed := range node.Embeds {
+			c.expr(embed, s)
+		}
+		return unknown
+
+	case *ast.FunctionLiteral:
+		c.queue = append(c.queue, pending{fn: node, outer: s})
+		return &Type{Kind: Func, Fn: &FuncType{Params: len(node.Parameters), Required: required(node),
+			Variadic: node.Rest != nil, TypeParams: node.TypeParams, ParamTypes: node.ParamTypes}}
+
+	case *ast.SpreadExpression:
+		c.expr(node.Value, s)
+		return unknown
+
+	case *ast.CallExpression:
+		return c.call(node, s)
+
+	case *ast.PropertyAccessExpression:
+		obj := c.expr(node.Object, s)
+		if f := field(obj, node.Property.Value); f != nil {
+			return obj.fieldType(f)
+		}
+		return unknown
+
+	case *ast.AssignExpression:
+		return c.assignExpression(node, s)
+
+	case *ast.NewExpression:
+		b, ok := s.lookup(node.Class)
+		if !ok || b.typ.Kind == Unknown || b.typ.Kind != Class {
+			for _, arg := range node.Arguments {
+				c.expr(arg, s)
+			}
+			if ok && b.typ.Kind != Unknown {
+				c.errorf(node.Token, "cannot instantiate %s", node.Class)
+			}
+			return unknown
+		}
+		st := b.typ.Struct
+		c.construct(node.Token, st, node.Arguments, s)
+		return c.resolve(node.Token, node.Class, node.TypeArgs, true, s)
+
+	case *ast.IfStatement:
+		c.expr(node.Condition, s)
+		c.block(node.Consequence, s)
+		c.block(node.Alternative, s)
+		return unknown
+
+	case *ast.MatchExpression:
+		c.expr(node.Subject, s)
+		for _, arm := range node.Arms {
+			inner := newScope(s)
+			for _, name := range evaluator.PatternNames(arm.Pattern) {
+				inner.vars[name] = &binding{typ: unknown}
+			}
+			if arm.Guard != nil {
+				c.expr(arm.Guard, inner)
+			}
+			c.block(arm.Body, inner)
+		}
+		return unknown
+
+	case *ast.WhileStatement:
+		c.expr(node.Condition, s)
+		c.block(node.Body, s)
+		return unknown
+
+	case *ast.ForStatement:
+		loop := newScope(s)
+		if node.Init != nil {
+			c.statement(node.Init, loop)
+		}
+		c.expr(node.Condition, loop)
+		if node.Post != nil {
+			c.statement(node.Post, loop)
+		}
+		c.block(node.Body, loop)
+		return unknown
+
+	case *ast.ForInStatement:
+		iterable := c.expr(node.Iterable, s)
+		key, value := unknown, unknown
+		switch iterable.Kind {
+		case Int, Float, Bool, Func:
+			c.errorf(node.Iterable.GetToken(), "cannot iterate over %s", iterable)
+		case String:
+			key, value = &Type{Kind: Int}, &Type{Kind: String}
+		case Array:
+			key = &Type{Kind: Int}
+			if len(iterable.Args) > 0 {
+				value = iterable.Args[0]
+			}
+		}
+		loop := newScope(s)
+		if node.Key != nil {
+			loop.vars[node.Key.Value] = &binding{typ: key}
+		}
+		loop.vars[node.Value.Value] = &binding{typ: value}
+		c.block(node.Body, loop)
+		return unknown
+	}
+	return unknown
+}
+
+// construct checks the arguments of a constructor call, which runs the
+// field named after the type.
+func (c *checker) construct(tok token.Token, st *StructType, args []ast.Expression, s *scope) {
+	for _, arg := range args {
+		c.expr(arg, s)
+	}
+	ctor, ok := st.Fields[st.Name]
+	if !ok || ctor.Type == nil || ctor.Type.Kind != Func {
+		if len(args) > 0 {
+			c.errorf(tok, "%s has no constructor", st.Name)
+		}
+		return
+	}
+	if fn := ctor.Type.Fn; !spreads(args) && !fn.accepts(len(args)) {
+		c.errorf(tok, "%s expects %s, got %d", st.Name, fn.arity("constructor argument"), len(args))
+	}
+}
+
+// binaryResult is the type of left op right, where the evaluator's rules
+// make it certain.
+func binaryResult(op string, left, right *Type) *Type {
+	switch op {
+	case "==", "!=", "<", ">", "<=", ">=", "&&", "||":
+		return &Type{Kind: Bool}
+	}
+	switch {
+	case left.Kind == Int && right.Kind == Int:
+		return left
+	case (left.Kind == Int || left.Kind == Float) && (right.Kind == Int || right.Kind == Float):
+		return &Type{Kind: Float}
+	case op == "+" && left.Kind == String && right.Kind == String:
+		return left
+	}
+	return unknown
+}
+
+func (c *checker) structLiteral(node *ast.StructLiteral, s *scope) *Type {
+	st := &StructType{TypeParams: node.TypeParams, Fields: make(map[string]*Field)}
+	for name, value := range node.Pairs {
+		f := &Field{}
+		if ident, ok := value.(*ast.Identifier); ok && evaluator.IsTypeParam(node.TypeParams, ident.Value) {
+			f.Param = ident.Value
+		} else if ok && (ident.Value == "int" || ident.Value == "float" || ident.Value == "string") {
+			// the evaluator gives these fields a zero value of the type
+			f.Type, _ = primitive(ident.Value)
+		} else if t := c.expr(value, s); t.Kind == Func {
+			t.Fn.Name = name
+			f.Type = t
+		}
+		st.Fields[name] = f
+	}
+	// embedded structs promote the fields the literal does not declare
+	for _, embed := range node.Embeds {
+		base := c.expr(embed, s)
+		if base.Kind != Class {
+			st.Open = true
+			continue
+		}
+		st.Open = st.Open || base.Struct.Open
+		for name, f := range base.Struct.Fields {
+			if _, exists := st.Fields[name]; !exists {
+				st.Fields[name] = f
+			}
+		}
+	}
+	return &Type{Kind: Class, Struct: st}
+}
+
+// field finds a field of an instance or pointer type.
+func field(t *Type, name string) *Field {
+	if t.Kind != Struct && t.Kind != Pointer {
+		return nil
+	}
+	return t.Struct.Fields[name]
+}
+
+func (c *checker) assignExpression(node *ast.AssignExpression, s *scope) *Type {
+	val := c.expr(node.Value, s)
+	switch left := node.Left.(type) {
+	case *ast.Identifier:
+		c.assign(left, val, s)
+
+	case *ast.PropertyAccessExpression:
+		obj := c.expr(left.Object, s)
+		name := left.Property.Value
+		f := field(obj, name)
+		if f == nil {
+			// struct values grow new fields, heap blocks do not
+			if obj.Kind == Pointer && !obj.Struct.Open {
+				c.errorf(left.Property.Token, "struct %s has no field %s", obj.Struct.Name, name)
+			}
+			break
+		}
+		if want := obj.fieldType(f); !assignable(want, val) {
+			c.errorf(left.Property.Token, "cannot assign %s to field %s of type %s", val, name, want)
+		}
+
+	default:
+		c.expr(node.Left, s)
+	}
+	return val
+}
+
+func (c *checker) call(node *ast.CallExpression, s *scope) *Type {
+	args := make([]*Type, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		args[i] = c.expr(arg, s)
+	}
+
+	callee := c.expr(node.Function, s)
+	ident, named := node.Function.(*ast.Identifier)
+	if callee.Kind == Unknown {
+		if named {
+			if _, shadowed := s.lookup(ident.Value); !shadowed {
+				if kind, ok := builtinResults[ident.Value]; ok {
+					return &Type{Kind: kind}
+				}
+			}
+		}
+		return unknown
+	}
+
+	tok := node.Function.GetToken()
+	if prop, ok := node.Function.(*ast.PropertyAccessExpression); ok {
+		tok = prop.Property.Token
+	}
+	if callee.Kind != Func {
+		c.errorf(tok, "%s is not a function", callee)
+		return unknown
+	}
+	fn := callee.Fn
+	name := fn.Name
+	if named {
+		name = ident.Value
+	}
+	if name == "" {
+		name = "function"
+	}
+
+	if !spreads(node.Arguments) && !fn.accepts(len(args)) {
+		c.errorf(tok, "%s expects %s, got %d", name, fn.arity("argument"), len(args))
+	}
+	if node.TypeArgs != nil {
+		if len(fn.TypeParams) == 0 {
+			c.errorf(tok, "%s is not generic", name)
+			return unknown
+		}
+		c.genericArgs(tok, name, fn, node, args, s)
+	}
+	return unknown
+}
+
+// genericArgs checks the arguments of a generic call the way the evaluator
+// does: parameters annotated with a type that uses the type parameters take
+// that type and, with a single type parameter, unannotated ones take it.
+func (c *checker) genericArgs(tok token.Token, name string, fn *FuncType, node *ast.CallExpression, args []*Type, s *scope) {
+	typeArgs := make([]*Type, len(node.TypeArgs))
+	for i, arg := range node.TypeArgs {
+		typeArgs[i] = c.typeExpr(tok, arg, nil, s)
+	}
+	if c.bindTypeArgs(tok, name, fn.TypeParams, typeArgs, s) == nil {
+		return
+	}
+	params := make(map[string]*Type, len(typeArgs))
+	for i, param := range fn.TypeParams {
+		params[param.Name] = typeArgs[i]
+	}
+	for i, arg := range args {
+		var expr *ast.TypeExpr
+		if i < len(fn.ParamTypes) {
+			expr = fn.ParamTypes[i]
+		}
+		if expr == nil && len(fn.TypeParams) == 1 {
+			expr = &ast.TypeExpr{Token: tok, Name: fn.TypeParams[0].Name}
+		}
+		if expr == nil || !usesTypeParams(expr, params) {
+			continue
+		}
+		if want := c.typeExpr(tok, expr, params, s); !assignable(want, arg) {
+			c.errorf(node.Arguments[i].GetToken(), "generic type mismatch: %s<%s> got %s for argument %d",
+				name, ast.TypeArgsString(node.TypeArgs), arg, i+1)
+		}
+	}
+}
+
+func usesTypeParams(expr *ast.TypeExpr, params map[string]*Type) bool {
+	if _, ok := params[expr.Name]; ok {
+		return true
+	}
+	for _, arg := range expr.Args {
+		if usesTypeParams(arg, params) {
+			return true
+		}
+	}
+	return false
+}
+
+// required is the number of parameters of fn without a default.
+func required(fn *ast.FunctionLiteral) int {
+	for i, def := range fn.Defaults {
+		if def != nil {
+			return i
+		}
+	}
+	return len(fn.Parameters)
+}
+
+// spreads reports whether a call passes ...xs, whose length is only known
+// when it runs.
+func spreads(args []ast.Expression) bool {
+	for _, arg := range args {
+		if _, ok := arg.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,223 @@
+package typecheck
+
+import (
+	"Nikium/ast"
+	"Nikium/lexer"
+	"Nikium/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func messages(errs []*Error) []string {
+	out := make([]string, len(errs))
+	for i, err := range errs {
+		out[i] = err.Error()
+	}
+	return out
+}
+
+func TestAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`x:int = 5; y:i64 = x * 2; z:float = y; s:string = "a" + "b";`, nil},
+		{`x:int = "five";`, []string{"TypeError: cannot assign string to x of type int on line 1, col 1"}},
+		{`x:bool = 1 < 2; x = 3;`, []string{"TypeError: cannot assign int to x of type bool on line 1, col 17"}},
+		{`int n = 2.5;`, []string{"TypeError: cannot assign float to n of type int on line 1, col 5"}},
+		{`w:Widget = 1;`, []string{"TypeError: unknown type: Widget on line 1, col 1"}},
+		{`f = fn() {}; f n;`, []string{"TypeError: f is not a type on line 1, col 14"}},
+		// assignments inside functions reach the annotated global
+		{"count:int = 0;\nbump = fn() { count = \"many\"; };", []string{"TypeError: cannot assign string to count of type int on line 2, col 15"}},
+		// a let in a function shadows it
+		{"count:int = 0;\nf = fn() { let count = \"many\"; };", nil},
+		// parameters and unannotated variables are not constrained
+		{`f = fn(a) { x:int = a; }; y = 1; y = "one";`, nil},
+	}
+
+	for _, tt := range tests {
+		got := messages(Check(parse(t, tt.input)))
+		if len(got) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestGenerics(t *testing.T) {
+	box := "generic<T> Box = struct { value: T, count: int };\nNode = struct { val: 0 };\n"
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{box + `Box<int>* b = new Box<int>(); b->value = 1; b->count = 2;`, nil},
+		{box + `Box<string> b; b.value = "s";`, nil},
+		{box + `Box<int>* b = new Box<int>(); b->value = "s";`, []string{"TypeError: cannot assign string to field value of type int on line 3, col 34"}},
+		{box + `Box<int>* b = new Box<string>();`, []string{"TypeError: cannot assign *Box<string> to b of type *Box<int> on line 3, col 11"}},
+		{box + `Node* n = new Box<int>();`, []string{"TypeError: cannot assign *Box<int> to n of type *Node on line 3, col 7"}},
+		{box + `Box b; b.count = "x";`, []string{"TypeError: cannot assign string to field count of type int on line 3, col 10"}},
+		{box + `Node<int> n;`, []string{"TypeError: Node is not generic on line 3, col 1"}},
+		// embedded fields are promoted, and keep their types
+		{box + `Tree = struct { ...Node, left: 0 }; Tree* t = new Tree(); t->val = 1; t->left = 2;`, nil},
+		{box + `Tree = struct { ...Node }; Tree* t = new Tree(); t->next = 1;`, []string{"TypeError: struct Tree has no field next on line 3, col 53"}},
+		{box + `Tree = struct { ...Box }; Tree* t = new Tree(); t->count = "x";`, []string{"TypeError: cannot assign string to field count of type int on line 3, col 52"}},
+		{box + `Tree = struct { ...lib.Node }; Tree* t = new Tree(); t->next = 1;`, nil},
+		{box + `Box<Widget> b;`, []string{"TypeError: unknown type: Widget on line 3, col 1"}},
+		{box + `Node* n = new Node(); n->next = n;`, []string{"TypeError: struct Node has no field next on line 3, col 26"}},
+		// struct values take new fields
+		{box + `Node n; n.next = 1;`, nil},
+		{"generic<T> id = fn(a) { return a; };\nint a = id<int>(5);", nil},
+		{"generic<T> id = fn(a) { return a; };\nint a = id<int>(\"5\");", []string{"TypeError: generic type mismatch: id<int> got string for argument 1 on line 2, col 17"}},
+		{"id = fn(a) { return a; };\nint a = id<int>(5);", []string{"TypeError: id is not generic on line 2, col 9"}},
+	}
+
+	for _, tt := range tests {
+		got := messages(Check(parse(t, tt.input)))
+		if len(got) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestGenericParams(t *testing.T) {
+	pair := "generic<K, V> Pair = struct { key: K, value: V };\ngeneric<T: Comparable> max = fn(a: T, b: T) { return a; };\n"
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{pair + `Pair<string, int> p; p.key = "a"; p.value = 1;`, nil},
+		{pair + `Pair<string, int> p; p.value = "a";`, []string{"TypeError: cannot assign string to field value of type int on line 3, col 24"}},
+		{pair + `Pair<string> p;`, []string{"TypeError: Pair expects 2 type arguments, got 1 on line 3, col 1"}},
+		{pair + `Pair<int, Pair<int, string>>* p = new Pair<int, Pair<int, bool>>();`,
+			[]string{"TypeError: cannot assign *Pair<int, Pair<int, bool>> to p of type *Pair<int, Pair<int, string>> on line 3, col 31"}},
+		{pair + `Pair<int, []string> p; p.value = 1;`, []string{"TypeError: cannot assign int to field value of type []string on line 3, col 26"}},
+		{pair + `int m = max<int>(1, 2);`, nil},
+		{pair + `int m = max<int>(1, "2");`, []string{"TypeError: generic type mismatch: max<int> got string for argument 2 on line 3, col 21"}},
+		{pair + `max<bool>(true, false);`, []string{"TypeError: bool does not satisfy Comparable, required by T of max on line 3, col 1"}},
+		{pair + `max<Pair<int, int>>(1, 2);`, []string{"TypeError: Pair<int, int> does not satisfy Comparable, required by T of max on line 3, col 1"}},
+		// a declared interface replaces the builtin constraint of that name
+		{"Comparable = interface { compare(other) };\ngeneric<T: Comparable> Box = struct { v: T };\nBox<int> b;",
+			[]string{"TypeError: int does not satisfy Comparable, required by T of Box on line 3, col 1"}},
+		{"Comparable = interface { compare(other) };\nP = struct { compare: fn(o) { return 0; } };\ngeneric<T: Comparable> Box = struct { v: T };\nBox<P> b;", nil},
+		{"generic<K, V> put = fn(h, k: K, v: V) { return h; };\nput<string, int>({}, \"a\", \"b\");",
+			[]string{"TypeError: generic type mismatch: put<string, int> got string for argument 3 on line 2, col 27"}},
+	}
+
+	for _, tt := range tests {
+		got := messages(Check(parse(t, tt.input)))
+		if len(got) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestArity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`add = fn(a, b) { return a + b; }; add(1, 2);`, nil},
+		{`add = fn(a, b) { return a + b; }; add(1);`, []string{"TypeError: add expects 2 arguments, got 1 on line 1, col 35"}},
+		{`one = fn(a) { return a; }; one(1, 2);`, []string{"TypeError: one expects 1 argument, got 2 on line 1, col 28"}},
+		// recursion and calls to functions defined later are checked
+		{"fib = fn(n) { if (n < 2) { return n; } return fib(n - 1) + fib(); };", []string{"TypeError: fib expects 1 argument, got 0 on line 1, col 60"}},
+		{"main = fn() { helper(1); };\nhelper = fn() {};", []string{"TypeError: helper expects 0 arguments, got 1 on line 1, col 15"}},
+		// a name bound to functions of different arities is not checked
+		{`f = fn(a) {}; f = fn(a, b) {}; f(1, 2);`, nil},
+		{`Counter = struct { add: fn(n) { return n; } }; Counter c; c.add();`, []string{"TypeError: add expects 1 argument, got 0 on line 1, col 61"}},
+		{`P = struct { P: fn(a, b) {} }; P p(1, 2); q = new P(1, 2);`, nil},
+		{`P = struct { P: fn(a, b) {} }; P p(1);`, []string{"TypeError: P expects 2 constructor arguments, got 1 on line 1, col 34"}},
+		{`P = struct { P: fn(a) {} }; q = new P();`, []string{"TypeError: P expects 1 constructor argument, got 0 on line 1, col 33"}},
+		{`P = struct { v: 1 }; P p(1);`, []string{"TypeError: P has no constructor on line 1, col 24"}},
+		{`int n(1);`, []string{"TypeError: int has no constructor on line 1, col 5"}},
+		{`f = fn(a, b = 1) {}; f(1); f(1, 2); f();`, []string{"TypeError: f expects 1 to 2 arguments, got 0 on line 1, col 37"}},
+		{`f = fn(a, ...rest) {}; f(1, 2, 3); f();`, []string{"TypeError: f expects at least 1 argument, got 0 on line 1, col 36"}},
+		{`f = fn(a, b) {}; f(...[1, 2]); f(1, ...[]);`, nil},
+		{`f = fn(a, ...rest) { rest(); };`, []string{"TypeError: array is not a function on line 1, col 22"}},
+		{`P = struct { P: fn(a, b = 0) {} }; P p(1); P q(1, 2, 3);`, []string{"TypeError: P expects 1 to 2 constructor arguments, got 3 on line 1, col 46"}},
+		{`for (c in "ab") { c(); }`, []string{"TypeError: string is not a function on line 1, col 19"}},
+		{`n = 3; for (x in n) { }`, []string{"TypeError: cannot iterate over int on line 1, col 18"}},
+		{`for (i, x in [1, 2]) { i(); }`, []string{"TypeError: int is not a function on line 1, col 24"}},
+		{`s = "a${1}"; s();`, []string{"TypeError: string is not a function on line 1, col 14"}},
+		{`x = 5; c = channel(); select { case v = recv(c): x(); }`, []string{"TypeError: int is not a function on line 1, col 50"}},
+		{`x = 5; g = fn() { yield x(); };`, []string{"TypeError: int is not a function on line 1, col 25"}},
+		{`x = 5; x();`, []string{"TypeError: int is not a function on line 1, col 8"}},
+		{`n = len([1]) + 1; s:string = n;`, []string{"TypeError: cannot assign int to s of type string on line 1, col 19"}},
+		{`delete 5;`, []string{"TypeError: delete applied to non-pointer int on line 1, col 1"}},
+		// match arms are checked, with their bindings unknown
+		{`one = fn(a) {}; match 5 { [n] if one(n) => one(), n => one(n) };`, []string{"TypeError: one expects 1 argument, got 0 on line 1, col 44"}},
+	}
+
+	for _, tt := range tests {
+		got := messages(Check(parse(t, tt.input)))
+		if len(got) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], got[i])
+			}
+		}
+	}
+}
+
+func TestErrorsInSourceOrder(t *testing.T) {
+	input := "f = fn() { x:int = \"a\"; };\ny:int = \"b\";"
+	got := messages(Check(parse(t, input)))
+	expected := []string{
+		"TypeError: cannot assign string to x of type int on line 1, col 12",
+		"TypeError: cannot assign string to y of type int on line 2, col 1",
+	}
+	if len(got) != 2 || got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("wrong errors. want=%q, got=%q", expected, got)
+	}
+}
+
+// The examples and the standard library are known to run, so the checker
+// must not flag them.
+func TestExamplesAreClean(t *testing.T) {
+	files, _ := filepath.Glob("../example_codes/*.nik")
+	lib, _ := filepath.Glob("../stdlib/*.nik")
+	for _, file := range append(files, lib...) {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := parser.New(lexer.New(string(content)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			continue
+		}
+		if errs := Check(program); len(errs) != 0 {
+			t.Errorf("%s: unexpected errors: %q", file, messages(errs))
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package typecheck
+
+import (
+	"Nikium/ast"
+	"strconv"
+	"strings"
+)
+
+// Kind is the broad shape of a value the checker can tell apart.
+type Kind int
+
+const (
+	Unknown Kind = iota // anything: parameters, imported members, builtins
+	Int
+	Float
+	String
+	Bool
+	Array
+	Hash
+	Func
+	Class   // a struct literal bound to a name, used as a type
+	Struct  // an instance of a class
+	Pointer // a heap instance created with new
+	Module
+)
+
+// Type is what the checker knows about a value.
+type Type struct {
+	Kind   Kind
+	Struct *StructType // Class, Struct and Pointer
+	Args   []*Type     // type arguments of a generic instance, or an array's element type
+	Fn     *FuncType   // Func
+}
+
+// StructType describes the fields of a struct literal.
+type StructType struct {
+	Name       string
+	TypeParams []*ast.TypeParam // from generic<K, V>
+	Fields     map[string]*Field
+	// Open is set when the struct embeds one the checker cannot see, so
+	// it may have more fields than Fields lists.
+	Open bool
+}
+
+// Field is one entry of a struct literal.
+type Field struct {
+	// Type is set for fields declared with a type name (`age: int`) and
+	// for methods; fields that only hold a starting value are untyped.
+	Type *Type
+	// Param names the struct's type parameter the field is declared with.
+	Param string
+}
+
+// FuncType describes a function literal.
+type FuncType struct {
+	Name       string
+	Params     int
+	Required   int  // parameters without a default
+	Variadic   bool // ends in ...rest
+	TypeParams []*ast.TypeParam
+	ParamTypes []*ast.TypeExpr
+}
+
+func (f *FuncType) accepts(n int) bool {
+	return n >= f.Required && (f.Variadic || n <= f.Params)
+}
+
+// arity describes how many arguments f takes, as "2 arguments",
+// "1 to 2 arguments" or "at least 1 argument".
+func (f *FuncType) arity(noun string) string {
+	switch {
+	case f.Variadic:
+		return "at least " + strconv.Itoa(f.Required) + " " + plural(f.Required, noun)
+	case f.Required < f.Params:
+		return strconv.Itoa(f.Required) + " to " + strconv.Itoa(f.Params) + " " + plural(f.Params, noun)
+	}
+	return strconv.Itoa(f.Params) + " " + plural(f.Params, noun)
+}
+
+var unknown = &Type{Kind: Unknown}
+
+func (t *Type) String() string {
+	switch t.Kind {
+	case Int:
+		return "int"
+	case Float:
+		return "float"
+	case String:
+		return "string"
+	case Bool:
+		return "bool"
+	case Array:
+		if len(t.Args) > 0 {
+			return "[]" + t.Args[0].String()
+		}
+		return "array"
+	case Hash:
+		return "hash"
+	case Func:
+		return "fn(" + strconv.Itoa(t.Fn.Params) + ")"
+	case Class:
+		return "struct " + t.Struct.Name
+	case Struct:
+		return t.instanceName()
+	case Pointer:
+		return "*" + t.instanceName()
+	case Module:
+		return "module"
+	}
+	return "unknown"
+}
+
+func (t *Type) instanceName() string {
+	if len(t.Args) == 0 {
+		return t.Struct.Name
+	}
+	args := make([]string, len(t.Args))
+	for i, arg := range t.Args {
+		args[i] = arg.String()
+	}
+	return t.Struct.Name + "<" + strings.Join(args, ", ") + ">"
+}
+
+// same reports whether a and b are the same type, down to function arity.
+func same(a, b *Type) bool {
+	if a.Kind != b.Kind || a.Struct != b.Struct {
+		return false
+	}
+	if a.Fn != nil && b.Fn != nil && a.Fn.Params != b.Fn.Params {
+		return false
+	}
+	if len(a.Args) != len(b.Args) {
+		return false
+	}
+	for i := range a.Args {
+		if !same(a.Args[i], b.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// assignable reports whether a value of type src may be stored where dst
+// is declared. Anything unknown passes, and integers widen to floats.
+func assignable(dst, src *Type) bool {
+	if dst.Kind == Unknown || src.Kind == Unknown {
+		return true
+	}
+	if dst.Kind == Float && src.Kind == Int {
+		return true
+	}
+	if dst.Kind != src.Kind || dst.Struct != src.Struct {
+		return false
+	}
+	// an uninstantiated generic matches any instance, and an array of
+	// unknown elements any array
+	if len(dst.Args) == 0 || len(src.Args) == 0 || len(dst.Args) != len(src.Args) {
+		return true
+	}
+	for i := range dst.Args {
+		if !assignable(dst.Args[i], src.Args[i]) || !assignable(src.Args[i], dst.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// primitive maps the built-in type names usable in annotations.
+func primitive(name string) (*Type, bool) {
+	switch name {
+	case "int", "i8", "i16", "i32", "i64", "u8", "u16", "u32", "u64", "uint":
+		return &Type{Kind: Int}, true
+	case "float", "f32", "f64":
+		return &Type{Kind: Float}, true
+	case "string":
+		return &Type{Kind: String}, true
+	case "bool":
+		return &Type{Kind: Bool}, true
+	case "array":
+		return &Type{Kind: Array}, true
+	case "hash":
+		return &Type{Kind: Hash}, true
+	}
+	return nil, false
+}
+
+// fieldType returns the type of a field read through an instance of t.
+func (t *Type) fieldType(f *Field) *Type {
+	if f.Param != "" {
+		for i, param := range t.Struct.TypeParams {
+			if param.Name == f.Param && i < len(t.Args) {
+				return t.Args[i]
+			}
+		}
+		return unknown
+	}
+	if f.Type != nil {
+		return f.Type
+	}
+	return unknown
+}
+
+// builtinConstraints are the constraints of generic<T: Comparable> that are
+// not interfaces, by the kinds of value they admit.
+var builtinConstraints = map[string][]Kind{
+	"Comparable": {Int, Float, String},
+	"Numeric":    {Int, Float},
+	"Hashable":   {Int, String, Bool},
+}
+
+// builtinResults are the result types of builtins that always return the
+// same kind of value.
+var builtinResults = map[string]Kind{
+	"len":          Int,
+	"ord":          Int,
+	"chr":          String,
+	"str":          String,
+	"type":         String,
+	"int":          Int,
+	"float":        Float,
+	"float_format": String,
+	"time_now":     Int,
+	"keys":         Array,
+	"values":       Array,
+	"has_key":      Bool,
+	"file_exists":  Bool,
+}
@@ -0,0 +1,63 @@
+package vm
+
+import (
+	"Nikium/compiler"
+	"Nikium/evaluator"
+)
+
+const CLOSURE_OBJ = "CLOSURE"
+
+// cell boxes a local that a closure has captured, so the function that
+// declared it and every closure over it see the same variable.
+type cell struct {
+	value evaluator.Object
+}
+
+func (c *cell) Type() evaluator.ObjectType { return "CELL" }
+func (c *cell) Inspect() string {
+	if c.value == nil {
+		return "cell()"
+	}
+	return "cell(" + c.value.Inspect() + ")"
+}
+
+type Closure struct {
+	Fn   *compiler.CompiledFunction
+	Free []*cell
+	// Self is the struct or pointer a method was read from. Closures made
+	// inside a method start with the method's receiver.
+	Self evaluator.Object
+
+	vm *VM // runs Call
+}
+
+func (c *Closure) Type() evaluator.ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string            { return c.Fn.Inspect() }
+func (c *Closure) Accepts(n int) bool {
+	return evaluator.ArityError(c.Fn.NumRequired, c.Fn.NumParameters, c.Fn.Variadic, n) == nil
+}
+
+// Call runs the closure to completion on the VM that made it, so natives
+// and for-in loops can call back into compiled code.
+func (c *Closure) Call(args []evaluator.Object) evaluator.Object {
+	return c.vm.call(c, args)
+}
+
+// Bind returns the method with self and this set to receiver.
+func (c *Closure) Bind(receiver evaluator.Object) evaluator.Object {
+	return &Closure{Fn: c.Fn, Free: c.Free, Self: receiver, vm: c.vm}
+}
+
+type Frame struct {
+	cl          *Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() compiler.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,800 @@
+// Package vm runs bytecode produced by the compiler package. It shares the
+// object model, builtins and operator rules of the evaluator package, which
+// stays the reference implementation.
+package vm
+
+import (
+	"Nikium/compiler"
+	"Nikium/evaluator"
+	"fmt"
+)
+
+const StackSize = 2048
+const MaxFrames = 1024
+
+// RuntimeError wraps the Nikium error that stopped the VM.
+type RuntimeError struct {
+	Err *evaluator.Error
+}
+
+func (e *RuntimeError) Error() string { return e.Err.Inspect() }
+
+type VM struct {
+	constants   []evaluator.Object
+	globals     []evaluator.Object
+	globalNames []string
+
+	stack []evaluator.Object
+	sp    int // always points to the next free slot; top of stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+	stopAt      int // run returns when a return brings framesIndex down to it
+
+	handlers []handler
+
+	modules     []*compiler.CompiledModule
+	moduleCache []*evaluator.Module
+
+	lastPopped evaluator.Object
+}
+
+// handler is an active try block: where its catch code starts and the frame
+// and stack height to unwind to when an error is raised.
+type handler struct {
+	framesIndex int
+	ip          int
+	sp          int
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &compiler.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+	}
+	frames := make([]*Frame, MaxFrames)
+	vm := &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]evaluator.Object, len(bytecode.GlobalNames)),
+		globalNames: bytecode.GlobalNames,
+		stack:       make([]evaluator.Object, StackSize),
+		frames:      frames,
+		framesIndex: 1,
+		modules:     bytecode.Modules,
+		moduleCache: make([]*evaluator.Module, len(bytecode.Modules)),
+	}
+	frames[0] = NewFrame(&Closure{Fn: mainFn, vm: vm}, 0)
+	return vm
+}
+
+// LastPoppedStackElem returns the value of the last expression statement,
+// or the value of a top-level return.
+func (vm *VM) LastPoppedStackElem() evaluator.Object {
+	return vm.lastPopped
+}
+
+// Run executes the program, resuming at the innermost try handler whenever
+// a runtime error is raised inside one.
+func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if !vm.catch(err) {
+			return err
+		}
+	}
+}
+
+// call runs cl from Go on top of whatever the VM is running and returns
+// its result. A runtime error that no try inside cl catches comes back as
+// the *evaluator.Error, with the VM unwound to where the call started.
+func (vm *VM) call(cl *Closure, args []evaluator.Object) evaluator.Object {
+	sp, framesIndex, handlers, stopAt := vm.sp, vm.framesIndex, len(vm.handlers), vm.stopAt
+	defer func() { vm.stopAt = stopAt }()
+
+	err := vm.push(cl)
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+	if err == nil {
+		err = vm.callFunction(len(args))
+	}
+	if err == nil && vm.framesIndex > framesIndex {
+		vm.stopAt = framesIndex
+		for err = vm.run(); err != nil; err = vm.run() {
+			if len(vm.handlers) == handlers || !vm.catch(err) {
+				break
+			}
+		}
+	}
+	if err != nil {
+		vm.sp, vm.framesIndex = sp, framesIndex
+		vm.handlers = vm.handlers[:handlers]
+		if rtErr, ok := err.(*RuntimeError); ok {
+			return rtErr.Err
+		}
+		return evaluator.NewError("%s", err)
+	}
+	result := vm.pop()
+	vm.sp = sp
+	return result
+}
+
+// catch unwinds to the innermost handler and pushes the error as an
+// Exception for the catch code. It reports false if nothing can catch err.
+func (vm *VM) catch(err error) bool {
+	rtErr, ok := err.(*RuntimeError)
+	if !ok || len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.ip - 1
+	vm.push(&evaluator.Exception{Err: rtErr.Err})
+	return true
+}
+
+func (vm *VM) run() error {
+	var ip int
+	var ins compiler.Instructions
+	var op compiler.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		frame := vm.currentFrame()
+		frame.ip++
+		ip = frame.ip
+		ins = frame.Instructions()
+		op = compiler.Opcode(ins[ip])
+
+		switch op {
+		case compiler.OpConstant:
+			idx := compiler.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if err := vm.push(vm.constants[idx]); err != nil {
+				return err
+			}
+
+		case compiler.OpPop:
+			vm.lastPopped = vm.pop()
+
+		case compiler.OpDup:
+			if err := vm.push(vm.stack[vm.sp-1]); err != nil {
+				return err
+			}
+
+		case compiler.OpTrue:
+			if err := vm.push(evaluator.TRUE); err != nil {
+				return err
+			}
+
+		case compiler.OpFalse:
+			if err := vm.push(evaluator.FALSE); err != nil {
+				return err
+			}
+
+		case compiler.OpNull:
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+
+		case compiler.OpAdd, compiler.OpSub, compiler.OpMul, compiler.OpDiv, compiler.OpMod,
+			compiler.OpShl, compiler.OpShr, compiler.OpEqual, compiler.OpNotEqual,
+			compiler.OpLess, compiler.OpGreater, compiler.OpLessEqual, compiler.OpGreaterEqual:
+			right := vm.pop()
+			left := vm.pop()
+			result := executeBinaryOperation(op, left, right)
+			if err := vm.check(result); err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case compiler.OpMinus:
+			right := vm.pop()
+			if i, ok := right.(*evaluator.Integer); ok {
+				vm.push(&evaluator.Integer{Value: -i.Value})
+				break
+			}
+			result := evaluator.EvalPrefix("-", right)
+			if err := vm.check(result); err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case compiler.OpBang:
+			vm.push(evaluator.EvalPrefix("!", vm.pop()))
+
+		case compiler.OpTruthy:
+			vm.push(evaluator.NativeBool(evaluator.IsTruthy(vm.pop())))
+
+		case compiler.OpInc:
+			i, ok := vm.pop().(*evaluator.Integer)
+			if !ok {
+				return vm.fail(evaluator.NewError("++ only integer"))
+			}
+			vm.push(&evaluator.Integer{Value: i.Value + 1})
+
+		case compiler.OpJump:
+			pos := int(compiler.ReadUint32(ins[ip+1:]))
+			frame.ip = pos - 1
+
+		case compiler.OpJumpNotTruthy:
+			pos := int(compiler.ReadUint32(ins[ip+1:]))
+			frame.ip += 4
+			if !evaluator.IsTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+
+		case compiler.OpJumpIfBound:
+			idx := int(compiler.ReadUint8(ins[ip+1:]))
+			pos := int(compiler.ReadUint32(ins[ip+2:]))
+			frame.ip += 5
+			val := vm.stack[frame.basePointer+idx]
+			if c, ok := val.(*cell); ok {
+				val = c.value
+			}
+			if val != nil {
+				frame.ip = pos - 1
+			}
+
+		case compiler.OpIter:
+			it, errObj := evaluator.Iterate(vm.pop())
+			if errObj != nil {
+				return vm.fail(errObj)
+			}
+			vm.push(it)
+
+		case compiler.OpIterNext:
+			n := int(compiler.ReadUint8(ins[ip+1:]))
+			pos := int(compiler.ReadUint32(ins[ip+2:]))
+			frame.ip += 5
+			vals, errObj := vm.pop().(*evaluator.Iterator).Next(n)
+			if errObj != nil {
+				return vm.fail(errObj)
+			}
+			if vals == nil {
+				frame.ip = pos - 1
+				continue
+			}
+			for _, val := range vals {
+				if err := vm.push(val); err != nil {
+					return err
+				}
+			}
+
+		case compiler.OpGetGlobal:
+			idx := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			val := vm.global(idx)
+			if val == nil {
+				name := vm.globalNames[idx]
+				if val = evaluator.TypeZeroValue(name); val == nil {
+					return vm.fail(evaluator.NewError("identifier not found: %s", name))
+				}
+			}
+			if err := vm.push(val); err != nil {
+				return err
+			}
+
+		case compiler.OpSetGlobal:
+			idx := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.setGlobal(idx, vm.pop())
+
+		case compiler.OpGetLocal:
+			idx := int(compiler.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			val := vm.stack[frame.basePointer+idx]
+			if c, ok := val.(*cell); ok {
+				val = c.value
+			}
+			if val == nil {
+				return vm.fail(evaluator.NewError("identifier not found: %s", frame.cl.Fn.LocalNames[idx]))
+			}
+			if err := vm.push(val); err != nil {
+				return err
+			}
+
+		case compiler.OpSetLocal:
+			idx := int(compiler.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			slot := frame.basePointer + idx
+			if c, ok := vm.stack[slot].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
+
+		case compiler.OpGetBuiltin:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if err := vm.push(compiler.Builtins[idx].Fn); err != nil {
+				return err
+			}
+
+		case compiler.OpGetFree:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			c := frame.cl.Free[idx]
+			if c.value == nil {
+				return vm.fail(evaluator.NewError("identifier not found: %s", frame.cl.Fn.FreeNames[idx]))
+			}
+			if err := vm.push(c.value); err != nil {
+				return err
+			}
+
+		case compiler.OpSetFree:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			frame.cl.Free[idx].value = vm.pop()
+
+		case compiler.OpLocalCell:
+			idx := int(compiler.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			slot := frame.basePointer + idx
+			c, ok := vm.stack[slot].(*cell)
+			if !ok {
+				c = &cell{value: vm.stack[slot]}
+				vm.stack[slot] = c
+			}
+			if err := vm.push(c); err != nil {
+				return err
+			}
+
+		case compiler.OpFreeCell:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if err := vm.push(frame.cl.Free[idx]); err != nil {
+				return err
+			}
+
+		case compiler.OpArray:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			elements := make([]evaluator.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			vm.push(&evaluator.Array{Elements: elements})
+
+		case compiler.OpTemplate:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			parts := make([]evaluator.Object, n)
+			copy(parts, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			vm.push(evaluator.Interpolate(parts))
+
+		case compiler.OpHash:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			hash, err := vm.buildHash(vm.sp-n, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp -= n
+			vm.push(hash)
+
+		case compiler.OpStruct:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			properties := make(map[string]evaluator.Object, n/2)
+			for i := vm.sp - n; i < vm.sp; i += 2 {
+				properties[vm.stack[i].(*evaluator.String).Value] = vm.stack[i+1]
+			}
+			vm.sp -= n
+			vm.push(&evaluator.Struct{Properties: properties})
+
+		case compiler.OpEmbed:
+			name := vm.constants[compiler.ReadUint16(ins[ip+1:])].(*evaluator.String).Value
+			frame.ip += 2
+			base := vm.pop()
+			if errObj := evaluator.Embed(vm.stack[vm.sp-1].(*evaluator.Struct), base, name); errObj != nil {
+				return vm.fail(errObj)
+			}
+
+		case compiler.OpInterface:
+			template := vm.constants[compiler.ReadUint16(ins[ip+1:])].(*evaluator.Interface)
+			n := int(compiler.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+			embeds := make([]evaluator.Object, n)
+			copy(embeds, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			iface := evaluator.ExtendInterface(template, embeds)
+			if err := vm.check(iface); err != nil {
+				return err
+			}
+			vm.push(iface)
+
+		case compiler.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			result := evaluator.EvalIndex(left, index)
+			if err := vm.check(result); err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case compiler.OpSetIndex:
+			index := vm.pop()
+			container := vm.pop()
+			val := vm.pop()
+			if str, ok := container.(*evaluator.String); ok {
+				updated := evaluator.EvalStringSetIndex(str, index, val)
+				if err := vm.check(updated); err != nil {
+					return err
+				}
+				vm.push(val)
+				vm.push(updated)
+				vm.push(evaluator.TRUE)
+			} else {
+				result := evaluator.SetIndex(container, index, val)
+				if err := vm.check(result); err != nil {
+					return err
+				}
+				vm.push(val)
+				vm.push(evaluator.FALSE)
+			}
+
+		case compiler.OpGetProperty:
+			name := vm.constants[compiler.ReadUint16(ins[ip+1:])].(*evaluator.String).Value
+			arrow := compiler.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+			object := vm.pop()
+			result := evaluator.GetProperty(object, name, arrow)
+			if err := vm.check(result); err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case compiler.OpSetProperty:
+			name := vm.constants[compiler.ReadUint16(ins[ip+1:])].(*evaluator.String).Value
+			arrow := compiler.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+			object := vm.pop()
+			val := vm.pop()
+			result := evaluator.SetProperty(object, name, arrow, val)
+			if err := vm.check(result); err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case compiler.OpClosure:
+			constIdx := compiler.ReadUint16(ins[ip+1:])
+			numFree := int(compiler.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+			fn := vm.constants[constIdx].(*compiler.CompiledFunction)
+			free := make([]*cell, numFree)
+			for i := 0; i < numFree; i++ {
+				free[i] = vm.stack[vm.sp-numFree+i].(*cell)
+			}
+			vm.sp -= numFree
+			if err := vm.push(&Closure{Fn: fn, Free: free, Self: frame.cl.Self, vm: vm}); err != nil {
+				return err
+			}
+
+		case compiler.OpMatch:
+			pattern := vm.constants[compiler.ReadUint16(ins[ip+1:])].(*compiler.Pattern)
+			frame.ip += 2
+			bindings, ok := evaluator.MatchPattern(pattern.Node, vm.stack[vm.sp-1])
+			if ok {
+				for _, name := range pattern.Names {
+					if err := vm.push(bindings[name]); err != nil {
+						return err
+					}
+				}
+			}
+			if err := vm.push(evaluator.NativeBool(ok)); err != nil {
+				return err
+			}
+
+		case compiler.OpNoMatch:
+			return vm.fail(evaluator.NoMatch(vm.pop()))
+
+		case compiler.OpSelf:
+			if frame.cl.Self == nil {
+				return vm.fail(evaluator.NewError("identifier not found: self"))
+			}
+			if err := vm.push(frame.cl.Self); err != nil {
+				return err
+			}
+
+		case compiler.OpCall:
+			numArgs := int(compiler.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			if err := vm.callFunction(numArgs); err != nil {
+				return err
+			}
+
+		case compiler.OpCallSpread:
+			numArrays := int(compiler.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			arrays := make([]evaluator.Object, numArrays)
+			copy(arrays, vm.stack[vm.sp-numArrays:vm.sp])
+			vm.sp -= numArrays
+			numArgs := 0
+			for _, val := range arrays {
+				arr, ok := val.(*evaluator.Array)
+				if !ok {
+					return vm.fail(evaluator.SpreadError(val))
+				}
+				for _, el := range arr.Elements {
+					if err := vm.push(el); err != nil {
+						return err
+					}
+				}
+				numArgs += len(arr.Elements)
+			}
+			if err := vm.callFunction(numArgs); err != nil {
+				return err
+			}
+
+		case compiler.OpReturnValue:
+			returnValue := vm.pop()
+			// handlers left open by the returning frame die with it
+			for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].framesIndex >= vm.framesIndex {
+				vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			}
+			if vm.framesIndex == 1 {
+				vm.lastPopped = returnValue
+				return nil
+			}
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			vm.push(returnValue)
+			if vm.framesIndex == vm.stopAt {
+				return nil
+			}
+
+		case compiler.OpImport:
+			idx := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if mod := vm.moduleCache[idx]; mod != nil {
+				if err := vm.push(mod); err != nil {
+					return err
+				}
+				continue
+			}
+			// run the module body; its OpModule fills the cache
+			if err := vm.push(&Closure{Fn: vm.modules[idx].Fn, vm: vm}); err != nil {
+				return err
+			}
+			if err := vm.callFunction(0); err != nil {
+				return err
+			}
+
+		case compiler.OpModule:
+			idx := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			info := vm.modules[idx]
+			members := make(map[string]evaluator.Object, len(info.Exports))
+			for _, sym := range info.Exports {
+				val := vm.stack[frame.basePointer+sym.Index]
+				if c, ok := val.(*cell); ok {
+					val = c.value
+				}
+				if val != nil {
+					members[sym.Name] = val
+				}
+			}
+			mod := evaluator.NewModule(info.Name, info.Path, members)
+			vm.moduleCache[idx] = mod
+			if err := vm.push(mod); err != nil {
+				return err
+			}
+
+		case compiler.OpTry:
+			pos := int(compiler.ReadUint32(ins[ip+1:]))
+			frame.ip += 4
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, ip: pos, sp: vm.sp})
+
+		case compiler.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case compiler.OpThrow:
+			return vm.fail(evaluator.Throw(vm.pop()))
+
+		case compiler.OpPrint:
+			fmt.Println(vm.pop().Inspect())
+
+		default:
+			def, err := compiler.Lookup(byte(op))
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("opcode %s not implemented", def.Name)
+		}
+	}
+
+	return nil
+}
+
+func (vm *VM) callFunction(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *Closure:
+		fn := callee.Fn
+		if err := evaluator.ArityError(fn.NumRequired, fn.NumParameters, fn.Variadic, numArgs); err != nil {
+			return vm.fail(err)
+		}
+		if fn.Variadic {
+			// the arguments past the parameters become the rest array
+			rest := []evaluator.Object{}
+			if numArgs > fn.NumParameters {
+				rest = make([]evaluator.Object, numArgs-fn.NumParameters)
+			}
+			copy(rest, vm.stack[vm.sp-len(rest):vm.sp])
+			vm.sp -= len(rest)
+			numArgs -= len(rest)
+			// missing parameters sit between the passed ones and the rest
+			for i := vm.sp; i < vm.sp+fn.NumParameters-numArgs; i++ {
+				vm.stack[i] = nil
+			}
+			vm.sp += fn.NumParameters - numArgs
+			vm.stack[vm.sp] = &evaluator.Array{Elements: rest}
+			vm.sp++
+			numArgs = fn.NumParameters + 1
+		}
+		if vm.framesIndex >= MaxFrames {
+			return vm.fail(evaluator.NewError("stack overflow"))
+		}
+		basePointer := vm.sp - numArgs
+		if basePointer+fn.NumLocals >= StackSize {
+			return vm.fail(evaluator.NewError("stack overflow"))
+		}
+		// clear stale slots so unassigned locals read as undefined
+		for i := basePointer + numArgs; i < basePointer+fn.NumLocals; i++ {
+			vm.stack[i] = nil
+		}
+		vm.pushFrame(NewFrame(callee, basePointer))
+		vm.sp = basePointer + fn.NumLocals
+
+	case *evaluator.Function:
+		if callee.Native == nil {
+			return vm.fail(evaluator.NewError("not a function: %s", callee.Type()))
+		}
+		args := make([]evaluator.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		result := callee.Native(args)
+		if result == nil {
+			result = evaluator.NULL
+		}
+		if err := vm.check(result); err != nil {
+			return err
+		}
+		vm.sp = vm.sp - numArgs - 1
+		vm.push(result)
+
+	default:
+		return vm.fail(evaluator.NewError("not a function: %s", callee.Type()))
+	}
+	return nil
+}
+
+func executeBinaryOperation(op compiler.Opcode, left, right evaluator.Object) evaluator.Object {
+	l, lok := left.(*evaluator.Integer)
+	r, rok := right.(*evaluator.Integer)
+	if lok && rok {
+		lv, rv := l.Value, r.Value
+		switch op {
+		case compiler.OpAdd:
+			return &evaluator.Integer{Value: lv + rv}
+		case compiler.OpSub:
+			return &evaluator.Integer{Value: lv - rv}
+		case compiler.OpMul:
+			return &evaluator.Integer{Value: lv * rv}
+		case compiler.OpLess:
+			return evaluator.NativeBool(lv < rv)
+		case compiler.OpGreater:
+			return evaluator.NativeBool(lv > rv)
+		case compiler.OpLessEqual:
+			return evaluator.NativeBool(lv <= rv)
+		case compiler.OpGreaterEqual:
+			return evaluator.NativeBool(lv >= rv)
+		case compiler.OpEqual:
+			return evaluator.NativeBool(lv == rv)
+		case compiler.OpNotEqual:
+			return evaluator.NativeBool(lv != rv)
+		}
+	}
+	return evaluator.EvalInfix(operators[op], left, right)
+}
+
+var operators = map[compiler.Opcode]string{
+	compiler.OpAdd:          "+",
+	compiler.OpSub:          "-",
+	compiler.OpMul:          "*",
+	compiler.OpDiv:          "/",
+	compiler.OpMod:          "%",
+	compiler.OpShl:          "<<",
+	compiler.OpShr:          ">>",
+	compiler.OpEqual:        "==",
+	compiler.OpNotEqual:     "!=",
+	compiler.OpLess:         "<",
+	compiler.OpGreater:      ">",
+	compiler.OpLessEqual:    "<=",
+	compiler.OpGreaterEqual: ">=",
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (evaluator.Object, error) {
+	pairs := make(map[evaluator.HashKey]evaluator.HashPair)
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+		hashKey, ok := key.(evaluator.Hashable)
+		if !ok {
+			return nil, vm.fail(evaluator.NewError("unusable as hash key: %s", key.Type()))
+		}
+		pairs[hashKey.HashKey()] = evaluator.HashPair{Key: key, Value: value}
+	}
+	return &evaluator.Hash{Pairs: pairs}, nil
+}
+
+func (vm *VM) global(idx int) evaluator.Object {
+	if idx >= len(vm.globals) {
+		return nil
+	}
+	return vm.globals[idx]
+}
+
+func (vm *VM) setGlobal(idx int, val evaluator.Object) {
+	for idx >= len(vm.globals) {
+		vm.globals = append(vm.globals, nil)
+	}
+	vm.globals[idx] = val
+}
+
+// check turns an *evaluator.Error result into a runtime error.
+func (vm *VM) check(obj evaluator.Object) error {
+	if errObj, ok := obj.(*evaluator.Error); ok {
+		return vm.fail(errObj)
+	}
+	return nil
+}
+
+// fail stamps errObj with the source position of the current instruction,
+// the same way evaluator.Eval does for the node that produced it.
+func (vm *VM) fail(errObj *evaluator.Error) error {
+	if !errObj.HasLocation {
+		frame := vm.currentFrame()
+		if pos, ok := frame.cl.Fn.PositionAt(frame.ip); ok {
+			errObj.Line, errObj.Column = pos.Line, pos.Column
+			errObj.HasLocation = true
+		}
+	}
+	return &RuntimeError{Err: errObj}
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) push(o evaluator.Object) error {
+	if vm.sp >= StackSize {
+		return vm.fail(evaluator.NewError("stack overflow"))
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+func (vm *VM) pop() evaluator.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
@@ -0,0 +1,514 @@
+package vm
+
+import (
+	"Nikium/ast"
+	"Nikium/compiler"
+	"Nikium/evaluator"
+	"Nikium/lexer"
+	"Nikium/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"4 / 2 * 3 - 1", 5},
+		{"7 % 3", 1},
+		{"1 << 4 >> 2", 4},
+		{"-5 + 10", 5},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5 + 1", 2.5},
+		{"7 / 2.0", 3.5},
+		{"-0.5 * 4", -2.0},
+		{"2.5 > 2", true},
+		{"int(9.9) + 1", 10},
+		{"p = struct { f: float }; p.f", 0.0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 >= 2", false},
+		{"(1 < 2) == true", true},
+		{"!5", false},
+		{"!!true", true},
+		{"true && false", false},
+		{"false || 1 == 1", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (false) { 10 }", nil},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalsAndStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{"one = 1; two = one + one; two", 2},
+		{`s = "mon" + "key"; s`, "monkey"},
+		{`len("four")`, 4},
+		{`a = [1, 2, 3]; a[1] + len(a)`, 5},
+		{`h = {"k": 7}; h["k"]`, 7},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctionsAndClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{"f = fn(a, b) { a + b }; f(1, 2)", 3},
+		{"f = fn() { return 1; 2 }; f()", 1},
+		{`
+fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) };
+fib(15)`, 610},
+		{`
+newAdder = fn(x) { fn(y) { x + y } };
+addTwo = newAdder(2);
+addTwo(3)`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestScoping(t *testing.T) {
+	tests := []vmTestCase{
+		{"x = 1; f = fn() { x = x + 1; }; f(); f(); x", 3},
+		{"x = 1; f = fn() { let x = 10; x = x + 1; x }; f() + x", 12},
+		{"f = fn() { total = 5; }; total = 0; f(); total", 5},
+		{"x = 0; for (let i = 0; i < 4; ++i) { x = x + i; } x", 6},
+		{`
+counter = fn() {
+  let n = 0;
+  fn() { n = n + 1; n }
+};
+c = counter();
+c(); c();
+c()`, 3},
+		{`
+make = fn() {
+  let n = 0;
+  inc = fn() { ++n; };
+  get = fn() { n };
+  inc(); inc();
+  get()
+};
+make()`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"i = 0; while (i < 10) { i = i + 1; } i", 10},
+		{"i = 0; while (true) { i = i + 1; if (i == 3) { break; } } i", 3},
+		{`
+i = 0; odd = 0;
+while (i < 10) {
+  i = i + 1;
+  if (i % 2 == 0) { continue; }
+  odd = odd + 1;
+}
+odd`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{`a = [1, 2, 3]; a[0] = 10; a[0] + a[2]`, 13},
+		{`m = [[1, 2], [3, 4]]; m[1][0] = 30; m[1][0]`, 30},
+		{`h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`a = [0]; a[0] = 4`, 4},
+		{`s = "cat"; s[0] = "b"; s`, "bat"},
+		{`w = ["dog"]; w[0][2] = "t"; w[0]`, "dot"},
+		{`f = fn(s) { s[1] = "u"; s }; f("cat")`, "cut"},
+		{`P = struct { items: [1, 2] }; P.items[1] = 9; P.items[1]`, 9},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`try { 1 + true; x = 1; } catch (e) { x = e.kind; } x`, "RuntimeError"},
+		{`k = ""; try { int_parse("abc"); } catch (e) { k = e.kind; } k`, "ParseError"},
+		{`v = 0; try { throw 41; } catch (e) { v = e.value + 1; } v`, 42},
+		{`m = ""; try { throw error("ValueError", "bad"); } catch (e) { m = e.message; } m`, "bad"},
+		{`f = fn(n) { if (n < 0) { throw "neg"; } n }; m = ""; try { f(-1); } catch (e) { m = e.message; } m`, "neg"},
+		{`x = 0; try { throw "a"; } catch (e) { x = 1; } finally { x = x + 10; } x`, 11},
+		{`f = fn() { try { return 1; } finally { return 2; } }; f()`, 2},
+		{`log = ""; f = fn() { try { return 1; } finally { log = "done"; } }; f(); log`, "done"},
+		{`m = ""; try { try { throw "in"; } finally { 0; } } catch (e) { m = e.message; } m`, "in"},
+		{`m = ""; try { try { throw "in"; } catch (e) { throw e; } } catch (e) { m = e.message; } m`, "in"},
+		{`i = 0; n = 0; while (true) { i = i + 1; try { if (i == 3) { break; } } finally { n = n + 1; } } n`, 3},
+		{`f = fn() { try { return 5; } catch (e) { 0; } }; m = ""; try { f(); throw "after"; } catch (e) { m = e.message; } m`, "after"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestImports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"counter.nik": `
+import "helper";
+let _hidden = 1;
+count = 0;
+bump = fn() { count = count + 1; count };
+twice = fn(x) { helper.double(x) };
+for (let i = 0; i < 2; ++i) { count = count + 0; }
+`,
+		"helper.nik": `double = fn(x) { x * 2 };`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []vmTestCase{
+		{`import "counter" as c; c.twice(21)`, 42},
+		{`import "counter"; counter.bump()`, 1},
+		{`import "counter" as a; f = fn() { import "counter.nik" as b; b.bump() }; a.bump(); f()`, 2},
+		{`import "counter" as c; x = 1; c.count + x`, 1},
+		{`import "stdlib/linkedlist" as ll; l = ll.LinkedList_push(ll.LinkedList(), 4); l.size`, 1},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetDir(dir)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.input, tt.expected, machine.LastPoppedStackElem())
+	}
+
+	comp := compiler.New()
+	comp.SetDir(dir)
+	comp.Compile(parse(`import "counter" as c; c._hidden`))
+	machine := New(comp.Bytecode())
+	err := machine.Run()
+	if err == nil || err.Error() != "ImportError: _hidden is not exported by module counter on line 1, col 25" {
+		t.Errorf("expected an export error. got=%v", err)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	counter := `
+Counter = struct {
+  n: 0,
+  add: fn(k) { self.n = self.n + k; return self; },
+  get: fn() { return this.n; },
+  adder: fn() { return fn(k) { self.n = self.n + k; }; }
+};
+`
+	tests := []vmTestCase{
+		{counter + `c = Counter; c.add(2); c.add(3); c.get()`, 5},
+		{counter + `c = Counter; c.add(1).add(2).get()`, 3},
+		{counter + `c = Counter; f = c.adder(); f(4); c.n`, 4},
+		{counter + `c = Counter; g = c.get; c.n = 7; g()`, 7},
+		{counter + `c = Counter; d = struct { n: 100, get: c.get }; d.get()`, 100},
+		{`self = 5; f = fn() { self }; f()`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMatch(t *testing.T) {
+	describe := `
+describe = fn(v) {
+  match v {
+    0 => "zero",
+    "hi" => "greeting",
+    [x] => "one " + str(x),
+    [first, ...rest] => str(first) + " then " + str(rest),
+    {"kind": "circle", "r": r} => "circle " + str(r),
+    { name } => "named " + name,
+    n if type(n) == "INTEGER" && n > 100 => "big",
+    _ => { let t = type(v); "other " + t }
+  }
+};
+`
+	tests := []vmTestCase{
+		{describe + `describe(0)`, "zero"},
+		{describe + `describe("hi")`, "greeting"},
+		{describe + `describe([7])`, "one 7"},
+		{describe + `describe([1, 2, 3])`, "1 then [2, 3]"},
+		{describe + `describe({"kind": "circle", "r": 2})`, "circle 2"},
+		{describe + `describe(struct { name: "box" })`, "named box"},
+		{describe + `describe(500)`, "big"},
+		{describe + `describe(5)`, "other INTEGER"},
+		{`x = "outer"; match 1 { x => x }; x`, "outer"},
+		{`f = fn() { match [1] { [a] => { return "early"; } }; return "late"; }; f()`, "early"},
+		{`t = 0; for (let i = 0; i < 4; ++i) { match i { 1 => { continue; }, n => t = t + n } } t`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestInterfacesAndEmbedding(t *testing.T) {
+	shapes := `
+Sized = interface { length() };
+Sequence = interface { ...Sized, at(i) };
+Base = struct { name: "base", describe: fn() { return "I am " + self.name; } };
+Box = struct {
+  ...Base,
+  items: [1, 2],
+  length: fn() { return len(self.items); },
+  at: fn(i) { return self.items[i]; }
+};
+`
+	tests := []vmTestCase{
+		{shapes + `Box.describe()`, "I am base"},
+		{shapes + `Box.name = "box"; Box.describe()`, "I am box"},
+		{shapes + `implements(Box, Sequence)`, true},
+		{shapes + `implements(Base, Sized)`, false},
+		{shapes + `implements(struct { length: fn(n) {} }, Sized)`, false},
+		{shapes + `implements("abc", Sized)`, false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestEnums(t *testing.T) {
+	decls := `
+enum Color { Red, Green, Blue }
+enum Result { Ok(value), Err(msg) }
+describe = fn(r) {
+  match r {
+    Result.Ok(v) if v > 10 => "big " + str(v),
+    Result.Ok(v) => "ok " + str(v),
+    Result.Err(m) => "failed: " + m
+  }
+};
+`
+	tests := []vmTestCase{
+		{decls + `Color.Red == Color.Red`, true},
+		{decls + `Color.Red != Color.Blue`, true},
+		{decls + `Result.Ok(1) == Result.Ok(1)`, true},
+		{decls + `Result.Ok(1) == Result.Ok(2)`, false},
+		{decls + `Result.Ok(1).value`, 1},
+		{decls + `h = {Color.Green: 1, Result.Err("x"): 2}; h[Color.Green] + h[Result.Err("x")]`, 3},
+		{decls + `describe(Result.Ok(42))`, "big 42"},
+		{decls + `describe(Result.Ok(3))`, "ok 3"},
+		{decls + `describe(Result.Err("nope"))`, "failed: nope"},
+		{decls + `c = Color.Blue; if (c == Color.Blue) { "blue" } else { "other" }`, "blue"},
+		{decls + `match Color.Green { Color.Red => 1, Color.Green => 2, Color.Blue => 3 }`, 2},
+		{decls + `f = fn() { enum Dir { Up, Down } Dir.Down }; str(f())`, "Dir.Down"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForIn(t *testing.T) {
+	tests := []vmTestCase{
+		{`t = 0; for (x in [1, 2, 3]) { t = t + x; } t`, 6},
+		{`t = 0; for (i, x in [5, 6]) { t = t + i * x; } t`, 6},
+		{`t = 0; for (k, v in {"a": 1, "b": 2}) { t = t + v; } t`, 3},
+		{`s = ""; for (k in {"a": 1}) { s = s + k; } s`, "a"},
+		{`s = ""; for (c in "abc") { s = c + s; } s`, "cba"},
+		{`t = 0; for (i in range(5)) { t = t + i; } t`, 10},
+		{`t = 0; for (i in range(10, 0, -3)) { t = t + i; } t`, 22},
+		{`t = 0; for (i in range(1000000000)) { if (i == 3) { break; } t = t + i; } t`, 3},
+		{`t = 0; for (i in range(6)) { if (i % 2 == 0) { continue; } t = t + i; } t`, 9},
+		{`xs = [1, 2]; for (x in xs) { xs[0] = 10; xs = push(xs, x); } len(xs)`, 4},
+		{`x = 7; for (x in [1]) { } x`, 7},
+		{`f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()`, 20},
+		{`t = 0; for (a in [1, 2]) { for (b in [10, 20]) { t = t + a * b; } } t`, 90},
+		{`fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }); } fs[0]() + fs[1]()`, 4},
+		{`t = 0; for (x in [1, 2, 3]) { try { if (x == 2) { continue; } t = t + x; } catch (e) { } } t`, 4},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTemplateStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`name = "Ada"; age = 36; "Hello ${name}, you are ${age + 1}"`, "Hello Ada, you are 37"},
+		{`f = fn(x) { "<${x}>" }; "${f([1])}${f(2.5)}"`, "<[1]><2.5>"},
+		{`"outer ${"inner ${1 + 1}"}"`, "outer inner 2"},
+		{`format("[%-4d|%04x|%.1v]", 7, 255, 2.25)`, "[7   |00ff|2.2]"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestChannels(t *testing.T) {
+	tests := []vmTestCase{
+		{`ch = channel(2); send(ch, 1); send(ch, 2); recv(ch) * 10 + recv(ch)`, 12},
+		{`ch = channel(3); send(ch, 5); send(ch, 6); close(ch); t = 0; for (v in ch) { t = t + v; } t`, 11},
+		{`ch = channel(1); close(ch); t = 0; for (v in ch) { t = t + 1; } t`, 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSyncPrimitives(t *testing.T) {
+	tests := []vmTestCase{
+		{`m = mutex(); n = 0; with_lock(m, fn() { n = n + 1; }); m.lock(); m.unlock(); n`, 1},
+		{`a = atomic(1); a.add(2); a.cas(3, 10); a.get()`, 10},
+		{`wg = waitgroup(); wg.add(1); wg.done(); wg.wait(); 1`, 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIteratorProtocol(t *testing.T) {
+	counter := `counter = fn(n) { let i = 0; return struct { next: fn() { if (i >= n) { return; } i = i + 1; return i; } }; }; `
+	tests := []vmTestCase{
+		{counter + `t = 0; for (x in counter(4)) { t = t + x; } t`, 10},
+		{counter + `c = counter(3); next(c); next(c)`, 2},
+		{counter + `t = 0; for (i, x in counter(3)) { if (i == 1) { continue; } t = t + x; } t`, 4},
+		{`S = struct { k: 0, next: fn() { if (self.k >= 3) { return; } self.k = self.k + 1; return self.k; } }; t = 0; for (x in S) { t = t + x; } t`, 6},
+		{`it = struct { next: fn() { try { throw "inner"; } catch (e) { return 1; } } }; next(it)`, 1},
+		{`it = struct { next: fn() { throw "bad"; } }; t = 0; try { for (x in it) { } } catch (e) { t = 1; } t`, 1},
+		{`it = struct { next: fn() { f = fn(n) { if (n == 0) { return 7; } f(n - 1) }; f(3) } }; next(it) + next(it)`, 14},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctionArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{`greet = fn(name, greeting = "hi") { greeting + " " + name }; greet("bo")`, "hi bo"},
+		{`greet = fn(name, greeting = "hi") { greeting + " " + name }; greet("bo", "yo")`, "yo bo"},
+		{`f = fn(a, b = a * 2) { a + b }; f(3)`, 9},
+		{`f = fn(a, b = 1) { g = fn() { b }; g() }; f(1) + f(1, 5)`, 6},
+		{`sum = fn(...xs) { t = 0; for (let i = 0; i < len(xs); ++i) { t = t + xs[i]; } t }; sum(1, 2, 3)`, 6},
+		{`f = fn(a, ...rest) { len(rest) }; f(1)`, 0},
+		{`f = fn(a, b = 2, ...rest) { a + b + len(rest) }; f(1)`, 3},
+		{`f = fn(a, b = 2, ...rest) { a + b + len(rest) }; f(1, 1, 1, 1)`, 4},
+		{`f = fn(a, b, c) { a * 100 + b * 10 + c }; xs = [2, 3]; f(1, ...xs)`, 123},
+		{`f = fn(...xs) { len(xs) }; f(...[1, 2], 3, ...[])`, 3},
+		{`f = fn(a, b) { a - b }; f(...[5, 2])`, 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "Error: type mismatch: INTEGER + BOOLEAN on line 1, col 3"},
+		{"\nfoobar", "Error: identifier not found: foobar on line 2, col 1"},
+		{"a = [1];\na[1] = 2;", "Error: array index out of range: 1 (len 1) on line 2, col 6"},
+		{"f = fn(a) { a }; f(1, 2);", "ArgumentError: wrong number of arguments: want=1, got=2 on line 1, col 19"},
+		{"f = fn(a, b = 1) { a };\nf();", "ArgumentError: wrong number of arguments: want=1 to 2, got=0 on line 2, col 2"},
+		{"f = fn(a) { a };\nf(...5);", "TypeError: cannot spread INTEGER; expected an array on line 2, col 2"},
+		{"for (x in 5) { }", "TypeError: cannot iterate over INTEGER on line 1, col 1"},
+		{"X = struct { ...5 };", "TypeError: cannot embed INTEGER on line 1, col 5"},
+		{"x = 2;\nmatch x { 1 => 1 };", "ValueError: no match arm for 2 on line 2, col 1"},
+		{"enum R { Ok(v) }\nR.Ok();", "ArgumentError: R.Ok expects 1 argument, got 0 on line 2, col 5"},
+		{"throw error(\"IOError\", \"disk gone\");", "IOError: disk gone on line 1, col 1"},
+		{"f = fn() { self };\nf();", "Error: identifier not found: self on line 1, col 12"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine := New(comp.Bytecode())
+		err := machine.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine := New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.input, tt.expected, machine.LastPoppedStackElem())
+	}
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual evaluator.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		integer, ok := actual.(*evaluator.Integer)
+		if !ok || integer.Value != int64(expected) {
+			t.Errorf("%q: object is not Integer %d. got=%T (%+v)", input, expected, actual, actual)
+		}
+	case float64:
+		float, ok := actual.(*evaluator.Float)
+		if !ok || float.Value != expected {
+			t.Errorf("%q: object is not Float %g. got=%T (%+v)", input, expected, actual, actual)
+		}
+	case bool:
+		boolean, ok := actual.(*evaluator.Boolean)
+		if !ok || boolean.Value != expected {
+			t.Errorf("%q: object is not Boolean %t. got=%T (%+v)", input, expected, actual, actual)
+		}
+	case string:
+		str, ok := actual.(*evaluator.String)
+		if !ok || str.Value != expected {
+			t.Errorf("%q: object is not String %q. got=%T (%+v)", input, expected, actual, actual)
+		}
+	case nil:
+		if actual != evaluator.NULL {
+			t.Errorf("%q: object is not NULL. got=%T (%+v)", input, actual, actual)
+		}
+	}
+}